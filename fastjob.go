@@ -33,6 +33,12 @@ func UseTreeWithOptions(name string, urlPath string, acl int, options *model.Tre
 	treeCallHandler := tree.TreeCallHandler{Root: treeRoot}
 
 	Router.WebsocketWithOptions(urlPath, treeCallHandler.Handler, acl,options.WebsocketOptions)
+	if options.HTTPPath != "" {
+		treeRoot.MountHTTP(Router, options.HTTPPath, acl)
+	}
+	if options.SSEPath != "" {
+		treeRoot.MountSSE(Router, options.SSEPath, acl)
+	}
 
 	return treeRoot
 }
@@ -42,6 +48,11 @@ func UseTree(name string, urlPath string, acl int) *TreeRoot {
 func AddSystemBranches(treeRoot *TreeRoot) {
 	treeRoot.AddBranch(&tree.ChatBranch{})
 	treeRoot.AddBranch(&tree.ExecBranch{})
+	treeRoot.AddBranch(&tree.ScheduleBranch{})
+	treeRoot.AddBranch(&tree.DeadLetterBranch{})
+	treeRoot.AddBranch(&tree.WorkflowBranch{})
+	treeRoot.AddBranch(&tree.PushBranch{})
+	treeRoot.AddBranch(&tree.SessionsBranch{})
 
 	// 2019-11-12T13:31:02+00:00
 	// PythonBranch has moved to fastjob-python
@@ -101,9 +112,11 @@ func UseAuthentication(userManager model.AuthProvider) {
 // 拉到objsh來，這樣使用objsh的專案
 // 不需要import其他像是 tree, model之類的
 type BaseBranch = model.BaseBranch
+type GroupBranch = model.GroupBranch
 type TreeRoot = model.TreeRoot
 type TreeCallCtx = model.TreeCallCtx
 type Exportable = model.Exportable
+type Middleware = model.Middleware
 type BaseAuthProvider = model.BaseAuthProvider
 type Dict = model.Dict
 type User = model.User
@@ -12,4 +12,5 @@ require (
 	github.com/mattn/go-pointer v0.0.0-20190911064623-a0a44394634f
 	github.com/syndtr/goleveldb v1.0.0
 	github.com/valyala/fasthttp v1.12.0
+	google.golang.org/grpc v1.18.0
 )
@@ -0,0 +1,259 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Checkpoint is the saved state of a resumable call, see TreeCallCtx.Checkpoint()
+type Checkpoint struct {
+	ID       string            `json:"id"`
+	Path     string            `json:"path"`
+	Username string            `json:"username"`
+	Args     []string          `json:"args,omitempty"`
+	Kw       map[string]string `json:"kw,omitempty"`
+	// opaque state of the exportable in JSON, null before the first checkpoint
+	State json.RawMessage `json:"state"`
+	// times of resuming after restarts
+	Resumed int   `json:"resumed"`
+	Ctime   int64 `json:"ctime"`
+	Mtime   int64 `json:"mtime"`
+}
+
+// keys in storage
+const (
+	checkpointIndexKey = "checkpoint\tindex"
+	checkpointKey      = "checkpoint\t"
+)
+
+// checkpoints keeps resumable calls in TreeRoot.Storage
+type checkpoints struct {
+	root *TreeRoot
+	// ids of saved checkpoints
+	ids     map[string]bool
+	loaded  bool
+	resumed sync.Once
+	mutex   sync.Mutex
+}
+
+// load reads the index from storage, caller should hold cp.mutex
+func (cp *checkpoints) load() {
+	if cp.loaded {
+		return
+	}
+	cp.loaded = true
+	cp.ids = make(map[string]bool)
+	if data, err := cp.root.Storage.GetString(checkpointIndexKey); err == nil {
+		var ids []string
+		if err := json.Unmarshal(data, &ids); err != nil {
+			log.Println("checkpoint: bad index in storage,", err)
+		}
+		for _, id := range ids {
+			cp.ids[id] = true
+		}
+	}
+}
+
+// saveIndex writes the index to storage, caller should hold cp.mutex
+func (cp *checkpoints) saveIndex() error {
+	ids := make([]string, 0, len(cp.ids))
+	for id := range cp.ids {
+		ids = append(ids, id)
+	}
+	data, err := json.Marshal(ids)
+	if err != nil {
+		return err
+	}
+	return cp.root.Storage.SetString(checkpointIndexKey, data)
+}
+
+func (cp *checkpoints) save(checkpoint *Checkpoint) error {
+	data, err := json.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+	cp.mutex.Lock()
+	defer cp.mutex.Unlock()
+	cp.load()
+	if err := cp.root.Storage.SetString(checkpointKey+checkpoint.ID, data); err != nil {
+		return err
+	}
+	if !cp.ids[checkpoint.ID] {
+		cp.ids[checkpoint.ID] = true
+		return cp.saveIndex()
+	}
+	return nil
+}
+
+func (cp *checkpoints) remove(id string) {
+	cp.mutex.Lock()
+	defer cp.mutex.Unlock()
+	cp.load()
+	cp.root.Storage.DelString(checkpointKey + id)
+	if cp.ids[id] {
+		delete(cp.ids, id)
+		if err := cp.saveIndex(); err != nil {
+			log.Println("checkpoint: failed to save index,", err)
+		}
+	}
+}
+
+// list returns all saved checkpoints
+func (cp *checkpoints) list() []*Checkpoint {
+	cp.mutex.Lock()
+	defer cp.mutex.Unlock()
+	cp.load()
+	ret := make([]*Checkpoint, 0, len(cp.ids))
+	for id := range cp.ids {
+		data, err := cp.root.Storage.GetString(checkpointKey + id)
+		if err != nil {
+			continue
+		}
+		checkpoint := &Checkpoint{}
+		if err := json.Unmarshal(data, checkpoint); err != nil {
+			log.Println("checkpoint: bad checkpoint in storage,", id, err)
+			continue
+		}
+		ret = append(ret, checkpoint)
+	}
+	return ret
+}
+
+// Resumable makes this call be called again with its last checkpoint when the server is restarted
+// before it is finished (see Checkpoint() and TreeRoot.ResumeJobs). It requires TreeRoot.SetStorage().
+func (tcCtx *TreeCallCtx) Resumable() error {
+	if tcCtx.Root == nil || tcCtx.Root.Storage == nil {
+		return errors.New("no storage for checkpoints")
+	}
+	tcCtx.SetBackground(true)
+	tcCtx.killable()
+	tcCtx.mutex.Lock()
+	if tcCtx.checkpoint != nil {
+		// already resumable, or resumed by ResumeJobs
+		tcCtx.mutex.Unlock()
+		return nil
+	}
+	now := time.Now()
+	checkpoint := &Checkpoint{
+		ID:    strconv.FormatInt(now.UnixNano(), 36),
+		Path:  tcCtx.NodePath,
+		Args:  tcCtx.Args,
+		Kw:    kwOf(tcCtx),
+		Ctime: now.Unix(),
+		Mtime: now.Unix(),
+	}
+	if parts := strings.SplitN(tcCtx.CmdPath, "\t", 2); len(parts) == 2 {
+		checkpoint.Username = parts[1]
+	}
+	tcCtx.checkpoint = checkpoint
+	tcCtx.mutex.Unlock()
+	tcCtx.forgetCheckpointOnFinish()
+	return tcCtx.Root.checkpoints.save(checkpoint)
+}
+
+// forgetCheckpointOnFinish removes the checkpoint when this call is resolved, rejected or killed
+func (tcCtx *TreeCallCtx) forgetCheckpointOnFinish() {
+	tcCtx.Observe(func(ret *TreeCallReturn) {
+		if !tcCtx.IsFinished() {
+			return
+		}
+		tcCtx.mutex.RLock()
+		checkpoint := tcCtx.checkpoint
+		tcCtx.mutex.RUnlock()
+		tcCtx.Root.checkpoints.remove(checkpoint.ID)
+	})
+}
+
+// Checkpoint saves state of this call which is marshalled in JSON, the call turns to be resumable.
+// When this call is resumed after restart, the state can be got by LastCheckpoint().
+//
+//	func (self *DataBranch) Import(ctx *TreeCallCtx) {
+//		var offset int
+//		ctx.LastCheckpoint(&offset)
+//		for ; offset < len(rows); offset++ {
+//			...
+//			if offset%100 == 0 {
+//				ctx.Checkpoint(offset)
+//			}
+//		}
+//		ctx.Resolve(len(rows))
+//	}
+func (tcCtx *TreeCallCtx) Checkpoint(state interface{}) error {
+	if err := tcCtx.Resumable(); err != nil {
+		return err
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	tcCtx.mutex.Lock()
+	checkpoint := *tcCtx.checkpoint
+	checkpoint.State = data
+	checkpoint.Mtime = time.Now().Unix()
+	tcCtx.checkpoint = &checkpoint
+	tcCtx.mutex.Unlock()
+	if tcCtx.IsFinished() {
+		return errors.New("call has been finished")
+	}
+	return tcCtx.Root.checkpoints.save(&checkpoint)
+}
+
+// LastCheckpoint unmarshals the last state saved by Checkpoint() into v,
+// returns false if there is no checkpoint (ex. the call is not resumed from a restart).
+func (tcCtx *TreeCallCtx) LastCheckpoint(v interface{}) (bool, error) {
+	tcCtx.mutex.RLock()
+	checkpoint := tcCtx.checkpoint
+	tcCtx.mutex.RUnlock()
+	if checkpoint == nil || len(checkpoint.State) == 0 || string(checkpoint.State) == "null" {
+		return false, nil
+	}
+	return true, json.Unmarshal(checkpoint.State, v)
+}
+
+// ResumeJobs calls resumable calls which were not finished before restart again as their users,
+// with their last checkpoints. It is done once after the tree is ready and the storage is set.
+// A call which has been resumed MaxResumes times (ex. it crashes the server) is not resumed again,
+// its checkpoint is moved to the dead-letter list of Root.Retry.
+// Returns the number of resumed calls.
+func (self *TreeRoot) ResumeJobs() int {
+	if self.Storage == nil {
+		return 0
+	}
+	count := 0
+	self.checkpoints.resumed.Do(func() {
+		for _, checkpoint := range self.checkpoints.list() {
+			var user User
+			if checkpoint.Username != "" {
+				if user = lookupUser(checkpoint.Username); user == nil {
+					log.Println("checkpoint: user not found,", checkpoint.Username, checkpoint.Path)
+					self.checkpoints.remove(checkpoint.ID)
+					continue
+				}
+			}
+			if self.MaxResumes > 0 && checkpoint.Resumed >= self.MaxResumes {
+				log.Println("checkpoint: give up", checkpoint.Path, checkpoint.ID, "after resumed", checkpoint.Resumed, "times")
+				Metrics.Incr("checkpoint.exhausted")
+				self.Retry.buryCheckpoint(checkpoint)
+				self.checkpoints.remove(checkpoint.ID)
+				continue
+			}
+			checkpoint.Resumed++
+			if err := self.checkpoints.save(checkpoint); err != nil {
+				log.Println("checkpoint: failed to save", checkpoint.ID, err)
+			}
+			ctx := self.newInternalCallCtx(user, checkpoint.Args, checkpoint.Kw, nil)
+			ctx.checkpoint = checkpoint
+			ctx.forgetCheckpointOnFinish()
+			Metrics.Incr("checkpoint.resumed")
+			log.Println("checkpoint: resume", checkpoint.Path, checkpoint.ID)
+			go self.Call(checkpoint.Path, ctx)
+			count++
+		}
+	})
+	return count
+}
@@ -0,0 +1,71 @@
+package model
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+// memDict is a Dict in memory
+type memDict struct {
+	items map[string][]byte
+	mutex sync.Mutex
+}
+
+func newMemDict() *memDict {
+	return &memDict{items: make(map[string][]byte)}
+}
+func (d *memDict) Get(key []byte) ([]byte, error) {
+	return d.GetString(string(key))
+}
+func (d *memDict) GetString(key string) ([]byte, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if value, ok := d.items[key]; ok {
+		return value, nil
+	}
+	return nil, errors.New("not found")
+}
+func (d *memDict) Set(key []byte, value []byte) error {
+	return d.SetString(string(key), value)
+}
+func (d *memDict) SetString(key string, value []byte) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.items[key] = value
+	return nil
+}
+func (d *memDict) Del(key []byte) error {
+	return d.DelString(string(key))
+}
+func (d *memDict) DelString(key string) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	delete(d.items, key)
+	return nil
+}
+
+func TestResumeJobsGivesUpAfterMaxResumes(t *testing.T) {
+	root := newTestRoot()
+	root.Storage = newMemDict()
+	root.MaxResumes = 2
+	for _, checkpoint := range []*Checkpoint{
+		{ID: "fresh", Path: "Tree.test.Echo", Args: []string{"a"}},
+		{ID: "again", Path: "Tree.test.Echo", Resumed: 1},
+		{ID: "crashy", Path: "Tree.test.Wait", Args: []string{"b"}, Resumed: 2},
+	} {
+		if err := root.checkpoints.save(checkpoint); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n := root.ResumeJobs(); n != 2 {
+		t.Fatalf("expect 2 calls resumed, got %d", n)
+	}
+	letters := root.Retry.DeadLetters()
+	if len(letters) != 1 || letters[0].Path != "Tree.test.Wait" || letters[0].Attempts != 3 || letters[0].Args[0] != "b" {
+		t.Fatalf("expect the exhausted call in dead letters, got %+v", letters)
+	}
+	// resumed calls are finished and forget their checkpoints, the exhausted one is removed
+	waitFor(t, "checkpoints removed", func() bool { return len(root.checkpoints.list()) == 0 })
+}
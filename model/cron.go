@@ -0,0 +1,491 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CronSpec is a parsed cron expression of 5 fields: minute hour day-of-month month day-of-week.
+// A field could be "*", "5", "1-5", "*/15", "1-30/2" or a list of them, ex. "0,30".
+// Shortcuts @yearly, @monthly, @weekly, @daily and @hourly are supported too.
+type CronSpec struct {
+	minute, hour, dom, month, dow uint64 // bitmasks
+	// "*" for day-of-month or day-of-week, see Match()
+	domAny, dowAny bool
+}
+
+var cronShortcuts = map[string]string{
+	"@yearly":  "0 0 1 1 *",
+	"@monthly": "0 0 1 * *",
+	"@weekly":  "0 0 * * 0",
+	"@daily":   "0 0 * * *",
+	"@hourly":  "0 * * * *",
+}
+
+// ParseCron parses a cron expression, ex. "30 8 * * 1-5" for 08:30 on weekdays
+func ParseCron(expr string) (*CronSpec, error) {
+	expr = strings.TrimSpace(expr)
+	if shortcut, ok := cronShortcuts[expr]; ok {
+		expr = shortcut
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, errors.New("cron expression should have 5 fields: " + expr)
+	}
+	spec := &CronSpec{domAny: fields[2] == "*", dowAny: fields[4] == "*"}
+	var err error
+	if spec.minute, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, err
+	}
+	if spec.hour, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, err
+	}
+	if spec.dom, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, err
+	}
+	if spec.month, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, err
+	}
+	if spec.dow, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, err
+	}
+	// 7 is Sunday too
+	if spec.dow&(1<<7) != 0 {
+		spec.dow |= 1
+	}
+	return spec, nil
+}
+
+func parseCronField(field string, min int, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n < 1 {
+				return 0, errors.New("bad step in cron field: " + field)
+			}
+			step = n
+			part = part[:i]
+		}
+		from, to := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			n, err := strconv.Atoi(bounds[0])
+			if err != nil {
+				return 0, errors.New("bad value in cron field: " + field)
+			}
+			from, to = n, n
+			if len(bounds) == 2 {
+				if to, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, errors.New("bad range in cron field: " + field)
+				}
+			} else if step > 1 {
+				// "5/15" means from 5 to max every 15
+				to = max
+			}
+		}
+		if from < min || to > max || from > to {
+			return 0, fmt.Errorf("cron field %s out of range %d-%d", field, min, max)
+		}
+		for i := from; i <= to; i += step {
+			bits |= 1 << uint(i)
+		}
+	}
+	return bits, nil
+}
+
+// Match tells if t (in minute precision) is on the schedule
+func (spec *CronSpec) Match(t time.Time) bool {
+	if spec.minute&(1<<uint(t.Minute())) == 0 || spec.hour&(1<<uint(t.Hour())) == 0 {
+		return false
+	}
+	return spec.monthMatch(t) && spec.dayMatch(t)
+}
+
+func (spec *CronSpec) monthMatch(t time.Time) bool {
+	return spec.month&(1<<uint(t.Month())) != 0
+}
+
+// like crontab, a day matches either day-of-month or day-of-week if both of them are restricted
+func (spec *CronSpec) dayMatch(t time.Time) bool {
+	domMatch := spec.dom&(1<<uint(t.Day())) != 0
+	dowMatch := spec.dow&(1<<uint(t.Weekday())) != 0
+	if spec.domAny || spec.dowAny {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+// Next returns the first time after t which is on the schedule, zero time if there is none in 5 years
+func (spec *CronSpec) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case !spec.monthMatch(t):
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !spec.dayMatch(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case spec.hour&(1<<uint(t.Hour())) == 0:
+			t = t.Truncate(time.Hour).Add(time.Hour)
+		case spec.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// CronEntry is a tree API call which is run on schedule
+type CronEntry struct {
+	ID   string `json:"id"`
+	Spec string `json:"spec"`
+	// full path of the API, ex. "Tree.reports.Daily"
+	Path string            `json:"path"`
+	Args []string          `json:"args,omitempty"`
+	Kw   map[string]string `json:"kw,omitempty"`
+	// the call is run as this user, "" for guest
+	Username string `json:"username"`
+	Paused   bool   `json:"paused"`
+	Ctime    int64  `json:"ctime"`
+	LastRun  int64  `json:"lastRun"`
+	// computed when listing, not stored
+	NextRun int64 `json:"nextRun"`
+	spec    *CronSpec
+}
+
+// CronRun is a record of one run of a CronEntry
+type CronRun struct {
+	EntryID string      `json:"id"`
+	CmdID   int32       `json:"cmdID"`
+	Start   int64       `json:"start"`
+	End     int64       `json:"end"`
+	Retcode int32       `json:"retcode"`
+	Stdout  interface{} `json:"stdout,omitempty"`
+	Stderr  string      `json:"stderr,omitempty"`
+	// run by RunNow()
+	Manual bool `json:"manual,omitempty"`
+}
+
+// Cron runs tree API calls on cron expressions, see TreeRoot.Cron.
+// Entries and their history survive restarts if a storage is set by TreeRoot.SetStorage().
+//
+//	entry, err := Root.Cron.Add(&model.CronEntry{Spec: "0 6 * * *", Path: "Tree.reports.Daily", Username: "admin"})
+type Cron struct {
+	root    *TreeRoot
+	entries map[string]*CronEntry
+	// latest runs of every entry, latest first
+	history map[string][]*CronRun
+	storage Dict
+	// max number of runs kept in history of an entry
+	HistorySize int
+	// finds the user to run a call as, default to find by AuthProvierSingleton
+	UserLookup func(username string) User
+	started    bool
+	mutex      sync.RWMutex
+}
+
+// keys in storage
+const (
+	cronIndexKey   = "cron\tindex"
+	cronEntryKey   = "cron\tentry\t"
+	cronHistoryKey = "cron\thistory\t"
+)
+
+func NewCron(root *TreeRoot) *Cron {
+	return &Cron{
+		root:        root,
+		entries:     make(map[string]*CronEntry),
+		history:     make(map[string][]*CronRun),
+		HistorySize: 20,
+		UserLookup:  lookupUser,
+	}
+}
+
+// lookupUser finds user by the AccountProvider of AuthProvierSingleton
+func lookupUser(username string) User {
+	if provider, ok := AuthProvierSingleton.(PersitentAccountProvider); ok {
+		return provider.GetUser(username)
+	}
+	return nil
+}
+
+// SetStorage loads entries and history from storage, and saves changes to it afterward.
+func (c *Cron) SetStorage(storage Dict) {
+	c.mutex.Lock()
+	c.storage = storage
+	if data, err := storage.GetString(cronIndexKey); err == nil {
+		var ids []string
+		if err := json.Unmarshal(data, &ids); err != nil {
+			log.Println("cron: bad index in storage,", err)
+		}
+		for _, id := range ids {
+			data, err := storage.GetString(cronEntryKey + id)
+			if err != nil {
+				continue
+			}
+			entry := &CronEntry{}
+			if err := json.Unmarshal(data, entry); err != nil {
+				log.Println("cron: bad entry in storage,", id, err)
+				continue
+			}
+			if entry.spec, err = ParseCron(entry.Spec); err != nil {
+				log.Println("cron: bad spec in storage,", id, err)
+				continue
+			}
+			c.entries[id] = entry
+			if data, err := storage.GetString(cronHistoryKey + id); err == nil {
+				var runs []*CronRun
+				if json.Unmarshal(data, &runs) == nil {
+					c.history[id] = runs
+				}
+			}
+		}
+		log.Println("cron:", len(c.entries), "entries loaded")
+	}
+	hasEntries := len(c.entries) > 0
+	c.mutex.Unlock()
+	if hasEntries {
+		c.start()
+	}
+}
+
+// save writes entry (nil to delete entry of id) and the index to storage, caller should hold c.mutex
+func (c *Cron) save(id string, entry *CronEntry) {
+	if c.storage == nil {
+		return
+	}
+	var err error
+	if entry == nil {
+		c.storage.DelString(cronEntryKey + id)
+		c.storage.DelString(cronHistoryKey + id)
+	} else if data, jsonErr := json.Marshal(entry); jsonErr == nil {
+		err = c.storage.SetString(cronEntryKey+id, data)
+	} else {
+		err = jsonErr
+	}
+	ids := make([]string, 0, len(c.entries))
+	for id := range c.entries {
+		ids = append(ids, id)
+	}
+	if data, jsonErr := json.Marshal(ids); jsonErr == nil {
+		if err2 := c.storage.SetString(cronIndexKey, data); err2 != nil {
+			err = err2
+		}
+	}
+	if err != nil {
+		log.Println("cron: failed to save", id, err)
+	}
+}
+
+// Add adds an entry, its ID is generated if it is empty.
+func (c *Cron) Add(entry *CronEntry) (*CronEntry, error) {
+	spec, err := ParseCron(entry.Spec)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(entry.Path, c.root.Name+".") {
+		return nil, errors.New(entry.Path + " is not in tree " + c.root.Name)
+	}
+	entry.spec = spec
+	if entry.Ctime == 0 {
+		entry.Ctime = time.Now().Unix()
+	}
+	c.mutex.Lock()
+	if entry.ID == "" {
+		entry.ID = strconv.FormatInt(time.Now().UnixNano(), 36)
+	} else if _, ok := c.entries[entry.ID]; ok {
+		c.mutex.Unlock()
+		return nil, errors.New("duplicated id " + entry.ID)
+	}
+	c.entries[entry.ID] = entry
+	c.save(entry.ID, entry)
+	c.mutex.Unlock()
+	c.start()
+	return entry, nil
+}
+
+// Get returns a copy of the entry of id, nil if not found
+func (c *Cron) Get(id string) *CronEntry {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	if entry, ok := c.entries[id]; ok {
+		return c.copyOf(entry)
+	}
+	return nil
+}
+
+// copyOf returns a copy of entry with NextRun, caller should hold c.mutex
+func (c *Cron) copyOf(entry *CronEntry) *CronEntry {
+	ret := *entry
+	ret.NextRun = 0
+	if !entry.Paused {
+		if next := entry.spec.Next(time.Now()); !next.IsZero() {
+			ret.NextRun = next.Unix()
+		}
+	}
+	return &ret
+}
+
+// List returns entries of user (of all users if username is "*") by creation time
+func (c *Cron) List(username string) []*CronEntry {
+	c.mutex.RLock()
+	ret := make([]*CronEntry, 0, len(c.entries))
+	for _, entry := range c.entries {
+		if username == "*" || entry.Username == username {
+			ret = append(ret, c.copyOf(entry))
+		}
+	}
+	c.mutex.RUnlock()
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Ctime < ret[j].Ctime })
+	return ret
+}
+
+// SetPaused pauses or resumes an entry
+func (c *Cron) SetPaused(id string, paused bool) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	entry, ok := c.entries[id]
+	if !ok {
+		return errors.New("not found")
+	}
+	entry.Paused = paused
+	c.save(id, entry)
+	return nil
+}
+
+// Delete removes an entry and its history
+func (c *Cron) Delete(id string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if _, ok := c.entries[id]; !ok {
+		return errors.New("not found")
+	}
+	delete(c.entries, id)
+	delete(c.history, id)
+	c.save(id, nil)
+	return nil
+}
+
+// History returns latest runs of an entry, latest first
+func (c *Cron) History(id string) []*CronRun {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return append([]*CronRun{}, c.history[id]...)
+}
+
+// RunNow runs an entry immediately (even if it is paused), returns CmdID of the call
+func (c *Cron) RunNow(id string) (int32, error) {
+	c.mutex.RLock()
+	entry, ok := c.entries[id]
+	c.mutex.RUnlock()
+	if !ok {
+		return 0, errors.New("not found")
+	}
+	return c.run(entry, true)
+}
+
+// start starts the ticking goroutine once
+func (c *Cron) start() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.started {
+		return
+	}
+	c.started = true
+	go func() {
+		for {
+			// wake up at the beginning of every minute
+			now := time.Now()
+			next := now.Truncate(time.Minute).Add(time.Minute)
+			time.Sleep(next.Sub(now))
+			c.tick(next)
+		}
+	}()
+}
+
+// tick runs entries which are on schedule at t
+func (c *Cron) tick(t time.Time) {
+	due := make([]*CronEntry, 0)
+	c.mutex.RLock()
+	for _, entry := range c.entries {
+		if !entry.Paused && entry.spec.Match(t) {
+			due = append(due, entry)
+		}
+	}
+	c.mutex.RUnlock()
+	for _, entry := range due {
+		if _, err := c.run(entry, false); err != nil {
+			log.Println("cron:", entry.ID, entry.Path, err)
+		}
+	}
+}
+
+// run calls the API of entry in background and records the result in history
+func (c *Cron) run(entry *CronEntry, manual bool) (int32, error) {
+	record := &CronRun{EntryID: entry.ID, Start: time.Now().Unix(), Manual: manual}
+	var user User
+	if entry.Username != "" {
+		if user = c.UserLookup(entry.Username); user == nil {
+			record.End = record.Start
+			record.Retcode = RetcodeForbidden
+			record.Stderr = "user not found: " + entry.Username
+			c.record(record)
+			return 0, errors.New(record.Stderr)
+		}
+	}
+	Metrics.Incr("cron.run")
+	ctx := c.root.CallAs(user, entry.Path, entry.Args, entry.Kw, func(ret *TreeCallReturn) {
+		if ret.Retcode < 0 {
+			// notification
+			return
+		}
+		record.End = time.Now().Unix()
+		record.CmdID = ret.CmdID
+		record.Retcode = ret.Retcode
+		if ret.Stderr != nil {
+			record.Stderr = ret.Stderr.Error()
+		} else {
+			record.Stdout = ret.Stdout
+		}
+		c.record(record)
+	})
+	return ctx.CmdID, nil
+}
+
+// record adds a run to history of its entry
+func (c *Cron) record(run *CronRun) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	entry, ok := c.entries[run.EntryID]
+	if !ok {
+		// deleted
+		return
+	}
+	if run.Retcode != 0 {
+		Metrics.Incr("cron.failed")
+	}
+	runs := append([]*CronRun{run}, c.history[run.EntryID]...)
+	if len(runs) > c.HistorySize {
+		runs = runs[:c.HistorySize]
+	}
+	c.history[run.EntryID] = runs
+	entry.LastRun = run.Start
+	c.save(entry.ID, entry)
+	if c.storage != nil {
+		if data, err := json.Marshal(runs); err == nil {
+			c.storage.SetString(cronHistoryKey+run.EntryID, data)
+		} else {
+			log.Println("cron: failed to save history of", run.EntryID, err)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCronField(t *testing.T) {
+	bitsOf := func(values ...int) uint64 {
+		var bits uint64
+		for _, v := range values {
+			bits |= 1 << uint(v)
+		}
+		return bits
+	}
+	cases := []struct {
+		field    string
+		min, max int
+		expect   uint64
+		bad      bool
+	}{
+		{"*", 0, 5, bitsOf(0, 1, 2, 3, 4, 5), false},
+		{"3", 0, 59, bitsOf(3), false},
+		{"1-4", 0, 59, bitsOf(1, 2, 3, 4), false},
+		{"*/15", 0, 59, bitsOf(0, 15, 30, 45), false},
+		{"1-10/3", 0, 59, bitsOf(1, 4, 7, 10), false},
+		{"5/20", 0, 59, bitsOf(5, 25, 45), false},
+		{"0,30,45-46", 0, 59, bitsOf(0, 30, 45, 46), false},
+		{"60", 0, 59, 0, true},
+		{"0", 1, 31, 0, true},
+		{"5-1", 0, 59, 0, true},
+		{"*/0", 0, 59, 0, true},
+		{"a", 0, 59, 0, true},
+		{"1-b", 0, 59, 0, true},
+	}
+	for _, c := range cases {
+		bits, err := parseCronField(c.field, c.min, c.max)
+		if c.bad {
+			if err == nil {
+				t.Errorf("%q: expect error", c.field)
+			}
+			continue
+		}
+		if err != nil || bits != c.expect {
+			t.Errorf("%q: expect %b, got %b %v", c.field, c.expect, bits, err)
+		}
+	}
+}
+
+func TestParseCron(t *testing.T) {
+	for _, expr := range []string{"* * * * *", "30 8 * * 1-5", "@daily", " @hourly ", "0 0 1 1 7"} {
+		if _, err := ParseCron(expr); err != nil {
+			t.Errorf("%q: %v", expr, err)
+		}
+	}
+	for _, expr := range []string{"", "* * * *", "* * * * * *", "@never", "0 24 * * *", "0 0 0 * *", "0 0 * 13 *", "0 0 * * 8"} {
+		if _, err := ParseCron(expr); err == nil {
+			t.Errorf("%q: expect error", expr)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	at := func(s string) time.Time {
+		ts, err := time.Parse("2006-01-02 15:04", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ts
+	}
+	cases := []struct {
+		expr, from, expect string
+	}{
+		{"*/15 * * * *", "2026-10-19 10:07", "2026-10-19 10:15"},
+		{"30 8 * * 1-5", "2026-10-19 08:30", "2026-10-20 08:30"},
+		// 2026-10-24 is Saturday
+		{"30 8 * * 1-5", "2026-10-23 09:00", "2026-10-26 08:30"},
+		{"@monthly", "2026-12-31 23:59", "2027-01-01 00:00"},
+		// day-of-week 7 is Sunday too
+		{"0 0 * * 7", "2026-10-19 00:00", "2026-10-25 00:00"},
+		// either day-of-month or day-of-week when both are restricted
+		{"0 12 1 * 3", "2026-10-19 00:00", "2026-10-21 12:00"},
+		{"0 0 29 2 *", "2026-03-01 00:00", "2028-02-29 00:00"},
+	}
+	for _, c := range cases {
+		spec, err := ParseCron(c.expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		next := spec.Next(at(c.from))
+		if !next.Equal(at(c.expect)) {
+			t.Errorf("%q from %s: expect %s, got %s", c.expr, c.from, c.expect, next.Format("2006-01-02 15:04"))
+		}
+		if !spec.Match(next) {
+			t.Errorf("%q: %s does not match", c.expr, c.expect)
+		}
+	}
+	spec, _ := ParseCron("0 0 31 2 *")
+	if next := spec.Next(at("2026-01-01 00:00")); !next.IsZero() {
+		t.Errorf("expect no time for Feb 31, got %s", next)
+	}
+}
@@ -0,0 +1,300 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// HTTPJob is a call issued through HTTPGateway, it is polled by GET <prefix>/$jobs/<job id>
+type HTTPJob struct {
+	ID   string `json:"job"`
+	Path string `json:"path"`
+	// running, resolved or rejected
+	State   string `json:"state"`
+	Retcode int32  `json:"retcode"`
+	// the result, or the latest notify while running
+	Stdout json.RawMessage `json:"stdout,omitempty"`
+	Stderr json.RawMessage `json:"stderr,omitempty"`
+	// the latest progress, see TreeCallCtx.Progress()
+	Progress *Progress `json:"progress,omitempty"`
+	Ctime    int64     `json:"ctime"`
+	Mtime    int64     `json:"mtime"`
+	// url to poll this job
+	Status string `json:"status,omitempty"`
+	// username, or uuid of a traced guest, who issued this job
+	owner string
+	// true while this job is counted in HTTPGateway.running
+	counted bool
+	ctx     *TreeCallCtx
+	done    chan struct{}
+}
+
+// httpCallBody is the JSON body of POST <prefix>/<path>
+type httpCallBody struct {
+	Args []string          `json:"args"`
+	Kw   map[string]string `json:"kw"`
+	// deadline in milliseconds, like Command.timeout
+	Timeout int32 `json:"timeout"`
+}
+
+// HTTPGateway lets exportables of a tree be called over HTTP, for clients which can not hold a websocket.
+//
+//	POST <prefix>/<branch>.<func>   body: {"args":[...], "kw":{...}, "timeout": ms}, query: ?wait=ms
+//	GET  <prefix>/$jobs/<job id>    query: ?wait=ms
+//	POST <prefix>/$jobs/<job id>/kill
+//
+// A call which is finished in wait (default is Wait) responds its result, otherwise it responds
+// 202 with the job id and the url to poll. Calls are issued as the user of the request,
+// so the ACL mode of the routes and the ACL of branches are both respected.
+// Jobs are owned by username, or uuid of a traced guest, so a guest who is not traced
+// is forbidden, and running jobs of an owner are limited by MaxJobsPerOwner.
+type HTTPGateway struct {
+	Root   *TreeRoot
+	Prefix string
+	// default time to wait for the result before responding 202, default is 10 seconds
+	Wait time.Duration
+	// how long a finished job is kept for polling, default is 10 minutes
+	Retention time.Duration
+	// max running jobs of an owner, more calls are rejected with RetcodeBusy, default is 16, 0 for unlimited
+	MaxJobsPerOwner int
+	jobs            map[string]*HTTPJob
+	// count of running jobs by owner
+	running map[string]int
+	mutex   sync.RWMutex
+}
+
+// MountHTTP serves calls of this tree over HTTP at prefix (ex. /tree) with acl mode, see HTTPGateway
+func (self *TreeRoot) MountHTTP(router *RouteRegister, prefix string, acl int) *HTTPGateway {
+	prefix = strings.TrimRight(prefix, "/")
+	gateway := &HTTPGateway{
+		Root:      self,
+		Prefix:    prefix,
+		Wait:      10 * time.Second,
+		Retention:       10 * time.Minute,
+		MaxJobsPerOwner: 16,
+		jobs:            make(map[string]*HTTPJob),
+		running:         make(map[string]int),
+	}
+	router.Post(prefix+"/{path:*}", gateway.handlePost, acl)
+	router.Get(prefix+"/$jobs/{id}", gateway.handleGet, acl)
+	return gateway
+}
+
+// ownerOf returns who issues the request, it is empty for a guest who is not traced
+func ownerOf(ctx *RequestCtx) string {
+	if ctx.User != nil {
+		return ctx.User.Username()
+	}
+	return ctx.UUID
+}
+
+// respond writes v in JSON with status
+func respond(ctx *RequestCtx, status int, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		status = 500
+		data, _ = json.Marshal(&TreeCallError{Code: RetcodeJobFailed, Message: err.Error()})
+	}
+	ctx.Ctx.SetStatusCode(status)
+	ctx.Ctx.SetContentType("application/json; charset=utf-8")
+	ctx.Ctx.SetBody(data)
+}
+
+// respondError writes a TreeCallError in JSON, the status is retcode if it is a http status
+func respondError(ctx *RequestCtx, retcode int32, err error) {
+	status := int(retcode)
+	if status < 400 || status > 599 {
+		status = 500
+	}
+	respond(ctx, status, AsTreeCallError(retcode, err))
+}
+
+// waitOf returns ?wait=ms of request, or the default
+func (gw *HTTPGateway) waitOf(ctx *RequestCtx, def time.Duration) time.Duration {
+	if ms, err := ctx.Ctx.QueryArgs().GetUint("wait"); err == nil {
+		return time.Duration(ms) * time.Millisecond
+	}
+	return def
+}
+
+func (gw *HTTPGateway) handlePost(ctx *RequestCtx) {
+	path, _ := ctx.Ctx.UserValue("path").(string)
+	if strings.HasPrefix(path, "$jobs/") && strings.HasSuffix(path, "/kill") {
+		gw.kill(ctx, strings.TrimSuffix(strings.TrimPrefix(path, "$jobs/"), "/kill"))
+		return
+	}
+	owner := ownerOf(ctx)
+	if owner == "" {
+		// jobs of untraced guests could be polled or killed by each other
+		respondError(ctx, RetcodeForbidden, errors.New("login or tracing is required"))
+		return
+	}
+	body := httpCallBody{}
+	if data := ctx.Ctx.PostBody(); len(data) > 0 {
+		if err := json.Unmarshal(data, &body); err != nil {
+			respondError(ctx, RetcodeBadRequest, err)
+			return
+		}
+	}
+	// both <prefix>/$exec.Run and <prefix>/$exec/Run are accepted
+	nodePath := strings.Replace(strings.Trim(path, "/"), "/", ".", -1)
+	if !strings.HasPrefix(nodePath, gw.Root.Name+".") {
+		nodePath = gw.Root.Name + "." + nodePath
+	}
+
+	now := time.Now().Unix()
+	job := &HTTPJob{
+		ID:    uuid.New().String(),
+		Path:  nodePath,
+		State: "running",
+		Ctime: now,
+		Mtime: now,
+		owner: owner,
+		done:  make(chan struct{}),
+	}
+	job.Status = gw.Prefix + "/$jobs/" + job.ID
+	// the slot is reserved before the call is created, so a rejected request leaves nothing behind
+	gw.mutex.Lock()
+	if gw.MaxJobsPerOwner > 0 && gw.running[owner] >= gw.MaxJobsPerOwner {
+		gw.mutex.Unlock()
+		Metrics.Incr("gateway.busy")
+		respondError(ctx, RetcodeBusy, errors.New("too many running jobs"))
+		return
+	}
+	gw.jobs[job.ID] = job
+	gw.running[owner]++
+	job.counted = true
+	gw.mutex.Unlock()
+
+	callCtx := gw.Root.newInternalCallCtx(ctx.User, body.Args, body.Kw, nil)
+	callCtx.Observe(func(ret *TreeCallReturn) {
+		gw.update(job, ret)
+	})
+	if body.Timeout > 0 {
+		callCtx.SetTimeout(time.Duration(body.Timeout) * time.Millisecond)
+	}
+	gw.mutex.Lock()
+	if job.State == "running" {
+		job.ctx = callCtx
+	}
+	gw.mutex.Unlock()
+	Metrics.Incr("gateway.calls")
+	go gw.Root.Call(nodePath, callCtx)
+
+	gw.respondJob(ctx, job, gw.waitOf(ctx, gw.Wait))
+}
+
+// update records notify, progress and result of a job
+func (gw *HTTPGateway) update(job *HTTPJob, ret *TreeCallReturn) {
+	gw.mutex.Lock()
+	defer gw.mutex.Unlock()
+	if job.State != "running" {
+		return
+	}
+	job.Mtime = time.Now().Unix()
+	if ret.Progress != nil {
+		job.Progress = ret.Progress
+	}
+	if ret.Retcode > 0 {
+		job.State = "rejected"
+		job.Retcode = ret.Retcode
+		job.Stderr = json.RawMessage(MarshalStderr(ret.Retcode, ret.Stderr))
+	} else if ret.Stdout != nil || ret.Retcode == 0 {
+		data, err := json.Marshal(ret.Stdout)
+		if err != nil {
+			data, _ = json.Marshal(err.Error())
+		}
+		job.Stdout = data
+		if ret.Retcode == 0 {
+			job.State = "resolved"
+		}
+	}
+	if job.State != "running" {
+		close(job.done)
+		job.ctx = nil
+		if job.counted {
+			job.counted = false
+			if gw.running[job.owner]--; gw.running[job.owner] <= 0 {
+				delete(gw.running, job.owner)
+			}
+		}
+		time.AfterFunc(gw.Retention, func() {
+			gw.mutex.Lock()
+			delete(gw.jobs, job.ID)
+			gw.mutex.Unlock()
+		})
+	}
+}
+
+// respondJob waits for the job to be finished in wait, then responds a snapshot of it
+func (gw *HTTPGateway) respondJob(ctx *RequestCtx, job *HTTPJob, wait time.Duration) {
+	if wait > 0 {
+		timer := time.NewTimer(wait)
+		select {
+		case <-job.done:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+	gw.mutex.RLock()
+	snapshot := *job
+	gw.mutex.RUnlock()
+	switch snapshot.State {
+	case "running":
+		respond(ctx, 202, &snapshot)
+	case "rejected":
+		status := int(snapshot.Retcode)
+		if status < 400 || status > 599 {
+			status = 500
+		}
+		respond(ctx, status, &snapshot)
+	default:
+		respond(ctx, 200, &snapshot)
+	}
+}
+
+// job returns the job of id if it is issued by who issues the request,
+// otherwise the retcode to reject the request with
+func (gw *HTTPGateway) job(ctx *RequestCtx, id string) (*HTTPJob, int32, error) {
+	owner := ownerOf(ctx)
+	if owner == "" {
+		return nil, RetcodeForbidden, errors.New("login or tracing is required")
+	}
+	gw.mutex.RLock()
+	job, ok := gw.jobs[id]
+	gw.mutex.RUnlock()
+	if !ok || (job.owner != owner && !AdminChecker(ctx.User)) {
+		return nil, RetcodeNotFound, errors.New("job not found")
+	}
+	return job, 0, nil
+}
+
+func (gw *HTTPGateway) handleGet(ctx *RequestCtx) {
+	id, _ := ctx.Ctx.UserValue("id").(string)
+	job, retcode, err := gw.job(ctx, id)
+	if err != nil {
+		respondError(ctx, retcode, err)
+		return
+	}
+	gw.respondJob(ctx, job, gw.waitOf(ctx, 0))
+}
+
+func (gw *HTTPGateway) kill(ctx *RequestCtx, id string) {
+	job, retcode, err := gw.job(ctx, id)
+	if err != nil {
+		respondError(ctx, retcode, err)
+		return
+	}
+	gw.mutex.RLock()
+	callCtx := job.ctx
+	gw.mutex.RUnlock()
+	if callCtx != nil {
+		callCtx.Kill()
+	}
+	gw.respondJob(ctx, job, 0)
+}
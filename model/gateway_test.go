@@ -0,0 +1,155 @@
+package model
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// testBranch is a branch of exportables for tests
+type testBranch struct {
+	BaseBranch
+}
+
+// newTestRoot returns a tree with testBranch at "test"
+func newTestRoot() *TreeRoot {
+	root := NewTreeRoot()
+	branch := &testBranch{}
+	branch.InitBaseBranch("test")
+	branch.Export(branch.Echo, branch.Wait)
+	root.AddBranchWithName(branch, "test")
+	return root
+}
+
+func (b *testBranch) BeReady(treeroot *TreeRoot) {
+	treeroot.SureReady(b)
+}
+
+// Echo resolves its args
+func (b *testBranch) Echo(ctx *TreeCallCtx) {
+	ctx.Resolve(ctx.Args)
+}
+
+// Wait runs until it is killed or timed out
+func (b *testBranch) Wait(ctx *TreeCallCtx) {
+	<-ctx.Context().Done()
+}
+
+// gatewayRequest issues a request to gw as owner (uuid of a traced guest), returns status and the body
+func gatewayRequest(gw *HTTPGateway, method, owner, path, query, body string) (int, map[string]interface{}) {
+	fctx := &fasthttp.RequestCtx{}
+	fctx.Request.Header.SetMethod(method)
+	fctx.Request.SetRequestURI(gw.Prefix + "/" + path + query)
+	fctx.Request.SetBodyString(body)
+	ctx := &RequestCtx{Ctx: fctx, UUID: owner}
+	if method == "GET" {
+		fctx.SetUserValue("id", path[len("$jobs/"):])
+		gw.handleGet(ctx)
+	} else {
+		fctx.SetUserValue("path", path)
+		gw.handlePost(ctx)
+	}
+	ret := make(map[string]interface{})
+	json.Unmarshal(fctx.Response.Body(), &ret)
+	return fctx.Response.StatusCode(), ret
+}
+
+func newTestGateway() *HTTPGateway {
+	return &HTTPGateway{
+		Root:            newTestRoot(),
+		Prefix:          "/tree",
+		Wait:            time.Second,
+		Retention:       time.Minute,
+		MaxJobsPerOwner: 16,
+		jobs:            make(map[string]*HTTPJob),
+		running:         make(map[string]int),
+	}
+}
+
+func (gw *HTTPGateway) runningOf(owner string) int {
+	gw.mutex.RLock()
+	defer gw.mutex.RUnlock()
+	return gw.running[owner]
+}
+
+func TestGatewayJobLifecycle(t *testing.T) {
+	gw := newTestGateway()
+
+	status, ret := gatewayRequest(gw, "POST", "guest", "test.Echo", "", `{"args":["a","b"]}`)
+	if status != 200 || ret["state"] != "resolved" {
+		t.Fatalf("expect resolved, got %d %v", status, ret)
+	}
+	if stdout, _ := ret["stdout"].([]interface{}); len(stdout) != 2 || stdout[0] != "a" {
+		t.Fatalf("unexpected stdout %v", ret["stdout"])
+	}
+
+	status, ret = gatewayRequest(gw, "POST", "guest", "test/Wait", "?wait=0", "")
+	if status != 202 || ret["state"] != "running" {
+		t.Fatalf("expect 202, got %d %v", status, ret)
+	}
+	id, _ := ret["job"].(string)
+	if n := gw.runningOf("guest"); n != 1 {
+		t.Fatalf("expect 1 running job, got %d", n)
+	}
+	if status, _ = gatewayRequest(gw, "GET", "guest", "$jobs/"+id, "", ""); status != 202 {
+		t.Fatalf("expect the job running, got %d", status)
+	}
+	if status, _ = gatewayRequest(gw, "GET", "other", "$jobs/"+id, "", ""); status != 404 {
+		t.Fatalf("expect a job of others not found, got %d", status)
+	}
+	if status, _ = gatewayRequest(gw, "GET", "", "$jobs/"+id, "", ""); status != 403 {
+		t.Fatalf("expect an ownerless poll forbidden, got %d", status)
+	}
+	status, ret = gatewayRequest(gw, "POST", "guest", "$jobs/"+id+"/kill", "", "")
+	if ret["state"] != "rejected" || int32(ret["retcode"].(float64)) != RetcodeKilled {
+		t.Fatalf("expect killed, got %d %v", status, ret)
+	}
+	if n := gw.runningOf("guest"); n != 0 {
+		t.Fatalf("expect no running job, got %d", n)
+	}
+
+	if status, _ = gatewayRequest(gw, "POST", "", "test.Echo", "", ""); status != 403 {
+		t.Fatalf("expect an ownerless call forbidden, got %d", status)
+	}
+	if status, _ = gatewayRequest(gw, "POST", "guest", "test.Echo", "", "{"); status != 400 {
+		t.Fatalf("expect a bad body rejected, got %d", status)
+	}
+}
+
+func TestGatewayBusyDoesNotReleaseSlots(t *testing.T) {
+	gw := newTestGateway()
+	gw.MaxJobsPerOwner = 1
+
+	status, ret := gatewayRequest(gw, "POST", "guest", "test.Wait", "?wait=0", "")
+	if status != 202 {
+		t.Fatalf("expect 202, got %d %v", status, ret)
+	}
+	id, _ := ret["job"].(string)
+	for i := 0; i < 5; i++ {
+		// a busy call with a short timeout must not be counted when it times out
+		if status, _ = gatewayRequest(gw, "POST", "guest", "test.Wait", "?wait=0", `{"timeout":1}`); status != RetcodeBusy {
+			t.Fatalf("expect busy, got %d", status)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := gw.runningOf("guest"); n != 1 {
+		t.Fatalf("expect 1 running job, got %d", n)
+	}
+	if status, _ = gatewayRequest(gw, "POST", "guest", "test.Echo", "", ""); status != RetcodeBusy {
+		t.Fatalf("expect still busy, got %d", status)
+	}
+	// others are not limited by this owner
+	if status, _ = gatewayRequest(gw, "POST", "other", "test.Echo", "", ""); status != 200 {
+		t.Fatalf("expect others served, got %d", status)
+	}
+
+	gatewayRequest(gw, "POST", "guest", "$jobs/"+id+"/kill", "", "")
+	if n := gw.runningOf("guest"); n != 0 {
+		t.Fatalf("expect no running job, got %d", n)
+	}
+	if status, _ = gatewayRequest(gw, "POST", "guest", "test.Echo", "", ""); status != 200 {
+		t.Fatalf("expect the slot released, got %d", status)
+	}
+}
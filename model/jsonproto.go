@@ -0,0 +1,134 @@
+package model
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	"github.com/valyala/fasthttp"
+)
+
+// websocket subprotocols of the tree, see WebsocketCtx.SetJSON()
+const (
+	// Command and Result of objshpb.proto encaptured in google.protobuf.Any, in binary frames (default)
+	ProtocolProtobuf = "objsh.protobuf"
+	// Command and Result in JSON, in text frames
+	ProtocolJSON = "objsh.json"
+)
+
+// WebsocketProtocols are subprotocols accepted by websocket routes
+var WebsocketProtocols = []string{ProtocolProtobuf, ProtocolJSON}
+
+// subprotocolOf returns the first accepted subprotocol in Sec-WebSocket-Protocol of request, "" if none
+func subprotocolOf(ctx *fasthttp.RequestCtx) string {
+	for _, name := range strings.Split(string(ctx.Request.Header.Peek("Sec-WebSocket-Protocol")), ",") {
+		name = strings.TrimSpace(name)
+		for _, accepted := range WebsocketProtocols {
+			if name == accepted {
+				return name
+			}
+		}
+	}
+	return ""
+}
+
+// JSONResult is Result in JSON text protocol, ex.
+//	{"id":1,"retcode":0,"stdout":{"hello":"world"}}
+//	{"id":2,"retcode":404,"stderr":{"code":404,"message":"not found"}}
+//	{"id":3,"retcode":-1,"chunk":{"id":3,"data":"iVBORw0K...","content_type":"image/png","size":1024}}
+type JSONResult struct {
+	Id      int32           `json:"id"`
+	Retcode int32           `json:"retcode"`
+	Stdout  json.RawMessage `json:"stdout,omitempty"`
+	Stderr  json.RawMessage `json:"stderr,omitempty"`
+	// structured progress of a notify, see TreeCallCtx.Progress()
+	Progress *Progress `json:"progress,omitempty"`
+	// a piece of binary stream of a notify, data is in base64, see TreeCallCtx.StreamWriter()
+	Chunk *Chunk `json:"chunk,omitempty"`
+}
+
+// JSONMessage is a protobuf message other than Result in JSON text protocol
+type JSONMessage struct {
+	// full name of the message, ex. objsh.Command
+	Type    string          `json:"type"`
+	Message json.RawMessage `json:"message"`
+}
+
+// SetJSON lets this websocket send messages in JSON text frames instead of protobuf Any,
+// see ProtocolJSON
+func (self *WebsocketCtx) SetJSON(yes bool) {
+	self.SetBinary(!yes)
+	self.mutex.Lock()
+	self.json = yes
+	self.mutex.Unlock()
+}
+
+// IsJSON returns true if this websocket speaks JSON text protocol
+func (self *WebsocketCtx) IsJSON() bool {
+	self.mutex.RLock()
+	defer self.mutex.RUnlock()
+	return self.json
+}
+
+// MarshalJSONMessage encodes obj for JSON text protocol, a Result is encoded as JSONResult
+func MarshalJSONMessage(obj proto.Message) ([]byte, error) {
+	if result, ok := obj.(*Result); ok {
+		ret := JSONResult{
+			Id:       result.Id,
+			Retcode:  result.Retcode,
+			Progress: result.Progress,
+			Chunk:    result.Chunk,
+		}
+		if len(result.Stdout) > 0 {
+			ret.Stdout = json.RawMessage(result.Stdout)
+		}
+		if result.Stderr != "" {
+			if json.Valid([]byte(result.Stderr)) {
+				ret.Stderr = json.RawMessage(result.Stderr)
+			} else {
+				ret.Stderr, _ = json.Marshal(result.Stderr)
+			}
+		}
+		return json.Marshal(&ret)
+	}
+	var buf bytes.Buffer
+	if err := (&jsonpb.Marshaler{}).Marshal(&buf, obj); err != nil {
+		return nil, err
+	}
+	return json.Marshal(&JSONMessage{Type: proto.MessageName(obj), Message: buf.Bytes()})
+}
+
+// UnmarshalJSONCommand decodes a Command of JSON text protocol, ex.
+//	{"id":1,"name":"Tree.$exec.Run","args":["ls"],"kw":{"cwd":"/tmp"}}
+//	{"id":2,"kill":true,"name":"1"}
+func UnmarshalJSONCommand(data []byte) (*Command, error) {
+	cmd := &Command{}
+	if err := json.Unmarshal(data, cmd); err != nil {
+		return nil, err
+	}
+	if cmd.Message != nil {
+		return cmd, errors.New("message is not supported in JSON protocol")
+	}
+	return cmd, nil
+}
+
+// UnmarshalJSONChunk decodes a Chunk of an upload in JSON text protocol, data is in base64, ex.
+//	{"type":"objsh.Chunk","message":{"id":1,"offset":"0","data":"iVBORw0K...","eof":true}}
+// It returns nil if data is not a JSONMessage, ex. it is a Command.
+func UnmarshalJSONChunk(data []byte) (*Chunk, error) {
+	msg := JSONMessage{}
+	if err := json.Unmarshal(data, &msg); err != nil || msg.Type == "" {
+		return nil, nil
+	}
+	if msg.Type != proto.MessageName(&Chunk{}) {
+		return nil, errors.New("message type " + msg.Type + " is not supported in JSON protocol")
+	}
+	chunk := &Chunk{}
+	if err := jsonpb.Unmarshal(bytes.NewReader(msg.Message), chunk); err != nil {
+		return nil, err
+	}
+	return chunk, nil
+}
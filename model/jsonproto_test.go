@@ -0,0 +1,96 @@
+package model
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestSubprotocolOf(t *testing.T) {
+	cases := map[string]string{
+		"":                             "",
+		"chat":                         "",
+		"objsh.json":                   ProtocolJSON,
+		"chat, objsh.json":             ProtocolJSON,
+		"objsh.protobuf ,objsh.json":   ProtocolProtobuf,
+		" objsh.json , objsh.protobuf": ProtocolJSON,
+	}
+	for header, expect := range cases {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.Header.Set("Sec-WebSocket-Protocol", header)
+		if protocol := subprotocolOf(ctx); protocol != expect {
+			t.Errorf("%q: expect %q, got %q", header, expect, protocol)
+		}
+	}
+}
+
+func TestUnmarshalJSONCommand(t *testing.T) {
+	cmd, err := UnmarshalJSONCommand([]byte(`{"id":1,"name":"Tree.test.Echo","args":["a"],"kw":{"k":"v"},"timeout":100}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cmd.Id != 1 || cmd.Name != "Tree.test.Echo" || len(cmd.Args) != 1 || cmd.Kw["k"] != "v" || cmd.Timeout != 100 {
+		t.Fatalf("unexpected command %v", cmd)
+	}
+	if cmd, err = UnmarshalJSONCommand([]byte(`{"id":2,"kill":true,"name":"1"}`)); err != nil || !cmd.Kill {
+		t.Fatalf("expect a kill, got %v %v", cmd, err)
+	}
+	if _, err = UnmarshalJSONCommand([]byte(`{"id":3,"message":{"type_url":"x"}}`)); err == nil || !strings.Contains(err.Error(), "not supported") {
+		t.Fatalf("expect message not supported, got %v", err)
+	}
+	if _, err = UnmarshalJSONCommand([]byte(`{"id":`)); err == nil {
+		t.Fatal("expect a bad frame rejected")
+	}
+}
+
+func TestMarshalJSONMessage(t *testing.T) {
+	cases := []struct {
+		msg    *Result
+		expect string
+	}{
+		{&Result{Id: 1, Stdout: []byte(`{"hello":"world"}`)}, `{"id":1,"retcode":0,"stdout":{"hello":"world"}}`},
+		{&Result{Id: 2, Retcode: 404, Stderr: `{"code":404}`}, `{"id":2,"retcode":404,"stderr":{"code":404}}`},
+		{&Result{Id: 3, Retcode: 500, Stderr: "boom"}, `{"id":3,"retcode":500,"stderr":"boom"}`},
+		{&Result{Id: 4, Retcode: -1, Chunk: &Chunk{Id: 4, Data: []byte("hi"), Eof: true}}, `{"id":4,"retcode":-1,"chunk":{"id":4,"data":"aGk=","eof":true}}`},
+	}
+	for i, c := range cases {
+		data, err := MarshalJSONMessage(c.msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != c.expect {
+			t.Errorf("case %d: expect %s, got %s", i, c.expect, data)
+		}
+	}
+
+	data, err := MarshalJSONMessage(&Command{Id: 5, Name: "Tree.test.Echo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expect := `{"type":"objsh.Command","message":{"id":5,"name":"Tree.test.Echo"}}`; string(data) != expect {
+		t.Fatalf("expect %s, got %s", expect, data)
+	}
+}
+
+func TestUnmarshalJSONChunk(t *testing.T) {
+	chunk, err := UnmarshalJSONChunk([]byte(`{"type":"objsh.Chunk","message":{"id":1,"offset":"3","data":"aGk=","eof":true}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chunk == nil || chunk.Id != 1 || chunk.Offset != 3 || string(chunk.Data) != "hi" || !chunk.Eof {
+		t.Fatalf("unexpected chunk %v", chunk)
+	}
+	// a command is not a chunk
+	for _, frame := range []string{`{"id":1,"name":"Tree.test.Echo"}`, `not json`} {
+		if chunk, err = UnmarshalJSONChunk([]byte(frame)); chunk != nil || err != nil {
+			t.Fatalf("expect %s ignored, got %v %v", frame, chunk, err)
+		}
+	}
+	if _, err = UnmarshalJSONChunk([]byte(`{"type":"objsh.Command","message":{}}`)); err == nil {
+		t.Fatal("expect other messages not supported")
+	}
+	if _, err = UnmarshalJSONChunk([]byte(`{"type":"objsh.Chunk","message":{"data":1}}`)); err == nil {
+		t.Fatal("expect a bad chunk rejected")
+	}
+}
@@ -0,0 +1,293 @@
+package model
+
+import (
+	"strings"
+	"sync"
+)
+
+// CallLimiter limits the number of concurrent calls globally, per user, per branch and per exportable.
+// Excess calls are queued (the caller is notified with its position in queue) until slots are available,
+// or rejected with RetcodeBusy if the queue is full. Limits are unlimited (0) by default.
+//
+//	Root.Limiter.SetGlobal(100)
+//	Root.Limiter.SetPerUser(4)
+//	Root.Limiter.SetBranch("report", 2) // calls to report.* and its sub-branches
+//	Root.Limiter.SetExportable("report.Build", 1)
+//	Root.Limiter.SetMaxQueue(50)
+//
+// A call takes its slots until it is resolved or rejected (killed or timed out),
+// not until its exportable function returns.
+type CallLimiter struct {
+	global      int
+	perUser     int
+	branches    map[string]int // branch path: limit
+	exportables map[string]int // branch path.FuncName: limit
+	maxQueue    int
+	// number of running calls of every key, see keysOf()
+	running map[string]int
+	queue   []*limitTicket
+	mutex   sync.Mutex
+}
+
+// a call which is queued or running in CallLimiter
+type limitTicket struct {
+	ctx  *TreeCallCtx
+	keys []string
+	run  func()
+	// see ticketXXX
+	state int
+	// 1-based position in queue which has been notified to caller
+	position int
+}
+
+const (
+	ticketNew = iota
+	ticketQueued
+	ticketRunning
+	ticketDone
+)
+
+// the number of queued calls is limited to this by default
+const DefaultMaxQueue = 1000
+
+func NewCallLimiter() *CallLimiter {
+	return &CallLimiter{
+		branches:    make(map[string]int),
+		exportables: make(map[string]int),
+		maxQueue:    DefaultMaxQueue,
+		running:     make(map[string]int),
+	}
+}
+
+// SetGlobal sets the max number of concurrent calls of the whole tree, 0 for unlimited
+func (l *CallLimiter) SetGlobal(n int) {
+	l.mutex.Lock()
+	l.global = n
+	l.mutex.Unlock()
+	l.dispatch()
+}
+
+// SetPerUser sets the max number of concurrent calls of every user, 0 for unlimited.
+// Guests (not logged-in) share one quota.
+func (l *CallLimiter) SetPerUser(n int) {
+	l.mutex.Lock()
+	l.perUser = n
+	l.mutex.Unlock()
+	l.dispatch()
+}
+
+// SetBranch sets the max number of concurrent calls of a branch (including its sub-branches), 0 for unlimited
+// @path: branch path without tree name, ex. "report" or "report.monthly"
+func (l *CallLimiter) SetBranch(path string, n int) {
+	l.mutex.Lock()
+	setLimit(l.branches, path, n)
+	l.mutex.Unlock()
+	l.dispatch()
+}
+
+// SetExportable sets the max number of concurrent calls of an exportable, 0 for unlimited
+// @path: branch path and function name without tree name, ex. "report.Build"
+func (l *CallLimiter) SetExportable(path string, n int) {
+	l.mutex.Lock()
+	setLimit(l.exportables, path, n)
+	l.mutex.Unlock()
+	l.dispatch()
+}
+
+// SetMaxQueue sets the max number of queued calls, 0 to reject excess calls without queuing
+func (l *CallLimiter) SetMaxQueue(n int) {
+	l.mutex.Lock()
+	l.maxQueue = n
+	l.mutex.Unlock()
+}
+
+func setLimit(limits map[string]int, path string, n int) {
+	if n > 0 {
+		limits[path] = n
+	} else {
+		delete(limits, path)
+	}
+}
+
+func (l *CallLimiter) enabled() bool {
+	return l.global > 0 || l.perUser > 0 || len(l.branches) > 0 || len(l.exportables) > 0
+}
+
+// keysOf returns keys of counters which a call to branchPath.apiName takes
+func keysOf(username string, branchPath string, apiName string) []string {
+	keys := []string{"*", "user\t" + username}
+	paths := strings.Split(branchPath, ".")
+	for i := range paths {
+		keys = append(keys, "branch\t"+strings.Join(paths[:i+1], "."))
+	}
+	return append(keys, "api\t"+branchPath+"."+apiName)
+}
+
+// limitOf returns the limit of a key, 0 for unlimited
+func (l *CallLimiter) limitOf(key string) int {
+	switch {
+	case key == "*":
+		return l.global
+	case strings.HasPrefix(key, "user\t"):
+		return l.perUser
+	case strings.HasPrefix(key, "branch\t"):
+		return l.branches[key[len("branch\t"):]]
+	case strings.HasPrefix(key, "api\t"):
+		return l.exportables[key[len("api\t"):]]
+	}
+	return 0
+}
+
+// fits tells if a call which takes keys can be run now, caller should hold l.mutex
+func (l *CallLimiter) fits(keys []string) bool {
+	for _, key := range keys {
+		if limit := l.limitOf(key); limit > 0 && l.running[key] >= limit {
+			return false
+		}
+	}
+	return true
+}
+
+// take marks ticket as running, caller should hold l.mutex
+func (l *CallLimiter) take(ticket *limitTicket) {
+	ticket.state = ticketRunning
+	for _, key := range ticket.keys {
+		l.running[key]++
+	}
+}
+
+// Run calls run() if there are slots for this call, otherwise the call is queued or rejected.
+// It is called by TreeRoot.Call.
+func (l *CallLimiter) Run(ctx *TreeCallCtx, branchPath string, apiName string, run func()) {
+	l.mutex.Lock()
+	enabled := l.enabled()
+	l.mutex.Unlock()
+	if !enabled {
+		run()
+		return
+	}
+	var username string
+	if user := ctx.WsCtx.GetUser(); user != nil {
+		username = user.Username()
+	}
+	ticket := &limitTicket{ctx: ctx, keys: keysOf(username, branchPath, apiName), run: run}
+	// release slots or leave the queue when the call is completed, killed or timed out
+	ctx.Observe(func(ret *TreeCallReturn) {
+		if ctx.IsFinished() {
+			l.done(ticket)
+		}
+	})
+
+	l.mutex.Lock()
+	if ticket.state == ticketDone {
+		// it has been rejected (ex. timeout) already
+		l.mutex.Unlock()
+		return
+	}
+	if len(l.queue) == 0 && l.fits(ticket.keys) {
+		l.take(ticket)
+		l.mutex.Unlock()
+		run()
+		return
+	}
+	if len(l.queue) >= l.maxQueue {
+		l.mutex.Unlock()
+		Metrics.Incr("treecall.busy")
+		ctx.Reject(RetcodeBusy, NewTreeCallError(RetcodeBusy, "server busy", map[string]string{"path": ctx.NodePath}))
+		return
+	}
+	ticket.state = ticketQueued
+	l.queue = append(l.queue, ticket)
+	l.mutex.Unlock()
+	Metrics.Incr("treecall.queued")
+
+	// a queued call can be killed by user
+	ctx.killable()
+	l.dispatch()
+}
+
+// done releases slots of a running ticket or removes a queued ticket from queue
+func (l *CallLimiter) done(ticket *limitTicket) {
+	l.mutex.Lock()
+	switch ticket.state {
+	case ticketRunning:
+		for _, key := range ticket.keys {
+			if l.running[key]--; l.running[key] <= 0 {
+				delete(l.running, key)
+			}
+		}
+	case ticketQueued:
+		for i, t := range l.queue {
+			if t == ticket {
+				l.queue = append(l.queue[:i], l.queue[i+1:]...)
+				break
+			}
+		}
+	}
+	ticket.state = ticketDone
+	l.mutex.Unlock()
+	l.dispatch()
+}
+
+// dispatch starts queued calls which fit, and notifies others with their new positions.
+// A queued call is started in its own goroutine.
+func (l *CallLimiter) dispatch() {
+	var started, moved []*limitTicket
+	l.mutex.Lock()
+	queue := l.queue[:0]
+	for _, ticket := range l.queue {
+		if l.fits(ticket.keys) {
+			l.take(ticket)
+			started = append(started, ticket)
+			continue
+		}
+		queue = append(queue, ticket)
+		if ticket.position != len(queue) {
+			ticket.position = len(queue)
+			moved = append(moved, ticket)
+		}
+	}
+	l.queue = queue
+	// snapshot positions to be notified outside of the lock
+	positions := make([]int, len(moved))
+	for i, ticket := range moved {
+		positions[i] = ticket.position
+	}
+	l.mutex.Unlock()
+
+	for i, ticket := range moved {
+		ticket.ctx.Notify(map[string]interface{}{"queued": true, "position": positions[i]})
+	}
+	for _, ticket := range started {
+		ticket.ctx.Go(ticket.run)
+	}
+}
+
+// Snapshot returns limits and usages, the usage of user is included if user is not nil.
+func (l *CallLimiter) Snapshot(user User) map[string]interface{} {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	usage := func(key string, limit int) map[string]int {
+		return map[string]int{"limit": limit, "running": l.running[key]}
+	}
+	branches := make(map[string]map[string]int, len(l.branches))
+	for path, limit := range l.branches {
+		branches[path] = usage("branch\t"+path, limit)
+	}
+	exportables := make(map[string]map[string]int, len(l.exportables))
+	for path, limit := range l.exportables {
+		exportables[path] = usage("api\t"+path, limit)
+	}
+	ret := map[string]interface{}{
+		"global":      usage("*", l.global),
+		"perUser":     l.perUser,
+		"branches":    branches,
+		"exportables": exportables,
+		"queued":      len(l.queue),
+		"maxQueue":    l.maxQueue,
+	}
+	if user != nil {
+		ret["user"] = usage("user\t"+user.Username(), l.perUser)
+	}
+	return ret
+}
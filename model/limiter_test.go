@@ -0,0 +1,92 @@
+package model
+
+import (
+	"sync"
+	"testing"
+)
+
+// limitedCall is a call of test.Wait which records its queue positions and final retcode
+type limitedCall struct {
+	ctx       *TreeCallCtx
+	positions []int
+	retcode   int32
+	mutex     sync.Mutex
+}
+
+func callLimited(root *TreeRoot) *limitedCall {
+	call := &limitedCall{retcode: -1}
+	call.ctx = root.newInternalCallCtx(nil, nil, nil, func(ret *TreeCallReturn) {
+		call.mutex.Lock()
+		defer call.mutex.Unlock()
+		if ret.Retcode >= 0 {
+			call.retcode = ret.Retcode
+		} else if notify, ok := ret.Stdout.(map[string]interface{}); ok && notify["queued"] == true {
+			call.positions = append(call.positions, notify["position"].(int))
+		}
+	})
+	go root.Call("Tree.test.Wait", call.ctx)
+	return call
+}
+
+func (call *limitedCall) position() int {
+	call.mutex.Lock()
+	defer call.mutex.Unlock()
+	if len(call.positions) == 0 {
+		return 0
+	}
+	return call.positions[len(call.positions)-1]
+}
+
+func (call *limitedCall) finished() int32 {
+	call.mutex.Lock()
+	defer call.mutex.Unlock()
+	return call.retcode
+}
+
+func TestKeysOf(t *testing.T) {
+	keys := keysOf("alice", "report.monthly", "Build")
+	expect := []string{"*", "user\talice", "branch\treport", "branch\treport.monthly", "api\treport.monthly.Build"}
+	if len(keys) != len(expect) {
+		t.Fatalf("expect %v, got %v", expect, keys)
+	}
+	for i := range expect {
+		if keys[i] != expect[i] {
+			t.Fatalf("expect %v, got %v", expect, keys)
+		}
+	}
+}
+
+func TestLimiterQueuesAndReleases(t *testing.T) {
+	root := newTestRoot()
+	root.Limiter.SetExportable("test.Wait", 1)
+	root.Limiter.SetMaxQueue(2)
+
+	usage := func() int {
+		return root.Limiter.Snapshot(nil)["exportables"].(map[string]map[string]int)["test.Wait"]["running"]
+	}
+
+	running := callLimited(root)
+	waitFor(t, "the first call running", func() bool { return usage() == 1 })
+	second := callLimited(root)
+	waitFor(t, "the second call queued", func() bool { return second.position() == 1 })
+	third := callLimited(root)
+	waitFor(t, "the third call queued", func() bool { return third.position() == 2 })
+	rejected := callLimited(root)
+	waitFor(t, "the fourth call rejected", func() bool { return rejected.finished() == RetcodeBusy })
+
+	// the slot is released when the running call is killed, then the queue moves on
+	running.ctx.Kill()
+	waitFor(t, "the third call moved", func() bool { return third.position() == 1 })
+	if second.finished() != -1 {
+		t.Fatal("expect the second call running")
+	}
+	// a queued call could be killed, it leaves the queue
+	third.ctx.Kill()
+	waitFor(t, "the third call killed", func() bool { return third.finished() == RetcodeKilled })
+	second.ctx.Kill()
+	waitFor(t, "the second call killed", func() bool { return second.finished() == RetcodeKilled })
+
+	if n := usage(); n != 0 || root.Limiter.Snapshot(nil)["queued"] != 0 {
+		t.Fatalf("expect slots released, got %d running", n)
+	}
+}
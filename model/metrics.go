@@ -0,0 +1,51 @@
+package model
+
+import (
+	"sync"
+)
+
+// Counters is a set of named int64 values, for counting events (Add) or keeping gauges (Set).
+type Counters struct {
+	values map[string]int64
+	mutex  sync.RWMutex
+}
+
+func NewCounters() *Counters {
+	return &Counters{values: make(map[string]int64)}
+}
+
+// Incr increases the counter of given name by 1 and returns its new value
+func (c *Counters) Incr(name string) int64 {
+	return c.Add(name, 1)
+}
+func (c *Counters) Add(name string, delta int64) int64 {
+	c.mutex.Lock()
+	c.values[name] += delta
+	v := c.values[name]
+	c.mutex.Unlock()
+	return v
+}
+func (c *Counters) Set(name string, value int64) {
+	c.mutex.Lock()
+	c.values[name] = value
+	c.mutex.Unlock()
+}
+func (c *Counters) Get(name string) int64 {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.values[name]
+}
+
+// Snapshot returns a copy of all counters
+func (c *Counters) Snapshot() map[string]int64 {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	ret := make(map[string]int64, len(c.values))
+	for k, v := range c.values {
+		ret[k] = v
+	}
+	return ret
+}
+
+// Metrics is the system-wide counters, ex. Metrics.Get("treecall.panic")
+var Metrics = NewCounters()
@@ -0,0 +1,98 @@
+package model
+
+/*
+Middleware wraps every call of the tree, or every call of a branch (including its sub-branches).
+It is the place for cross-cutting concerns, such as logging, auth checks, metrics and argument validation.
+
+	treeRoot.Use(func(ctx *TreeCallCtx, next func()) {
+		if ctx.WsCtx.GetUser() == nil {
+			// short-circuit, next() is not called
+			ctx.Reject(403, errors.New("login required"))
+			return
+		}
+		started := time.Now()
+		ctx.Observe(func(ret *TreeCallReturn) {
+			if ret.Retcode >= 0 {
+				log.Println(ctx.NodePath, "completed in", time.Since(started))
+			}
+		})
+		next()
+	})
+
+The order of calling is root's middlewares, then branches' middlewares from top level down,
+ex. for Tree.sys.network.SetInterface: Tree's, sys's then network's.
+next() returns when the exportable returns, which is not always the end of the call
+(ex. a background task), use ctx.Observe() to know the outcome of the call.
+*/
+type Middleware func(ctx *TreeCallCtx, next func())
+
+// TreeCallObserver is called with every Notify (Retcode < 0), Resolve (Retcode == 0)
+// and Reject (Retcode > 0) of a call
+type TreeCallObserver func(*TreeCallReturn)
+
+// BranchWithMiddleware is implemented by a branch which has middlewares (BaseBranch does)
+type BranchWithMiddleware interface {
+	Middlewares() []Middleware
+}
+
+// Use appends middlewares to every call of this tree
+func (self *TreeRoot) Use(middlewares ...Middleware) {
+	self.middlewares = append(self.middlewares, middlewares...)
+}
+
+// Use appends middlewares to every call of this branch and its sub-branches
+func (bb *BaseBranch) Use(middlewares ...Middleware) {
+	bb.middlewares = append(bb.middlewares, middlewares...)
+}
+func (bb *BaseBranch) Middlewares() []Middleware {
+	return bb.middlewares
+}
+
+func runMiddlewares(middlewares []Middleware, ctx *TreeCallCtx, final func()) {
+	var call func(int)
+	call = func(i int) {
+		if i == len(middlewares) {
+			final()
+			return
+		}
+		middlewares[i](ctx, func() {
+			call(i + 1)
+		})
+	}
+	call(0)
+}
+
+// Observe adds an observer of Notify, Resolve and Reject of this call
+func (tcCtx *TreeCallCtx) Observe(observer TreeCallObserver) {
+	tcCtx.mutex.Lock()
+	tcCtx.observers = append(tcCtx.observers, observer)
+	tcCtx.mutex.Unlock()
+}
+
+func (tcCtx *TreeCallCtx) observe(ret *TreeCallReturn) {
+	tcCtx.mutex.RLock()
+	observers := tcCtx.observers
+	tcCtx.mutex.RUnlock()
+	for _, observer := range observers {
+		observer(ret)
+	}
+}
+
+// AdminChecker tells if user is an administrator, it could be replaced by application.
+// By default, a user is an administrator if its metadata "role" is "admin".
+var AdminChecker = func(user User) bool {
+	if user == nil {
+		return false
+	}
+	role, ok := user.GetMetadata("role")
+	return ok && role == "admin"
+}
+
+// RequireAdmin is a middleware which rejects calls from non-administrators, see AdminChecker
+func RequireAdmin(ctx *TreeCallCtx, next func()) {
+	if !AdminChecker(ctx.WsCtx.GetUser()) {
+		ctx.Reject(RetcodeForbidden, NewTreeCallError(RetcodeForbidden, "admin only", map[string]string{"path": ctx.NodePath}))
+		return
+	}
+	next()
+}
@@ -34,7 +34,13 @@ type Command struct {
 	Message *any.Any          `protobuf:"bytes,5,opt,name=message,proto3" json:"message,omitempty"`
 	//repeated google.protobuf.Any mesgArgs = 6;
 	//map<string,google.protobuf.Any> mesgKw = 7;
-	Kill                 bool     `protobuf:"varint,8,opt,name=kill,proto3" json:"kill,omitempty"`
+	Kill bool `protobuf:"varint,8,opt,name=kill,proto3" json:"kill,omitempty"`
+	// deadline of this call in milliseconds, 0 for no deadline
+	// the call is rejected with retcode 504 if it is not completed in time
+	Timeout int32 `protobuf:"varint,9,opt,name=timeout,proto3" json:"timeout,omitempty"`
+	// like kill, pause or resume the call whose id is given by name
+	Pause                bool     `protobuf:"varint,10,opt,name=pause,proto3" json:"pause,omitempty"`
+	Resume               bool     `protobuf:"varint,11,opt,name=resume,proto3" json:"resume,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -107,6 +113,27 @@ func (m *Command) GetKill() bool {
 	return false
 }
 
+func (m *Command) GetTimeout() int32 {
+	if m != nil {
+		return m.Timeout
+	}
+	return 0
+}
+
+func (m *Command) GetPause() bool {
+	if m != nil {
+		return m.Pause
+	}
+	return false
+}
+
+func (m *Command) GetResume() bool {
+	if m != nil {
+		return m.Resume
+	}
+	return false
+}
+
 type Result struct {
 	// id of Command of this result belongs to
 	// 0 if is an unsolited message from server (aka announcement)
@@ -119,7 +146,11 @@ type Result struct {
 	// JSON encodeding data
 	Stdout []byte `protobuf:"bytes,3,opt,name=stdout,proto3" json:"stdout,omitempty"`
 	// JSON encoded data
-	Stderr               string   `protobuf:"bytes,4,opt,name=stderr,proto3" json:"stderr,omitempty"`
+	Stderr string `protobuf:"bytes,4,opt,name=stderr,proto3" json:"stderr,omitempty"`
+	// structured progress (retcode is -1 or -2), see TreeCallCtx.Progress()
+	Progress *Progress `protobuf:"bytes,5,opt,name=progress,proto3" json:"progress,omitempty"`
+	// a piece of binary stream (retcode is -1 or -2), see TreeCallCtx.StreamWriter()
+	Chunk                *Chunk   `protobuf:"bytes,6,opt,name=chunk,proto3" json:"chunk,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -178,32 +209,242 @@ func (m *Result) GetStderr() string {
 	return ""
 }
 
+func (m *Result) GetProgress() *Progress {
+	if m != nil {
+		return m.Progress
+	}
+	return nil
+}
+
+func (m *Result) GetChunk() *Chunk {
+	if m != nil {
+		return m.Chunk
+	}
+	return nil
+}
+
+// Progress of a call
+type Progress struct {
+	// 0 ~ 100, -1 if unknown
+	Percent float32 `protobuf:"fixed32,1,opt,name=percent,proto3" json:"percent,omitempty"`
+	// current step, 1-based, 0 if unknown
+	Step       int32  `protobuf:"varint,2,opt,name=step,proto3" json:"step,omitempty"`
+	TotalSteps int32  `protobuf:"varint,3,opt,name=total_steps,json=totalSteps,proto3" json:"total_steps,omitempty"`
+	Message    string `protobuf:"bytes,4,opt,name=message,proto3" json:"message,omitempty"`
+	// estimated seconds to complete, -1 if unknown
+	Eta                  int32    `protobuf:"varint,5,opt,name=eta,proto3" json:"eta,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Progress) Reset()         { *m = Progress{} }
+func (m *Progress) String() string { return proto.CompactTextString(m) }
+func (*Progress) ProtoMessage()    {}
+func (*Progress) Descriptor() ([]byte, []int) {
+	return fileDescriptor_c56ccb4321bcc0e5, []int{2}
+}
+
+func (m *Progress) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Progress.Unmarshal(m, b)
+}
+func (m *Progress) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Progress.Marshal(b, m, deterministic)
+}
+func (m *Progress) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Progress.Merge(m, src)
+}
+func (m *Progress) XXX_Size() int {
+	return xxx_messageInfo_Progress.Size(m)
+}
+func (m *Progress) XXX_DiscardUnknown() {
+	xxx_messageInfo_Progress.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Progress proto.InternalMessageInfo
+
+func (m *Progress) GetPercent() float32 {
+	if m != nil {
+		return m.Percent
+	}
+	return 0
+}
+
+func (m *Progress) GetStep() int32 {
+	if m != nil {
+		return m.Step
+	}
+	return 0
+}
+
+func (m *Progress) GetTotalSteps() int32 {
+	if m != nil {
+		return m.TotalSteps
+	}
+	return 0
+}
+
+func (m *Progress) GetMessage() string {
+	if m != nil {
+		return m.Message
+	}
+	return ""
+}
+
+func (m *Progress) GetEta() int32 {
+	if m != nil {
+		return m.Eta
+	}
+	return 0
+}
+
+// Chunk is a piece of binary stream uploaded by browser to a call, see TreeCallCtx.Upload().
+// For flow control, server grants how far the stream could be sent by a Chunk of limit,
+// browser sends data in order until limit, then waits for the next grant.
+// It is also a piece of binary stream sent by server in Result.chunk, see TreeCallCtx.StreamWriter().
+type Chunk struct {
+	// id of Command of the call
+	Id int32 `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	// offset of data in the stream
+	Offset int64  `protobuf:"varint,2,opt,name=offset,proto3" json:"offset,omitempty"`
+	Data   []byte `protobuf:"bytes,3,opt,name=data,proto3" json:"data,omitempty"`
+	// true if this is the last chunk
+	Eof bool `protobuf:"varint,4,opt,name=eof,proto3" json:"eof,omitempty"`
+	// by server, browser could send data until this offset
+	Limit int64 `protobuf:"varint,5,opt,name=limit,proto3" json:"limit,omitempty"`
+	// by browser, the upload is cancelled
+	Abort bool `protobuf:"varint,6,opt,name=abort,proto3" json:"abort,omitempty"`
+	// by server, MIME type of the stream, in the first chunk only
+	ContentType string `protobuf:"bytes,7,opt,name=content_type,json=contentType,proto3" json:"content_type,omitempty"`
+	// by server, total bytes of the stream in the first chunk, -1 if unknown
+	Size                 int64    `protobuf:"varint,8,opt,name=size,proto3" json:"size,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Chunk) Reset()         { *m = Chunk{} }
+func (m *Chunk) String() string { return proto.CompactTextString(m) }
+func (*Chunk) ProtoMessage()    {}
+func (*Chunk) Descriptor() ([]byte, []int) {
+	return fileDescriptor_c56ccb4321bcc0e5, []int{3}
+}
+
+func (m *Chunk) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Chunk.Unmarshal(m, b)
+}
+func (m *Chunk) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Chunk.Marshal(b, m, deterministic)
+}
+func (m *Chunk) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Chunk.Merge(m, src)
+}
+func (m *Chunk) XXX_Size() int {
+	return xxx_messageInfo_Chunk.Size(m)
+}
+func (m *Chunk) XXX_DiscardUnknown() {
+	xxx_messageInfo_Chunk.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Chunk proto.InternalMessageInfo
+
+func (m *Chunk) GetId() int32 {
+	if m != nil {
+		return m.Id
+	}
+	return 0
+}
+
+func (m *Chunk) GetOffset() int64 {
+	if m != nil {
+		return m.Offset
+	}
+	return 0
+}
+
+func (m *Chunk) GetData() []byte {
+	if m != nil {
+		return m.Data
+	}
+	return nil
+}
+
+func (m *Chunk) GetEof() bool {
+	if m != nil {
+		return m.Eof
+	}
+	return false
+}
+
+func (m *Chunk) GetLimit() int64 {
+	if m != nil {
+		return m.Limit
+	}
+	return 0
+}
+
+func (m *Chunk) GetAbort() bool {
+	if m != nil {
+		return m.Abort
+	}
+	return false
+}
+
+func (m *Chunk) GetContentType() string {
+	if m != nil {
+		return m.ContentType
+	}
+	return ""
+}
+
+func (m *Chunk) GetSize() int64 {
+	if m != nil {
+		return m.Size
+	}
+	return 0
+}
+
 func init() {
 	proto.RegisterType((*Command)(nil), "objsh.Command")
 	proto.RegisterMapType((map[string]string)(nil), "objsh.Command.KwEntry")
 	proto.RegisterType((*Result)(nil), "objsh.Result")
+	proto.RegisterType((*Progress)(nil), "objsh.Progress")
+	proto.RegisterType((*Chunk)(nil), "objsh.Chunk")
 }
 
 func init() { proto.RegisterFile("objshpb.proto", fileDescriptor_c56ccb4321bcc0e5) }
 
 var fileDescriptor_c56ccb4321bcc0e5 = []byte{
-	// 278 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x64, 0x8f, 0xcd, 0x4e, 0xc3, 0x30,
-	0x10, 0x84, 0x65, 0xe7, 0xaf, 0xdd, 0x02, 0x42, 0x56, 0x55, 0x99, 0x9e, 0xac, 0x1e, 0x90, 0x4f,
-	0xae, 0x54, 0x84, 0x84, 0xb8, 0x55, 0x88, 0x13, 0x37, 0xbf, 0x41, 0x42, 0x4c, 0x08, 0xf9, 0x71,
-	0x65, 0x3b, 0x44, 0x79, 0x5f, 0x1e, 0x04, 0xc5, 0x49, 0x4e, 0xbd, 0xcd, 0x37, 0xda, 0xdd, 0x99,
-	0x85, 0x5b, 0x9d, 0xfd, 0xd8, 0xef, 0x4b, 0x26, 0x2e, 0x46, 0x3b, 0x4d, 0x22, 0x8f, 0xfb, 0x87,
-	0x42, 0xeb, 0xa2, 0x56, 0x47, 0x6f, 0x66, 0xdd, 0xd7, 0xf1, 0xdc, 0x0e, 0xd3, 0xc4, 0xe1, 0x0f,
-	0x41, 0xf2, 0xa6, 0x9b, 0x26, 0x6d, 0x73, 0x72, 0x07, 0xb8, 0xcc, 0x29, 0x62, 0x88, 0x47, 0x12,
-	0x97, 0x39, 0x21, 0x10, 0xb6, 0x69, 0xa3, 0x28, 0x66, 0x88, 0xaf, 0xa5, 0xd7, 0xa3, 0x97, 0x9a,
-	0xc2, 0xd2, 0x80, 0x05, 0xa3, 0x37, 0x6a, 0xf2, 0x08, 0xb8, 0xea, 0x69, 0xc8, 0x02, 0xbe, 0x39,
-	0xed, 0x84, 0x8f, 0x14, 0xf3, 0x4d, 0xf1, 0xd1, 0xbf, 0xb7, 0xce, 0x0c, 0x12, 0x57, 0x3d, 0x11,
-	0x90, 0x34, 0xca, 0xda, 0xb4, 0x50, 0x34, 0x62, 0x88, 0x6f, 0x4e, 0x5b, 0x31, 0x15, 0x13, 0x4b,
-	0x31, 0x71, 0x6e, 0x07, 0xb9, 0x0c, 0x8d, 0x59, 0x55, 0x59, 0xd7, 0x74, 0xc5, 0x10, 0x5f, 0x49,
-	0xaf, 0xf7, 0xcf, 0x90, 0xcc, 0x27, 0xc9, 0x3d, 0x04, 0x95, 0x1a, 0x7c, 0xdf, 0xb5, 0x1c, 0x25,
-	0xd9, 0x42, 0xf4, 0x9b, 0xd6, 0xdd, 0xd2, 0x78, 0x82, 0x57, 0xfc, 0x82, 0x0e, 0x19, 0xc4, 0x52,
-	0xd9, 0xae, 0x76, 0x57, 0x4f, 0x52, 0x48, 0x8c, 0x72, 0x9f, 0x3a, 0x9f, 0xb6, 0x22, 0xb9, 0x20,
-	0xd9, 0x41, 0x6c, 0x5d, 0xae, 0x3b, 0x47, 0x03, 0x86, 0xf8, 0x8d, 0x9c, 0x69, 0xf6, 0x95, 0x31,
-	0x34, 0xf4, 0x31, 0x33, 0x65, 0xb1, 0xff, 0xe2, 0xe9, 0x3f, 0x00, 0x00, 0xff, 0xff, 0xab, 0x35,
-	0xe7, 0x89, 0x84, 0x01, 0x00, 0x00,
+	// 494 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x64, 0x52, 0x4d, 0x8f, 0xd3, 0x30,
+	0x10, 0x55, 0x92, 0xa6, 0x49, 0xa7, 0xe5, 0x43, 0xd6, 0xaa, 0x32, 0x7b, 0x21, 0xf4, 0x80, 0x22,
+	0x21, 0x65, 0xa5, 0x45, 0x48, 0x88, 0x1b, 0x42, 0x9c, 0xb8, 0x20, 0xc3, 0x7d, 0xe5, 0x36, 0xd3,
+	0x6e, 0x68, 0x12, 0x47, 0xb6, 0x43, 0x15, 0xee, 0xfc, 0x1b, 0x6e, 0x5c, 0xf8, 0x79, 0xc8, 0x13,
+	0x7b, 0x2f, 0x7b, 0x7b, 0xef, 0x65, 0x32, 0xf3, 0xe6, 0x79, 0xe0, 0x89, 0xda, 0xff, 0x30, 0xf7,
+	0xc3, 0xbe, 0x1a, 0xb4, 0xb2, 0x8a, 0xa5, 0x44, 0xaf, 0x5f, 0x9c, 0x94, 0x3a, 0xb5, 0x78, 0x43,
+	0xe2, 0x7e, 0x3c, 0xde, 0xc8, 0x7e, 0x9a, 0x2b, 0x76, 0x7f, 0x62, 0xc8, 0x3e, 0xa9, 0xae, 0x93,
+	0x7d, 0xcd, 0x9e, 0x42, 0xdc, 0xd4, 0x3c, 0x2a, 0xa2, 0x32, 0x15, 0x71, 0x53, 0x33, 0x06, 0x8b,
+	0x5e, 0x76, 0xc8, 0xe3, 0x22, 0x2a, 0x57, 0x82, 0xb0, 0xd3, 0xa4, 0x3e, 0x19, 0x9e, 0x14, 0x89,
+	0xd3, 0x1c, 0x66, 0xaf, 0x21, 0x3e, 0x5f, 0xf8, 0xa2, 0x48, 0xca, 0xf5, 0xed, 0xb6, 0xa2, 0x91,
+	0x95, 0xef, 0x59, 0x7d, 0xb9, 0x7c, 0xee, 0xad, 0x9e, 0x44, 0x7c, 0xbe, 0xb0, 0x0a, 0xb2, 0x0e,
+	0x8d, 0x91, 0x27, 0xe4, 0x69, 0x11, 0x95, 0xeb, 0xdb, 0xab, 0x6a, 0x36, 0x56, 0x05, 0x63, 0xd5,
+	0xc7, 0x7e, 0x12, 0xa1, 0xc8, 0xcd, 0x3a, 0x37, 0x6d, 0xcb, 0xf3, 0x22, 0x2a, 0x73, 0x41, 0x98,
+	0x71, 0xc8, 0x6c, 0xd3, 0xa1, 0x1a, 0x2d, 0x5f, 0x91, 0xd1, 0x40, 0xd9, 0x15, 0xa4, 0x83, 0x1c,
+	0x0d, 0x72, 0xa0, 0xf2, 0x99, 0xb0, 0x2d, 0x2c, 0x35, 0x9a, 0xb1, 0x43, 0xbe, 0x26, 0xd9, 0xb3,
+	0xeb, 0x77, 0x90, 0x79, 0x6b, 0xec, 0x39, 0x24, 0x67, 0x9c, 0x68, 0xef, 0x95, 0x70, 0xd0, 0xb5,
+	0xfa, 0x29, 0xdb, 0x31, 0x6c, 0x3e, 0x93, 0x0f, 0xf1, 0xfb, 0x68, 0xf7, 0x37, 0x82, 0xa5, 0x40,
+	0x33, 0xb6, 0xf6, 0x51, 0x5a, 0x1c, 0x32, 0x8d, 0xf6, 0xa0, 0xea, 0xf9, 0xb7, 0x54, 0x04, 0xea,
+	0x3c, 0x18, 0x5b, 0x3b, 0xcb, 0x49, 0x11, 0x95, 0x1b, 0xe1, 0x99, 0xd7, 0x51, 0x6b, 0xbe, 0xa0,
+	0x39, 0x9e, 0xb1, 0x37, 0x90, 0x0f, 0x5a, 0x9d, 0x34, 0x1a, 0xe3, 0x83, 0x7a, 0xe6, 0x53, 0xfd,
+	0xea, 0x65, 0xf1, 0x50, 0xc0, 0x76, 0x90, 0x1e, 0xee, 0xc7, 0xfe, 0xcc, 0x97, 0x54, 0xb9, 0x09,
+	0xf9, 0x3b, 0x4d, 0xcc, 0x9f, 0x76, 0xbf, 0x23, 0xc8, 0xc3, 0xaf, 0xce, 0xe7, 0x80, 0xfa, 0x80,
+	0xbd, 0x25, 0xf3, 0xb1, 0x08, 0xd4, 0xe5, 0x6d, 0x2c, 0x0e, 0xde, 0x3e, 0x61, 0xf6, 0x12, 0xd6,
+	0x56, 0x59, 0xd9, 0xde, 0x39, 0x66, 0x68, 0x81, 0x54, 0x00, 0x49, 0xdf, 0x9c, 0xe2, 0xda, 0x85,
+	0x47, 0x9d, 0xb7, 0x08, 0xd4, 0xe5, 0x8a, 0x56, 0xd2, 0x06, 0xa9, 0x70, 0x70, 0xf7, 0x2f, 0x82,
+	0x94, 0x8c, 0x3d, 0x0a, 0x6f, 0x0b, 0x4b, 0x75, 0x3c, 0x1a, 0xb4, 0x34, 0x3c, 0x11, 0x9e, 0x39,
+	0x4b, 0xb5, 0xb4, 0xd2, 0x07, 0x47, 0x98, 0xfa, 0xaa, 0x23, 0x4d, 0xcb, 0x85, 0x83, 0xee, 0xbd,
+	0xda, 0xa6, 0x6b, 0x2c, 0xcd, 0x4a, 0xc4, 0x4c, 0x9c, 0x2a, 0xf7, 0x4a, 0x5b, 0x4a, 0x26, 0x17,
+	0x33, 0x61, 0xaf, 0x60, 0x73, 0x50, 0xbd, 0xc5, 0xde, 0xde, 0xd9, 0x69, 0x40, 0x9e, 0x91, 0xe9,
+	0xb5, 0xd7, 0xbe, 0x4f, 0x03, 0xdd, 0x9d, 0x69, 0x7e, 0x21, 0xdd, 0x5d, 0x22, 0x08, 0xef, 0x97,
+	0x74, 0xa2, 0x6f, 0xff, 0x0f, 0x00, 0x75, 0x25, 0x75, 0xa8, 0x61, 0x03, 0x00, 0x00,
 }
@@ -0,0 +1,200 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+
+	"github.com/dgrr/fastws"
+)
+
+// overflow policies of WebsocketOptions
+const (
+	// the oldest notify (a Result of retcode < 0, without chunk) in queue is dropped,
+	// the connection is closed if there is no notify to drop
+	OverflowDropNotify = "drop-notify"
+	// the connection is closed, a slow peer is usually gone
+	OverflowClose = "close"
+)
+
+// default size of outbound queue of a websocket
+const defaultQueueSize = 256
+
+// outbound is a message waiting to be written
+type outbound struct {
+	mode fastws.Mode
+	data []byte
+	// true if it could be dropped when the queue is full, see OverflowDropNotify
+	droppable bool
+	// a control frame (ex. ping) if it is not CodeContinuation, data is ignored
+	control fastws.Code
+}
+
+// outbox serializes writes of a websocket by a single writer goroutine,
+// so that calls running in their own goroutines do not race on the connection.
+type outbox struct {
+	ws     *WebsocketCtx
+	conn   *fastws.Conn
+	queue  []*outbound
+	size   int
+	policy string
+	// true after the writer has been started
+	started bool
+	stopped bool
+	mutex   sync.Mutex
+	cond    *sync.Cond
+	// signaled when a message is taken by the writer, see waitRoom()
+	room *sync.Cond
+}
+
+func newOutbox(ws *WebsocketCtx, conn *fastws.Conn, options *WebsocketOptions) *outbox {
+	box := &outbox{ws: ws, conn: conn, size: defaultQueueSize, policy: OverflowDropNotify}
+	if options != nil {
+		if options.QueueSize > 0 {
+			box.size = options.QueueSize
+		}
+		if options.Overflow != "" {
+			box.policy = options.Overflow
+		}
+	}
+	box.cond = sync.NewCond(&box.mutex)
+	box.room = sync.NewCond(&box.mutex)
+	return box
+}
+
+// push queues a message, returns error if the queue is full and the connection should be closed
+func (box *outbox) push(message *outbound) error {
+	box.mutex.Lock()
+	defer box.mutex.Unlock()
+	if box.stopped {
+		return errors.New("disconnected")
+	}
+	if len(box.queue) >= box.size {
+		idx := -1
+		if box.policy == OverflowDropNotify {
+			for i, queued := range box.queue {
+				if queued.droppable {
+					idx = i
+					break
+				}
+			}
+		}
+		if idx == -1 {
+			Metrics.Incr("websocket.queue.overflow")
+			return errors.New("outbound queue is full")
+		}
+		box.queue = append(box.queue[:idx], box.queue[idx+1:]...)
+		Metrics.Add("websocket.queue.depth", -1)
+		Metrics.Incr("websocket.queue.dropped")
+	}
+	box.enqueue(message)
+	return nil
+}
+
+// ping queues a ping, it is skipped if the queue is full since the peer has a lot to receive
+func (box *outbox) ping() error {
+	box.mutex.Lock()
+	defer box.mutex.Unlock()
+	if box.stopped {
+		return errors.New("disconnected")
+	}
+	if len(box.queue) >= box.size {
+		Metrics.Incr("websocket.ping.skipped")
+		return nil
+	}
+	box.enqueue(&outbound{control: fastws.CodePing})
+	return nil
+}
+
+// enqueue appends message and wakes up the writer, caller should hold box.mutex
+func (box *outbox) enqueue(message *outbound) {
+	box.queue = append(box.queue, message)
+	Metrics.Add("websocket.queue.depth", 1)
+	if !box.started {
+		box.started = true
+		go box.write()
+	}
+	box.cond.Signal()
+}
+
+// write is the only goroutine which writes to the connection
+func (box *outbox) write() {
+	for {
+		box.mutex.Lock()
+		for len(box.queue) == 0 && !box.stopped {
+			box.cond.Wait()
+		}
+		if box.stopped {
+			box.mutex.Unlock()
+			return
+		}
+		message := box.queue[0]
+		box.queue[0] = nil
+		box.queue = box.queue[1:]
+		box.room.Broadcast()
+		box.mutex.Unlock()
+		Metrics.Add("websocket.queue.depth", -1)
+
+		if message.control != fastws.CodeContinuation {
+			if err := box.conn.SendCode(message.control, 0, nil); err != nil {
+				box.ws.errorOnIO(err)
+				return
+			}
+			continue
+		}
+		size, err := box.conn.WriteMessage(message.mode, message.data)
+		if err != nil {
+			box.ws.errorOnIO(err)
+			return
+		}
+		atomic.AddInt64(&box.ws.bytesOut, int64(size))
+	}
+}
+
+// stop discards queued messages and ends the writer
+func (box *outbox) stop() {
+	box.mutex.Lock()
+	if n := len(box.queue); n > 0 {
+		log.Println("websocket closed with", n, "messages not sent")
+		Metrics.Add("websocket.queue.depth", -int64(n))
+	}
+	box.queue = nil
+	box.stopped = true
+	box.cond.Broadcast()
+	box.room.Broadcast()
+	box.mutex.Unlock()
+}
+
+// waitRoom blocks until the queue is less than half full, so that a bulk sender
+// leaves room for other messages instead of overflowing the queue
+func (box *outbox) waitRoom(ctx context.Context) error {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			box.mutex.Lock()
+			box.room.Broadcast()
+			box.mutex.Unlock()
+		case <-done:
+		}
+	}()
+	box.mutex.Lock()
+	defer box.mutex.Unlock()
+	for len(box.queue) >= (box.size+1)/2 && !box.stopped && ctx.Err() == nil {
+		box.room.Wait()
+	}
+	if box.stopped {
+		return errors.New("disconnected")
+	}
+	return ctx.Err()
+}
+
+// depth returns number of queued messages
+func (box *outbox) depth() int {
+	box.mutex.Lock()
+	defer box.mutex.Unlock()
+	return len(box.queue)
+}
@@ -0,0 +1,139 @@
+package model
+
+import (
+	"errors"
+	"strings"
+)
+
+// Pausable makes this call could be paused and resumed by its user (see PausePeer).
+// A pausable call should check WaitIfPaused() at safe points, or listen to On("Pause") and On("Resume").
+//
+//	func (self *DataBranch) Import(ctx *TreeCallCtx) {
+//		ctx.SetBackground(true)
+//		ctx.Pausable()
+//		for _, row := range rows {
+//			if err := ctx.WaitIfPaused(); err != nil {
+//				return // killed or timed out while paused
+//			}
+//			...
+//		}
+//		ctx.Resolve(len(rows))
+//	}
+func (tcCtx *TreeCallCtx) Pausable() {
+	tcCtx.mutex.Lock()
+	tcCtx.pausable = true
+	tcCtx.mutex.Unlock()
+	// a paused call is still killable
+	tcCtx.killable()
+}
+
+// Pause holds this call, it is notified as {"paused":true}. The call goes on until it checks WaitIfPaused().
+func (tcCtx *TreeCallCtx) Pause() error {
+	if tcCtx.IsFinished() {
+		return errors.New("call has been finished")
+	}
+	tcCtx.mutex.Lock()
+	if !tcCtx.pausable {
+		tcCtx.mutex.Unlock()
+		return errors.New("call is not pausable")
+	}
+	if tcCtx.resumed != nil {
+		// already paused
+		tcCtx.mutex.Unlock()
+		return nil
+	}
+	tcCtx.resumed = make(chan struct{})
+	listeners := tcCtx.pauseListener
+	tcCtx.mutex.Unlock()
+
+	Metrics.Incr("treecall.pause")
+	tcCtx.Notify(map[string]interface{}{"paused": true})
+	for _, fn := range listeners {
+		fn()
+	}
+	return nil
+}
+
+// Resume continues a paused call, it is notified as {"paused":false}
+func (tcCtx *TreeCallCtx) Resume() error {
+	if tcCtx.IsFinished() {
+		return errors.New("call has been finished")
+	}
+	tcCtx.mutex.Lock()
+	if tcCtx.resumed == nil {
+		// not paused
+		tcCtx.mutex.Unlock()
+		return nil
+	}
+	close(tcCtx.resumed)
+	tcCtx.resumed = nil
+	listeners := tcCtx.resumeListener
+	tcCtx.mutex.Unlock()
+
+	tcCtx.Notify(map[string]interface{}{"paused": false})
+	for _, fn := range listeners {
+		fn()
+	}
+	return nil
+}
+
+// IsPaused tells if this call has been paused
+func (tcCtx *TreeCallCtx) IsPaused() bool {
+	tcCtx.mutex.RLock()
+	defer tcCtx.mutex.RUnlock()
+	return tcCtx.resumed != nil
+}
+
+// WaitIfPaused is a checkpoint of a pausable call. It blocks while this call is paused,
+// and returns the error of Context() if this call is killed or timed out.
+func (tcCtx *TreeCallCtx) WaitIfPaused() error {
+	ctx := tcCtx.Context()
+	tcCtx.mutex.RLock()
+	resumed := tcCtx.resumed
+	tcCtx.mutex.RUnlock()
+	if resumed != nil {
+		select {
+		case <-resumed:
+		case <-ctx.Done():
+		}
+	}
+	return ctx.Err()
+}
+
+// PausePeer pauses a call of the same user, see Pausable()
+func (tcCtx *TreeCallCtx) PausePeer(cmdID int32) error {
+	peer, err := tcCtx.peerOf(cmdID)
+	if err != nil {
+		return err
+	}
+	return peer.Pause()
+}
+
+// ResumePeer resumes a call of the same user which was paused by PausePeer
+func (tcCtx *TreeCallCtx) ResumePeer(cmdID int32) error {
+	peer, err := tcCtx.peerOf(cmdID)
+	if err != nil {
+		return err
+	}
+	return peer.Resume()
+}
+
+// peerOf returns a call in Root.Bank which is owned by the user of this call
+func (tcCtx *TreeCallCtx) peerOf(cmdID int32) (*TreeCallCtx, error) {
+	peer := tcCtx.Root.Bank.Get(cmdID)
+	if peer == nil {
+		return nil, errors.New("not found")
+	}
+	username := ""
+	if user := tcCtx.WsCtx.GetUser(); user != nil {
+		username = user.Username()
+	}
+	owner := ""
+	if parts := strings.SplitN(peer.CmdPath, "\t", 2); len(parts) == 2 {
+		owner = parts[1]
+	}
+	if owner != username {
+		return nil, errors.New("not found")
+	}
+	return peer, nil
+}
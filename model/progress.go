@@ -0,0 +1,85 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// NewProgress returns a progress of percent (0 ~ 100) with unknown steps and ETA
+func NewProgress(percent float32, message string) *Progress {
+	return &Progress{Percent: percent, Message: message, Eta: -1}
+}
+
+// Progress reports structured progress of this call to the caller and hooked listeners (retcode -1 or -2).
+// Unlike Notify(), browsers get it by deferred.report(callback) of sdk.js. The latest progress is kept,
+// so $.ListUserTasks can show it.
+//
+//	ctx.Progress(&model.Progress{Percent: 40, Step: 2, TotalSteps: 5, Message: "building", Eta: -1})
+//
+// If Percent is 0 and steps are given, it is computed by steps.
+// If Eta is -1 and Percent is in (0, 100), it is estimated by the elapsed time since Ctime.
+func (tcCtx *TreeCallCtx) Progress(progress *Progress) {
+	p := &Progress{
+		Percent:    progress.Percent,
+		Step:       progress.Step,
+		TotalSteps: progress.TotalSteps,
+		Message:    progress.Message,
+		Eta:        progress.Eta,
+	}
+	if p.Percent == 0 && p.TotalSteps > 0 && p.Step > 0 {
+		p.Percent = float32(p.Step-1) * 100 / float32(p.TotalSteps)
+	}
+	if p.Eta < 0 && p.Percent > 0 && p.Percent < 100 && tcCtx.Ctime > 0 {
+		elapsed := time.Now().Unix() - int64(tcCtx.Ctime)
+		p.Eta = int32(float32(elapsed) * (100 - p.Percent) / p.Percent)
+	}
+	tcCtx.mutex.Lock()
+	tcCtx.progress = p
+	throttle := tcCtx.throttle
+	tcCtx.mutex.Unlock()
+	if throttle != nil {
+		// coalesced and sent later, see SetNotifyPolicy()
+		throttle.addProgress(p)
+		return
+	}
+	tcCtx.sendProgress(p)
+}
+
+func (tcCtx *TreeCallCtx) sendProgress(p *Progress) {
+	tcCtx.promise.Progress(p, tcCtx.RetcodeOfNotify)
+	tcCtx.observe(&TreeCallReturn{CmdID: tcCtx.CmdID, Retcode: tcCtx.RetcodeOfNotify, Progress: p})
+}
+
+// ProgressStep reports the step-th (1-based) of total steps is started
+func (tcCtx *TreeCallCtx) ProgressStep(step int, total int, message string) {
+	tcCtx.Progress(&Progress{Step: int32(step), TotalSteps: int32(total), Message: message, Eta: -1})
+}
+
+// LastProgress returns the latest progress reported by Progress(), nil if none
+func (tcCtx *TreeCallCtx) LastProgress() *Progress {
+	tcCtx.mutex.RLock()
+	defer tcCtx.mutex.RUnlock()
+	return tcCtx.progress
+}
+
+// FormatProgress returns a readable progress, ex. "40% step 2/5 building (eta 1m30s)", "" for nil
+func FormatProgress(p *Progress) string {
+	if p == nil {
+		return ""
+	}
+	parts := make([]string, 0, 4)
+	if p.Percent >= 0 {
+		parts = append(parts, fmt.Sprintf("%.0f%%", p.Percent))
+	}
+	if p.TotalSteps > 0 {
+		parts = append(parts, fmt.Sprintf("step %d/%d", p.Step, p.TotalSteps))
+	}
+	if p.Message != "" {
+		parts = append(parts, p.Message)
+	}
+	if p.Eta >= 0 {
+		parts = append(parts, "(eta "+(time.Duration(p.Eta)*time.Second).String()+")")
+	}
+	return strings.Join(parts, " ")
+}
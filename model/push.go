@@ -0,0 +1,170 @@
+package model
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GroupChecker tells if user is in group, it could be replaced by application.
+// By default, groups of a user are in its metadata "groups", separated by comma.
+var GroupChecker = func(user User, group string) bool {
+	if user == nil {
+		return false
+	}
+	groups, ok := user.GetMetadata("groups")
+	if !ok {
+		return false
+	}
+	for _, g := range strings.Split(groups, ",") {
+		if strings.TrimSpace(g) == group {
+			return true
+		}
+	}
+	return false
+}
+
+// PushMessage is an unsolicited message from server, it is sent as a Result of id 0 in JSON
+type PushMessage struct {
+	Topic   string      `json:"topic"`
+	Payload interface{} `json:"payload"`
+	// timestamp of sending
+	Ts int64 `json:"ts"`
+}
+
+// pushConn is a connection of the tree and its subscribed topics
+type pushConn struct {
+	listener PromiseStateListener
+	topics   map[string]bool
+}
+
+// PushHub sends announcements to connections of a tree, see TreeRoot.Push.
+// A message of a topic is only sent to connections which have subscribed the topic
+// (by $push.Subscribe or Subscribe()), a message of empty topic is sent to all target connections.
+//
+//	Root.Push.ToUser("alice", "report", map[string]string{"file": "2019-12.pdf"})
+//	Root.Push.ToGroup("ops", "alert", "disk is full")
+//	Root.Push.Broadcast("", "server will restart in 5 minutes")
+type PushHub struct {
+	conns map[PromiseStateListener]*pushConn
+	mutex sync.RWMutex
+}
+
+func NewPushHub() *PushHub {
+	return &PushHub{conns: make(map[PromiseStateListener]*pushConn)}
+}
+
+// Register adds a connection, it is removed when the connection is closed
+func (hub *PushHub) Register(listener PromiseStateListener) {
+	hub.mutex.Lock()
+	if _, ok := hub.conns[listener]; ok {
+		hub.mutex.Unlock()
+		return
+	}
+	hub.conns[listener] = &pushConn{listener: listener, topics: make(map[string]bool)}
+	hub.mutex.Unlock()
+	listener.On("Close", "_push", func() {
+		hub.Unregister(listener)
+	})
+}
+
+// Unregister removes a connection
+func (hub *PushHub) Unregister(listener PromiseStateListener) {
+	hub.mutex.Lock()
+	delete(hub.conns, listener)
+	hub.mutex.Unlock()
+}
+
+// Subscribe lets a connection receive messages of topics
+func (hub *PushHub) Subscribe(listener PromiseStateListener, topics ...string) error {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+	conn, ok := hub.conns[listener]
+	if !ok {
+		return errors.New("not connected")
+	}
+	for _, topic := range topics {
+		conn.topics[topic] = true
+	}
+	return nil
+}
+
+// Unsubscribe stops a connection from receiving messages of topics
+func (hub *PushHub) Unsubscribe(listener PromiseStateListener, topics ...string) error {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+	conn, ok := hub.conns[listener]
+	if !ok {
+		return errors.New("not connected")
+	}
+	for _, topic := range topics {
+		delete(conn.topics, topic)
+	}
+	return nil
+}
+
+// Topics returns subscribed topics of a connection
+func (hub *PushHub) Topics(listener PromiseStateListener) []string {
+	hub.mutex.RLock()
+	defer hub.mutex.RUnlock()
+	ret := make([]string, 0)
+	if conn, ok := hub.conns[listener]; ok {
+		for topic := range conn.topics {
+			ret = append(ret, topic)
+		}
+	}
+	return ret
+}
+
+// ToUser sends a message to all connections of a user, returns the number of connections sent to
+func (hub *PushHub) ToUser(username string, topic string, payload interface{}) int {
+	return hub.send(topic, payload, func(user User) bool {
+		return user != nil && user.Username() == username
+	})
+}
+
+// ToGroup sends a message to all connections of users in group, see GroupChecker
+func (hub *PushHub) ToGroup(group string, topic string, payload interface{}) int {
+	return hub.send(topic, payload, func(user User) bool {
+		return GroupChecker(user, group)
+	})
+}
+
+// Broadcast sends a message to all connections of the tree, including guests
+func (hub *PushHub) Broadcast(topic string, payload interface{}) int {
+	return hub.send(topic, payload, func(user User) bool {
+		return true
+	})
+}
+
+func (hub *PushHub) send(topic string, payload interface{}, match func(User) bool) int {
+	hub.mutex.RLock()
+	targets := make([]PromiseStateListener, 0)
+	for listener, conn := range hub.conns {
+		if topic != "" && !conn.topics[topic] {
+			continue
+		}
+		if match(listener.GetUser()) {
+			targets = append(targets, listener)
+		}
+	}
+	hub.mutex.RUnlock()
+
+	ret := &TreeCallReturn{
+		CmdID:   0,
+		Retcode: 0,
+		Stdout:  &PushMessage{Topic: topic, Payload: payload, Ts: time.Now().Unix()},
+	}
+	count := 0
+	for _, listener := range targets {
+		if listener.IsClosed() {
+			continue
+		}
+		if _, err := listener.SendTreeCallReturn(ret); err == nil {
+			count++
+		}
+	}
+	Metrics.Add("push.sent", int64(count))
+	return count
+}
@@ -0,0 +1,302 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/google/uuid"
+)
+
+// sessionTopic is the topic of the message which tells browser its session id, see SessionResumer.Attach()
+const sessionTopic = "$session"
+
+// ResumableSession stands for a browser across reconnections of its websocket.
+// Calls issued through a session take it as their WsCtx instead of the websocket,
+// so when the websocket is dropped, foreground calls keep running for a grace period
+// and their results are buffered. The buffered results are sent in order when the browser
+// reconnects with ?resume=<session id>. If the browser does not come back in time,
+// the session expires and its Close listeners kill foreground calls as a closed websocket does.
+type ResumableSession struct {
+	ID   string
+	user User
+	uuid string
+	// current websocket, nil while detached
+	ws            *WebsocketCtx
+	closeListener map[string]CloseEventHandler
+	// messages sent while detached
+	buffer  []proto.Message
+	timer   *time.Timer
+	expired bool
+	resumer *SessionResumer
+	mutex   sync.Mutex
+}
+
+// SessionResumer keeps resumable sessions of a tree, see TreeRoot.Resumer
+type SessionResumer struct {
+	// how long a detached session waits for reconnection, 0 to disable resuming
+	Grace time.Duration
+	// max number of buffered messages of a detached session, the session expires when it is exceeded
+	MaxBuffered int
+	sessions    map[string]*ResumableSession
+	mutex       sync.Mutex
+}
+
+func NewSessionResumer() *SessionResumer {
+	return &SessionResumer{
+		Grace:       30 * time.Second,
+		MaxBuffered: 1000,
+		sessions:    make(map[string]*ResumableSession),
+	}
+}
+
+// Attach binds wsCtx to the session of sid and sends its buffered messages,
+// returns true if the session is resumed. A new session is created if sid is empty,
+// not found, expired or owned by another user.
+// Browser is told the session id by a message of topic "$session" of id 0.
+func (r *SessionResumer) Attach(wsCtx *WebsocketCtx, sid string) (*ResumableSession, bool) {
+	if sid != "" {
+		r.mutex.Lock()
+		session := r.sessions[sid]
+		r.mutex.Unlock()
+		if session != nil && session.ownedBy(wsCtx) && session.attach(wsCtx, true) {
+			Metrics.Incr("session.resumed")
+			return session, true
+		}
+	}
+	session := &ResumableSession{
+		ID:            uuid.New().String(),
+		user:          wsCtx.User,
+		uuid:          wsCtx.UUID,
+		closeListener: make(map[string]CloseEventHandler),
+		resumer:       r,
+	}
+	r.mutex.Lock()
+	r.sessions[session.ID] = session
+	r.mutex.Unlock()
+	session.attach(wsCtx, false)
+	return session, false
+}
+
+// Get returns the session of sid, nil if not found
+func (r *SessionResumer) Get(sid string) *ResumableSession {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.sessions[sid]
+}
+
+func (r *SessionResumer) remove(sid string) {
+	r.mutex.Lock()
+	delete(r.sessions, sid)
+	r.mutex.Unlock()
+}
+
+// ownedBy tells if wsCtx is connected by the same user (or the same traced guest) as this session
+func (s *ResumableSession) ownedBy(wsCtx *WebsocketCtx) bool {
+	if s.user != nil || wsCtx.User != nil {
+		return s.user != nil && wsCtx.User != nil && s.user.Username() == wsCtx.User.Username()
+	}
+	return s.uuid == wsCtx.UUID
+}
+
+func (s *ResumableSession) attach(ws *WebsocketCtx, resumed bool) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.expired {
+		return false
+	}
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	if old := s.ws; old != nil && old != ws {
+		// taken over by a new websocket before the old one is found dead
+		go old.Close()
+	}
+	s.ws = ws
+	ws.mutex.Lock()
+	ws.session = s
+	ws.mutex.Unlock()
+	// detach in another goroutine, since Close() of ws might be called while sending with s.mutex held
+	ws.On("Close", "_resume", func() {
+		go s.detach(ws)
+	})
+	ws.SendTreeCallReturn(&TreeCallReturn{
+		CmdID:   0,
+		Retcode: 0,
+		Stdout: &PushMessage{
+			Topic:   sessionTopic,
+			Payload: map[string]interface{}{"id": s.ID, "resumed": resumed, "buffered": len(s.buffer)},
+			Ts:      time.Now().Unix(),
+		},
+	})
+	for i, message := range s.buffer {
+		if _, err := ws.SendProtobufMessage(message); err != nil {
+			// keep the rest for next reconnection
+			s.buffer = s.buffer[i:]
+			return true
+		}
+	}
+	s.buffer = nil
+	return true
+}
+
+// detach starts waiting for reconnection after ws is closed
+func (s *ResumableSession) detach(ws *WebsocketCtx) {
+	s.mutex.Lock()
+	if s.ws != ws || s.expired {
+		s.mutex.Unlock()
+		return
+	}
+	s.ws = nil
+	grace := s.resumer.Grace
+	if grace > 0 {
+		s.timer = time.AfterFunc(grace, s.expire)
+	}
+	s.mutex.Unlock()
+	if grace <= 0 {
+		s.expire()
+	}
+}
+
+// expire fires Close listeners of a detached session, foreground calls of it are killed
+func (s *ResumableSession) expire() {
+	s.mutex.Lock()
+	if s.expired || s.ws != nil {
+		s.mutex.Unlock()
+		return
+	}
+	s.expireLocked()
+}
+
+// terminate expires the session attached to ws at once, it is not resumable anymore.
+// Returns false if the session has expired or been taken over by another websocket.
+func (s *ResumableSession) terminate(ws *WebsocketCtx) bool {
+	s.mutex.Lock()
+	if s.expired || (s.ws != nil && s.ws != ws) {
+		s.mutex.Unlock()
+		return false
+	}
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	s.ws = nil
+	s.expireLocked()
+	return true
+}
+
+// expireLocked is called with s.mutex held, which is released
+func (s *ResumableSession) expireLocked() {
+	s.expired = true
+	listeners := s.closeListener
+	s.closeListener = nil
+	s.buffer = nil
+	s.mutex.Unlock()
+	s.resumer.remove(s.ID)
+	Metrics.Incr("session.expired")
+	for _, fn := range listeners {
+		fn()
+	}
+}
+
+// Websocket returns the current websocket, nil while detached
+func (s *ResumableSession) Websocket() *WebsocketCtx {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.ws
+}
+
+func (s *ResumableSession) GetUser() User {
+	return s.user
+}
+func (s *ResumableSession) traceID() string {
+	return s.uuid
+}
+func (s *ResumableSession) GenID() string {
+	return s.ID
+}
+func (s *ResumableSession) IsClosed() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.expired
+}
+
+// WaitWritable implements FlowController by the websocket attached,
+// messages are buffered without waiting while detached
+func (s *ResumableSession) WaitWritable(ctx context.Context) error {
+	s.mutex.Lock()
+	ws := s.ws
+	s.mutex.Unlock()
+	if ws == nil || ws.IsClosed() {
+		return nil
+	}
+	return ws.WaitWritable(ctx)
+}
+
+// CarriesChunks implements ChunkCarrier, Chunks are sent by the websocket attached
+func (s *ResumableSession) CarriesChunks() bool {
+	return true
+}
+
+// On accepts "Close" only, which is fired when the session expires
+func (s *ResumableSession) On(evtName string, token string, fn interface{}, args ...interface{}) bool {
+	if evtName != "Close" {
+		log.Println("session has no event", evtName)
+		return false
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.expired {
+		return false
+	}
+	if _, ok := s.closeListener[token]; ok {
+		return false
+	}
+	s.closeListener[token] = fn.(CloseEventHandler)
+	return true
+}
+func (s *ResumableSession) Off(evtName string, token string) bool {
+	if evtName != "Close" {
+		return false
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.closeListener, token)
+	return true
+}
+func (s *ResumableSession) SendTreeCallReturn(ret *TreeCallReturn) (int, error) {
+	return s.SendProtobufMessage(ResultOf(ret))
+}
+
+// SendProtobufMessage sends to the current websocket, or buffers message while detached
+func (s *ResumableSession) SendProtobufMessage(message proto.Message) (int, error) {
+	s.mutex.Lock()
+	if s.expired {
+		s.mutex.Unlock()
+		return 0, errors.New("session expired")
+	}
+	if s.ws != nil && !s.ws.IsClosed() {
+		if n, err := s.ws.SendProtobufMessage(message); err == nil {
+			s.mutex.Unlock()
+			return n, nil
+		}
+	}
+	overflow := len(s.buffer) >= s.resumer.MaxBuffered
+	if overflow {
+		// give up the broken websocket (if any) too
+		s.ws = nil
+	} else {
+		s.buffer = append(s.buffer, message)
+	}
+	s.mutex.Unlock()
+	if overflow {
+		log.Println("session", s.ID, "expired due to too many buffered messages")
+		s.expire()
+		return 0, errors.New("session expired")
+	}
+	return 0, nil
+}
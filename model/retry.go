@@ -0,0 +1,305 @@
+package model
+
+import (
+	"errors"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RetryPolicy tells how a background call of an exportable is retried when it is rejected.
+// The exportable is called again with the same TreeCallCtx, see TreeCallCtx.Attempts().
+// A job of Scheduler is run again in its worker pool instead, see JobOptions.Retry.
+type RetryPolicy struct {
+	// max number of calls including the first one
+	MaxAttempts int
+	// delay before the 1st retry, default is 1 second
+	Backoff time.Duration
+	// delay is multiplied by this for every retry, default is 2
+	Multiplier float64
+	// max delay, 0 for no limit
+	MaxBackoff time.Duration
+	// retcodes which are retried, empty for all.
+	// A killed or timed out call is never retried.
+	Retcodes []int32
+}
+
+// delayOf returns the delay before calling the attempt-th (2, 3 ...) time
+func (policy *RetryPolicy) delayOf(attempt int) time.Duration {
+	backoff, multiplier := policy.Backoff, policy.Multiplier
+	if backoff == 0 {
+		backoff = time.Second
+	}
+	if multiplier == 0 {
+		multiplier = 2
+	}
+	delay := time.Duration(float64(backoff) * math.Pow(multiplier, float64(attempt-2)))
+	if policy.MaxBackoff > 0 && delay > policy.MaxBackoff {
+		delay = policy.MaxBackoff
+	}
+	return delay
+}
+
+func (policy *RetryPolicy) retryable(retcode int32) bool {
+	if retcode == RetcodeKilled || retcode == RetcodeTimeout {
+		return false
+	}
+	if len(policy.Retcodes) == 0 {
+		return true
+	}
+	for _, code := range policy.Retcodes {
+		if code == retcode {
+			return true
+		}
+	}
+	return false
+}
+
+// DeadLetter is a background call which is failed permanently
+type DeadLetter struct {
+	ID       int64             `json:"id"`
+	CmdID    int32             `json:"cmdID"`
+	Path     string            `json:"path"`
+	Username string            `json:"username"`
+	Args     []string          `json:"args,omitempty"`
+	Kw       map[string]string `json:"kw,omitempty"`
+	Retcode  int32             `json:"retcode"`
+	Error    string            `json:"error"`
+	Attempts int               `json:"attempts"`
+	Ctime    uint32            `json:"ctime"`
+	// time of failure
+	Mtime int64 `json:"mtime"`
+}
+
+// RetryManager keeps retry policies of exportables and the dead-letter list of the tree.
+//
+//	Root.Retry.SetPolicy("reports.Daily", &model.RetryPolicy{MaxAttempts: 5, Backoff: 10 * time.Second})
+//
+// A background call (see TreeCallCtx.SetBackground) which is rejected with a retryable retcode
+// is called again after backoff. When it is failed permanently, it is moved to the dead-letter list,
+// so are background jobs of Scheduler. Admins can inspect and re-run them by the $deadletter branch.
+type RetryManager struct {
+	root        *TreeRoot
+	policies    map[string]*RetryPolicy // branch path.FuncName: policy
+	deadLetters map[int64]*DeadLetter
+	seq         int64
+	// max number of dead letters, the oldest one is dropped when it is exceeded
+	MaxDeadLetters int
+	mutex          sync.RWMutex
+}
+
+func NewRetryManager(root *TreeRoot) *RetryManager {
+	return &RetryManager{
+		root:           root,
+		policies:       make(map[string]*RetryPolicy),
+		deadLetters:    make(map[int64]*DeadLetter),
+		MaxDeadLetters: 1000,
+	}
+}
+
+// SetPolicy sets retry policy of an exportable, nil to remove it
+// @path: branch path and function name without tree name, ex. "reports.Daily"
+func (rm *RetryManager) SetPolicy(path string, policy *RetryPolicy) {
+	rm.mutex.Lock()
+	defer rm.mutex.Unlock()
+	if policy == nil {
+		delete(rm.policies, path)
+	} else {
+		rm.policies[path] = policy
+	}
+}
+
+// PolicyOf returns retry policy of a call path (ex. "Tree.reports.Daily"), nil if none
+func (rm *RetryManager) PolicyOf(nodePath string) *RetryPolicy {
+	rm.mutex.RLock()
+	defer rm.mutex.RUnlock()
+	return rm.policies[strings.TrimPrefix(nodePath, rm.root.Name+".")]
+}
+
+// bury adds a failed call to the dead-letter list
+func (rm *RetryManager) bury(ctx *TreeCallCtx, retcode int32, err error) {
+	letter := &DeadLetter{
+		CmdID:    ctx.CmdID,
+		Path:     ctx.NodePath,
+		Args:     ctx.Args,
+		Kw:       kwOf(ctx),
+		Retcode:  retcode,
+		Attempts: ctx.Attempts(),
+		Ctime:    ctx.Ctime,
+		Mtime:    time.Now().Unix(),
+	}
+	if parts := strings.SplitN(ctx.CmdPath, "\t", 2); len(parts) == 2 {
+		letter.Username = parts[1]
+	}
+	if err != nil {
+		letter.Error = err.Error()
+	}
+	rm.add(letter)
+}
+
+// buryCheckpoint adds a resumable call which is not finished after resumed MaxResumes times
+func (rm *RetryManager) buryCheckpoint(checkpoint *Checkpoint) {
+	rm.add(&DeadLetter{
+		Path:     checkpoint.Path,
+		Username: checkpoint.Username,
+		Args:     checkpoint.Args,
+		Kw:       checkpoint.Kw,
+		Retcode:  RetcodeJobFailed,
+		Error:    "not finished after resumed " + strconv.Itoa(checkpoint.Resumed) + " times",
+		Attempts: checkpoint.Resumed + 1,
+		Ctime:    uint32(checkpoint.Ctime),
+		Mtime:    time.Now().Unix(),
+	})
+}
+
+// add appends letter to the dead-letter list
+func (rm *RetryManager) add(letter *DeadLetter) {
+	rm.mutex.Lock()
+	rm.seq++
+	letter.ID = rm.seq
+	rm.deadLetters[letter.ID] = letter
+	if len(rm.deadLetters) > rm.MaxDeadLetters {
+		// ids are increasing, drop the oldest one
+		oldest := letter.ID
+		for id := range rm.deadLetters {
+			if id < oldest {
+				oldest = id
+			}
+		}
+		delete(rm.deadLetters, oldest)
+	}
+	rm.mutex.Unlock()
+	Metrics.Incr("treecall.deadletter")
+	log.Println("[dead letter]", letter.ID, letter.Path, letter.Retcode, letter.Error)
+}
+
+// DeadLetters returns the dead-letter list, oldest first
+func (rm *RetryManager) DeadLetters() []*DeadLetter {
+	rm.mutex.RLock()
+	ret := make([]*DeadLetter, 0, len(rm.deadLetters))
+	for _, letter := range rm.deadLetters {
+		ret = append(ret, letter)
+	}
+	rm.mutex.RUnlock()
+	sort.Slice(ret, func(i, j int) bool { return ret[i].ID < ret[j].ID })
+	return ret
+}
+
+// DeadLetter returns a dead letter of id, nil if not found
+func (rm *RetryManager) DeadLetter(id int64) *DeadLetter {
+	rm.mutex.RLock()
+	defer rm.mutex.RUnlock()
+	return rm.deadLetters[id]
+}
+
+// Delete removes a dead letter
+func (rm *RetryManager) Delete(id int64) error {
+	rm.mutex.Lock()
+	defer rm.mutex.Unlock()
+	if _, ok := rm.deadLetters[id]; !ok {
+		return errors.New("not found")
+	}
+	delete(rm.deadLetters, id)
+	return nil
+}
+
+// Rerun calls a dead letter again as its user in background (see TreeRoot.CallAs),
+// it is removed from the list. Returns the new call.
+func (rm *RetryManager) Rerun(id int64) (*TreeCallCtx, error) {
+	rm.mutex.Lock()
+	letter, ok := rm.deadLetters[id]
+	if ok {
+		delete(rm.deadLetters, id)
+	}
+	rm.mutex.Unlock()
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	var user User
+	if letter.Username != "" {
+		if user = lookupUser(letter.Username); user == nil {
+			return nil, errors.New("user not found: " + letter.Username)
+		}
+	}
+	return rm.root.CallAs(user, letter.Path, letter.Args, letter.Kw, nil), nil
+}
+
+// kwOf converts ctx.Kw to map
+func kwOf(ctx *TreeCallCtx) map[string]string {
+	kw := make(map[string]string)
+	if ctx.Kw != nil {
+		ctx.Kw.VisitAll(func(key, value []byte) {
+			kw[string(key)] = string(value)
+		})
+	}
+	return kw
+}
+
+// Attempts returns how many times the exportable has been called for this call, see RetryPolicy
+func (tcCtx *TreeCallCtx) Attempts() int {
+	return int(atomic.LoadInt32(&tcCtx.attempts))
+}
+
+// cancelled tells if the context has been cancelled
+func (tcCtx *TreeCallCtx) cancelled() bool {
+	tcCtx.mutex.RLock()
+	defer tcCtx.mutex.RUnlock()
+	return tcCtx.context.Err() != nil
+}
+
+// retryOrBury is called by Reject. It returns true if the call will be retried,
+// otherwise a permanently failed background call is moved to the dead-letter list.
+func (tcCtx *TreeCallCtx) retryOrBury(retcode int32, err error) bool {
+	if !tcCtx.background || tcCtx.Root == nil || tcCtx.IsFinished() {
+		return false
+	}
+	job := tcCtx.job
+	var policy *RetryPolicy
+	if job != nil {
+		policy = job.policy()
+	} else {
+		policy = tcCtx.Root.Retry.PolicyOf(tcCtx.NodePath)
+	}
+	if policy == nil && job == nil {
+		return false
+	}
+	if policy != nil && (job != nil || tcCtx.invoke != nil) &&
+		policy.retryable(retcode) && tcCtx.Attempts() < policy.MaxAttempts && !tcCtx.cancelled() {
+		attempt := int(atomic.AddInt32(&tcCtx.attempts, 1))
+		delay := policy.delayOf(attempt)
+		message := ""
+		if err != nil {
+			message = err.Error()
+		}
+		Metrics.Incr("treecall.retry")
+		tcCtx.Notify(map[string]interface{}{
+			"retry":       attempt,
+			"maxAttempts": policy.MaxAttempts,
+			"retcode":     retcode,
+			"error":       message,
+			"delay":       delay.String(),
+		})
+		if job != nil {
+			// a job of Scheduler is run again in its worker pool
+			tcCtx.Root.Scheduler.retry(job, delay)
+			return true
+		}
+		time.AfterFunc(delay, func() {
+			if tcCtx.IsFinished() {
+				// killed or timed out while waiting
+				return
+			}
+			tcCtx.Go(tcCtx.invoke)
+		})
+		return true
+	}
+	if retcode != RetcodeKilled {
+		tcCtx.Root.Retry.bury(tcCtx, retcode, err)
+	}
+	return false
+}
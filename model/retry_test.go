@@ -0,0 +1,118 @@
+package model
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyDelay(t *testing.T) {
+	cases := []struct {
+		policy  RetryPolicy
+		attempt int
+		expect  time.Duration
+	}{
+		{RetryPolicy{}, 2, time.Second},
+		{RetryPolicy{}, 3, 2 * time.Second},
+		{RetryPolicy{}, 4, 4 * time.Second},
+		{RetryPolicy{Backoff: 100 * time.Millisecond, Multiplier: 3}, 4, 900 * time.Millisecond},
+		{RetryPolicy{Backoff: time.Second, Multiplier: 1}, 5, time.Second},
+		{RetryPolicy{Backoff: time.Second, MaxBackoff: 3 * time.Second}, 5, 3 * time.Second},
+	}
+	for i, c := range cases {
+		if delay := c.policy.delayOf(c.attempt); delay != c.expect {
+			t.Errorf("case %d: expect %s, got %s", i, c.expect, delay)
+		}
+	}
+}
+
+func TestRetryPolicyRetryable(t *testing.T) {
+	all := &RetryPolicy{}
+	if !all.retryable(RetcodeBusy) || !all.retryable(RetcodeJobFailed) {
+		t.Error("expect all retcodes retryable")
+	}
+	if all.retryable(RetcodeKilled) || all.retryable(RetcodeTimeout) {
+		t.Error("expect killed and timed out calls never retried")
+	}
+	some := &RetryPolicy{Retcodes: []int32{RetcodeBusy}}
+	if !some.retryable(RetcodeBusy) || some.retryable(RetcodeJobFailed) {
+		t.Error("expect only listed retcodes retryable")
+	}
+}
+
+// flakyBranch rejects calls of Run with retcode until it has been called failures times
+type flakyBranch struct {
+	BaseBranch
+	calls    int32
+	failures int32
+	retcode  int32
+}
+
+func (b *flakyBranch) BeReady(treeroot *TreeRoot) {
+	treeroot.SureReady(b)
+}
+
+func (b *flakyBranch) Run(ctx *TreeCallCtx) {
+	if n := atomic.AddInt32(&b.calls, 1); n <= b.failures {
+		ctx.Reject(b.retcode, errors.New("flaky"))
+		return
+	}
+	ctx.Resolve("ok")
+}
+
+func callFlaky(t *testing.T, failures int32, retcode int32, policy *RetryPolicy) (*TreeRoot, *flakyBranch, *TreeCallReturn) {
+	root := newTestRoot()
+	branch := &flakyBranch{failures: failures, retcode: retcode}
+	branch.InitBaseBranch("flaky")
+	branch.Export(branch.Run)
+	root.AddBranchWithName(branch, "flaky")
+	root.Retry.SetPolicy("flaky.Run", policy)
+	finished := make(chan *TreeCallReturn, 1)
+	root.CallAs(nil, "Tree.flaky.Run", nil, nil, func(ret *TreeCallReturn) {
+		if ret.Retcode >= 0 {
+			finished <- ret
+		}
+	})
+	return root, branch, waitResult(t, finished)
+}
+
+func TestRetryBackgroundCall(t *testing.T) {
+	root, branch, ret := callFlaky(t, 2, RetcodeBusy, &RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond})
+	if ret.Retcode != 0 || atomic.LoadInt32(&branch.calls) != 3 {
+		t.Fatalf("expect resolved at the 3rd attempt, got %d after %d calls", ret.Retcode, branch.calls)
+	}
+	if n := len(root.Retry.DeadLetters()); n != 0 {
+		t.Fatalf("expect no dead letter, got %d", n)
+	}
+}
+
+func TestRetryBuriesExhaustedCall(t *testing.T) {
+	root, branch, ret := callFlaky(t, 5, RetcodeBusy, &RetryPolicy{MaxAttempts: 2, Backoff: time.Millisecond})
+	if ret.Retcode != RetcodeBusy || atomic.LoadInt32(&branch.calls) != 2 {
+		t.Fatalf("expect rejected after 2 calls, got %d after %d calls", ret.Retcode, branch.calls)
+	}
+	letters := root.Retry.DeadLetters()
+	if len(letters) != 1 || letters[0].Path != "Tree.flaky.Run" || letters[0].Attempts != 2 {
+		t.Fatalf("expect the call buried, got %+v", letters)
+	}
+
+	// a dead letter is removed when it is called again
+	if _, err := root.Retry.Rerun(letters[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if root.Retry.DeadLetter(letters[0].ID) != nil {
+		t.Fatal("expect the dead letter removed")
+	}
+	waitFor(t, "the rerun", func() bool { return atomic.LoadInt32(&branch.calls) >= 3 })
+}
+
+func TestRetrySkipsUnlistedRetcode(t *testing.T) {
+	root, branch, ret := callFlaky(t, 1, RetcodeBadRequest, &RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond, Retcodes: []int32{RetcodeBusy}})
+	if ret.Retcode != RetcodeBadRequest || atomic.LoadInt32(&branch.calls) != 1 {
+		t.Fatalf("expect rejected at once, got %d after %d calls", ret.Retcode, branch.calls)
+	}
+	if n := len(root.Retry.DeadLetters()); n != 1 {
+		t.Fatalf("expect the call buried, got %d dead letters", n)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
     "sync"
 	"log"
+	"time"
 	"mime/multipart"
 	"os"
 	"path/filepath"
@@ -221,6 +222,56 @@ func (routeRegister *RouteRegister) Post(urlPath string, handler RequestHandler,
 
 type WebsocketOptions struct{
     MaxPayloadSize uint64
+	// interval of sending ping to the peer, negative for no heartbeat
+	PingInterval time.Duration
+	// the connection is closed when nothing (including pong) is received from the peer
+	// in PingInterval * MaxMissedPongs, default is 3
+	MaxMissedPongs int
+	// read timeout if there is no heartbeat, default is 1 hour
+	ReadTimeout time.Duration
+	// write timeout of a message, default is 8 seconds
+	WriteTimeout time.Duration
+	// max number of messages waiting to be sent, default is 256
+	QueueSize int
+	// what to do when the queue is full, OverflowDropNotify (default) or OverflowClose
+	Overflow string
+}
+
+// DefaultWebsocketOptions fills zero fields of options of a websocket route, see withDefaults()
+var DefaultWebsocketOptions = &WebsocketOptions{
+	PingInterval:   30 * time.Second,
+	MaxMissedPongs: 3,
+}
+
+// withDefaults returns a copy of options whose zero fields are taken from DefaultWebsocketOptions,
+// so a route which sets some of the options still gets the heartbeat
+func (options *WebsocketOptions) withDefaults() *WebsocketOptions {
+	ret := *DefaultWebsocketOptions
+	if options == nil {
+		return &ret
+	}
+	if options.MaxPayloadSize > 0 {
+		ret.MaxPayloadSize = options.MaxPayloadSize
+	}
+	if options.PingInterval != 0 {
+		ret.PingInterval = options.PingInterval
+	}
+	if options.MaxMissedPongs > 0 {
+		ret.MaxMissedPongs = options.MaxMissedPongs
+	}
+	if options.ReadTimeout > 0 {
+		ret.ReadTimeout = options.ReadTimeout
+	}
+	if options.WriteTimeout > 0 {
+		ret.WriteTimeout = options.WriteTimeout
+	}
+	if options.QueueSize > 0 {
+		ret.QueueSize = options.QueueSize
+	}
+	if options.Overflow != "" {
+		ret.Overflow = options.Overflow
+	}
+	return &ret
 }
 // WebsocketWithOption registers a websocket handler
 func (routeRegister *RouteRegister) WebsocketWithOptions(urlPath string, reqHandler WebsocketHandler, acl int,options *WebsocketOptions) {
@@ -236,13 +287,15 @@ func (routeRegister *RouteRegister) WebsocketWithOptions(urlPath string, reqHand
 			args := ctx.QueryArgs()
 			newargs := fasthttp.Args{}
 			args.CopyTo(&newargs)
+			protocol := subprotocolOf(ctx)
 			upgr := fastws.Upgrader{
 				Handler: func(conn *fastws.Conn) {
 					wsCtx := NewWebsocketCtx(nil, "", &newargs, conn)
-					reqHandler(wsCtx)
-					wsCtx.Handle()
+					wsCtx.Subprotocol = protocol
+					handleWebsocket(urlPath, wsCtx, reqHandler, options)
 				},
-				Compress: true,
+				Protocols: WebsocketProtocols,
+				Compress:  true,
 			}
 			upgr.Upgrade(ctx)
 		})
@@ -253,13 +306,15 @@ func (routeRegister *RouteRegister) WebsocketWithOptions(urlPath string, reqHand
 			args := ctx.QueryArgs()
 			newargs := fasthttp.Args{}
 			args.CopyTo(&newargs)
+			protocol := subprotocolOf(ctx)
 			upgr := fastws.Upgrader{
 				Handler: func(conn *fastws.Conn) {
 					wsCtx := NewWebsocketCtx(nil, UUID, &newargs, conn)
-					reqHandler(wsCtx)
-					wsCtx.Handle()
+					wsCtx.Subprotocol = protocol
+					handleWebsocket(urlPath, wsCtx, reqHandler, options)
 				},
-				Compress: true,
+				Protocols: WebsocketProtocols,
+				Compress:  true,
 			}
 			upgr.Upgrade(ctx)
 		})
@@ -276,21 +331,34 @@ func (routeRegister *RouteRegister) WebsocketWithOptions(urlPath string, reqHand
 			args := ctx.QueryArgs()
 			newargs := fasthttp.Args{}
 			args.CopyTo(&newargs)
+			protocol := subprotocolOf(ctx)
 			upgr := fastws.Upgrader{
 				Handler: func(conn *fastws.Conn) {
-                    if options != nil && options.MaxPayloadSize > 0 {
-                        conn.MaxPayloadSize = options.MaxPayloadSize
-                    }
 					wsCtx := NewWebsocketCtx(user, "", &newargs, conn)
-					reqHandler(wsCtx)
-					wsCtx.Handle()
+					wsCtx.Subprotocol = protocol
+					handleWebsocket(urlPath, wsCtx, reqHandler, options)
 				},
-				Compress: true,
+				Protocols: WebsocketProtocols,
+				Compress:  true,
 			}
 			upgr.Upgrade(ctx)
 		})
 	}
 }
+// handleWebsocket registers wsCtx to Sessions and serves it until it is closed
+func handleWebsocket(urlPath string, wsCtx *WebsocketCtx, reqHandler WebsocketHandler, options *WebsocketOptions) {
+	options = options.withDefaults()
+	if options.MaxPayloadSize > 0 {
+		wsCtx.Conn.MaxPayloadSize = options.MaxPayloadSize
+	}
+	wsCtx.options = options
+	wsCtx.outbox = newOutbox(wsCtx, wsCtx.Conn, options)
+	wsCtx.Route = urlPath
+	Sessions.Add(wsCtx)
+	reqHandler(wsCtx)
+	wsCtx.Handle()
+}
+
 // Websocket is shortcut of WebsocketWithOption
 func (routeRegister *RouteRegister) Websocket(urlPath string, reqHandler WebsocketHandler, acl int) {
     routeRegister.WebsocketWithOptions(urlPath, reqHandler , acl , nil)
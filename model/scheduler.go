@@ -0,0 +1,370 @@
+package model
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"log"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Job is the work of a background task which is run by Scheduler.
+// Its result is resolved when it returns nil error, otherwise it is rejected (RetcodeJobFailed by default),
+// then it is retried by the RetryPolicy of JobOptions.Retry or of the exportable (see RetryManager).
+// A job should report progress by ctx.Notify() and stop when ctx.Context() is done.
+type Job func(ctx *TreeCallCtx) (interface{}, error)
+
+// JobOptions of TreeCallCtx.Enqueue
+type JobOptions struct {
+	// name of worker pool, "" for "default"
+	Pool string
+	// higher priority job is run first, jobs of same priority are run in FIFO
+	Priority int
+	// retry policy of this job, default is the policy of the exportable in Root.Retry.
+	// A job which is failed permanently is moved to the dead-letter list.
+	Retry *RetryPolicy
+	// Deprecated: use Retry. Times to retry when the job returns error,
+	// it is a shorthand of RetryPolicy{MaxAttempts: MaxRetries + 1, Backoff: RetryDelay, Multiplier: 1}
+	// and is ignored if Retry is set.
+	MaxRetries int
+	// Deprecated: use Retry. Delay before retrying, default is 1 second
+	RetryDelay time.Duration
+}
+
+// retryPolicy returns Retry, or the policy built by MaxRetries and RetryDelay
+func (options *JobOptions) retryPolicy() *RetryPolicy {
+	if options.Retry != nil || options.MaxRetries <= 0 {
+		return options.Retry
+	}
+	return &RetryPolicy{MaxAttempts: options.MaxRetries + 1, Backoff: options.RetryDelay, Multiplier: 1}
+}
+
+// job states
+const (
+	JobQueued  = "queued"
+	JobRunning = "running"
+	JobRetry   = "retrying"
+	JobDone    = "done"
+)
+
+// scheduledJob is a job in a worker pool
+type scheduledJob struct {
+	ID      int64
+	ctx     *TreeCallCtx
+	fn      Job
+	options JobOptions
+	// see JobXXX
+	state string
+	// for FIFO of same priority
+	seq   int64
+	index int // index in jobHeap
+}
+
+// jobHeap is a priority queue of jobs, see container/heap
+type jobHeap []*scheduledJob
+
+func (h jobHeap) Len() int { return len(h) }
+func (h jobHeap) Less(i, j int) bool {
+	if h[i].options.Priority == h[j].options.Priority {
+		return h[i].seq < h[j].seq
+	}
+	return h[i].options.Priority > h[j].options.Priority
+}
+func (h jobHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+func (h *jobHeap) Push(x interface{}) {
+	job := x.(*scheduledJob)
+	job.index = len(*h)
+	*h = append(*h, job)
+}
+func (h *jobHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	job := old[n-1]
+	old[n-1] = nil
+	job.index = -1
+	*h = old[:n-1]
+	return job
+}
+
+// WorkerPool runs at most Workers jobs at the same time
+type WorkerPool struct {
+	Name    string
+	Workers int
+	running int
+	queue   jobHeap
+}
+
+// Scheduler runs background jobs in worker pools by priority, see TreeCallCtx.Enqueue().
+// There is a "default" pool of runtime.NumCPU() workers, more pools can be added:
+//
+//	Root.Scheduler.SetPool("report", 2)
+//
+//	func (self *ReportBranch) Build(ctx *TreeCallCtx) {
+//		ctx.Enqueue(func(ctx *TreeCallCtx) (interface{}, error) {
+//			ctx.Notify("step 1 of 2")
+//			...
+//			return result, nil
+//		}, &model.JobOptions{Pool: "report", Priority: 5, Retry: &model.RetryPolicy{MaxAttempts: 4}})
+//	}
+type Scheduler struct {
+	pools map[string]*WorkerPool
+	seq   int64
+	mutex sync.Mutex
+}
+
+func NewScheduler() *Scheduler {
+	scheduler := &Scheduler{pools: make(map[string]*WorkerPool)}
+	scheduler.SetPool("default", runtime.NumCPU())
+	return scheduler
+}
+
+// SetPool adds a worker pool or changes the number of workers of an existing pool
+func (s *Scheduler) SetPool(name string, workers int) {
+	if workers < 1 {
+		workers = 1
+	}
+	s.mutex.Lock()
+	if pool, ok := s.pools[name]; ok {
+		pool.Workers = workers
+	} else {
+		s.pools[name] = &WorkerPool{Name: name, Workers: workers, queue: make(jobHeap, 0)}
+	}
+	s.mutex.Unlock()
+	s.dispatch()
+}
+
+// Enqueue puts ctx into a worker pool to run job, ctx is turned to be a background task.
+func (s *Scheduler) Enqueue(ctx *TreeCallCtx, fn Job, options *JobOptions) error {
+	if options == nil {
+		options = &JobOptions{}
+	}
+	if options.Pool == "" {
+		options.Pool = "default"
+	}
+	s.mutex.Lock()
+	pool, ok := s.pools[options.Pool]
+	if !ok {
+		s.mutex.Unlock()
+		return errors.New("no such worker pool: " + options.Pool)
+	}
+	if ctx.job != nil {
+		s.mutex.Unlock()
+		return errors.New("job has been enqueued")
+	}
+	s.seq++
+	job := &scheduledJob{ID: s.seq, ctx: ctx, fn: fn, options: *options, state: JobQueued, seq: s.seq}
+	job.options.Retry = options.retryPolicy()
+	ctx.job = job
+	heap.Push(&pool.queue, job)
+	position := s.positionOf(job)
+	s.mutex.Unlock()
+
+	// the first attempt, a call not issued by TreeRoot.Call has not counted it
+	atomic.CompareAndSwapInt32(&ctx.attempts, 0, 1)
+	ctx.SetBackground(true)
+	// a job can be killed by user, and is listed in $.ListUserTasks
+	ctx.killable()
+	// leave the queue when the job is killed or timed out
+	ctx.Observe(func(ret *TreeCallReturn) {
+		if ctx.IsFinished() {
+			s.remove(job)
+		}
+	})
+	Metrics.Incr("scheduler.enqueued")
+	ctx.Notify(map[string]interface{}{"queued": true, "pool": options.Pool, "position": position})
+	s.dispatch()
+	return nil
+}
+
+// positionOf returns 1-based position of a queued job in its pool, caller should hold s.mutex
+func (s *Scheduler) positionOf(job *scheduledJob) int {
+	pool := s.pools[job.options.Pool]
+	position := 1
+	for i := range pool.queue {
+		if i != job.index && pool.queue.Less(i, job.index) {
+			position++
+		}
+	}
+	return position
+}
+
+// remove takes a queued job out of its pool
+func (s *Scheduler) remove(job *scheduledJob) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if job.state == JobQueued && job.index >= 0 {
+		heap.Remove(&s.pools[job.options.Pool].queue, job.index)
+	}
+	job.state = JobDone
+}
+
+// dispatch starts queued jobs if there are idle workers
+func (s *Scheduler) dispatch() {
+	var started []*scheduledJob
+	s.mutex.Lock()
+	for _, pool := range s.pools {
+		for pool.running < pool.Workers && pool.queue.Len() > 0 {
+			job := heap.Pop(&pool.queue).(*scheduledJob)
+			job.state = JobRunning
+			pool.running++
+			started = append(started, job)
+		}
+	}
+	s.mutex.Unlock()
+	for _, job := range started {
+		go s.run(job)
+	}
+}
+
+// run calls the job, then resolves or rejects it, a rejected job might be retried by retryOrBury()
+func (s *Scheduler) run(job *scheduledJob) {
+	ctx := job.ctx
+	defer func() {
+		s.mutex.Lock()
+		s.pools[job.options.Pool].running--
+		if job.state == JobRunning {
+			job.state = JobDone
+		}
+		s.mutex.Unlock()
+		s.dispatch()
+	}()
+	if ctx.IsFinished() {
+		return
+	}
+	result, err := s.call(job)
+	if ctx.IsFinished() || s.retrying(job) {
+		// resolved or rejected by the job itself, or killed
+		return
+	}
+	if err == nil {
+		ctx.Resolve(result)
+		return
+	}
+	tcErr := AsTreeCallError(RetcodeJobFailed, err)
+	ctx.Reject(tcErr.Code, tcErr)
+	if ctx.IsFinished() {
+		Metrics.Incr("scheduler.failed")
+	}
+}
+
+// retrying tells if the job is waiting to be retried
+func (s *Scheduler) retrying(job *scheduledJob) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return job.state == JobRetry
+}
+
+// retry puts a rejected job back to its pool after delay, it is called by retryOrBury()
+func (s *Scheduler) retry(job *scheduledJob, delay time.Duration) {
+	s.mutex.Lock()
+	job.state = JobRetry
+	s.mutex.Unlock()
+	Metrics.Incr("scheduler.retry")
+	time.AfterFunc(delay, func() { s.requeue(job) })
+}
+
+// call runs job.fn, a panic is taken as an error (which could be retried)
+func (s *Scheduler) call(job *scheduledJob) (result interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("[panic] job %v of %v: %v\n%s", job.ID, job.ctx.NodePath, r, debug.Stack())
+			Metrics.Incr("treecall.panic")
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return job.fn(job.ctx)
+}
+
+// policy returns the retry policy of the job, nil if none
+func (job *scheduledJob) policy() *RetryPolicy {
+	if job.options.Retry != nil {
+		return job.options.Retry
+	}
+	if job.ctx.Root == nil {
+		return nil
+	}
+	return job.ctx.Root.Retry.PolicyOf(job.ctx.NodePath)
+}
+
+// requeue puts a failed job back to its pool for retrying
+func (s *Scheduler) requeue(job *scheduledJob) {
+	s.mutex.Lock()
+	if job.state != JobRetry || job.ctx.IsFinished() {
+		s.mutex.Unlock()
+		return
+	}
+	job.state = JobQueued
+	heap.Push(&s.pools[job.options.Pool].queue, job)
+	s.mutex.Unlock()
+	s.dispatch()
+}
+
+// Status returns a readable state of the job of ctx ("" if it is not a job), ex. "queued #2 (default, priority 5)"
+func (s *Scheduler) Status(ctx *TreeCallCtx) string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	job := ctx.job
+	if job == nil {
+		return ""
+	}
+	detail := fmt.Sprintf("%s, priority %d", job.options.Pool, job.options.Priority)
+	if policy := job.policy(); policy != nil && policy.MaxAttempts > 1 {
+		detail += fmt.Sprintf(", attempt %d/%d", job.ctx.Attempts(), policy.MaxAttempts)
+	}
+	if job.state == JobQueued {
+		return fmt.Sprintf("%s #%d (%s)", job.state, s.positionOf(job), detail)
+	}
+	return fmt.Sprintf("%s (%s)", job.state, detail)
+}
+
+// Snapshot returns workers, running and queued jobs of every pool
+func (s *Scheduler) Snapshot() map[string]map[string]int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	ret := make(map[string]map[string]int, len(s.pools))
+	for name, pool := range s.pools {
+		ret[name] = map[string]int{"workers": pool.Workers, "running": pool.running, "queued": pool.queue.Len()}
+	}
+	return ret
+}
+
+// Enqueue runs job in a worker pool of Root.Scheduler, this call is turned to be a background task.
+// The result of job is resolved, or rejected after retries. See Scheduler.
+func (tcCtx *TreeCallCtx) Enqueue(job Job, options *JobOptions) error {
+	if tcCtx.Root == nil {
+		return errors.New("no tree root")
+	}
+	return tcCtx.Root.Scheduler.Enqueue(tcCtx, job, options)
+}
+
+// JobStatus returns the state of the job enqueued by Enqueue() or the attempt of a retried call, "" if none
+func (tcCtx *TreeCallCtx) JobStatus() string {
+	if tcCtx.Root == nil {
+		return ""
+	}
+	if tcCtx.IsPaused() {
+		return "paused"
+	}
+	if status := tcCtx.Root.Scheduler.Status(tcCtx); status != "" {
+		return status
+	}
+	tcCtx.mutex.RLock()
+	workflow := tcCtx.workflow
+	tcCtx.mutex.RUnlock()
+	if workflow != nil {
+		return workflow.status()
+	}
+	// a call which has been retried, see RetryPolicy
+	if attempts := tcCtx.Attempts(); attempts > 1 {
+		return fmt.Sprintf("attempt %d", attempts)
+	}
+	return ""
+}
@@ -0,0 +1,105 @@
+package model
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// enqueueJob enqueues fn as a background call of root, returns the channel of its final result
+func enqueueJob(t *testing.T, root *TreeRoot, fn Job, options *JobOptions) (*TreeCallCtx, chan *TreeCallReturn) {
+	finished := make(chan *TreeCallReturn, 1)
+	ctx := root.newInternalCallCtx(nil, nil, nil, func(ret *TreeCallReturn) {
+		if ret.Retcode >= 0 {
+			finished <- ret
+		}
+	})
+	ctx.NodePath = "Tree.test.Job"
+	if err := ctx.Enqueue(fn, options); err != nil {
+		t.Fatal(err)
+	}
+	return ctx, finished
+}
+
+func waitResult(t *testing.T, finished chan *TreeCallReturn) *TreeCallReturn {
+	select {
+	case ret := <-finished:
+		return ret
+	case <-time.After(5 * time.Second):
+		t.Fatal("job is not finished")
+	}
+	return nil
+}
+
+func TestSchedulerRetryShorthand(t *testing.T) {
+	root := newTestRoot()
+	failures := 2
+	ctx, finished := enqueueJob(t, root, func(ctx *TreeCallCtx) (interface{}, error) {
+		if ctx.Attempts() <= failures {
+			return nil, errors.New("not yet")
+		}
+		return "ok", nil
+	}, &JobOptions{MaxRetries: 2, RetryDelay: time.Millisecond})
+	if ret := waitResult(t, finished); ret.Retcode != 0 || ret.Stdout != "ok" {
+		t.Fatalf("expect resolved, got %d %v", ret.Retcode, ret.Stderr)
+	}
+	if n := ctx.Attempts(); n != 3 {
+		t.Fatalf("expect 3 attempts, got %d", n)
+	}
+}
+
+func TestSchedulerRetryPolicyBuries(t *testing.T) {
+	root := newTestRoot()
+	_, finished := enqueueJob(t, root, func(ctx *TreeCallCtx) (interface{}, error) {
+		return nil, errors.New("always")
+	}, &JobOptions{
+		Retry:      &RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond},
+		MaxRetries: 10, // ignored since Retry is set
+	})
+	if ret := waitResult(t, finished); ret.Retcode != RetcodeJobFailed {
+		t.Fatalf("expect job failed, got %d", ret.Retcode)
+	}
+	letters := root.Retry.DeadLetters()
+	if len(letters) != 1 || letters[0].Attempts != 3 || letters[0].Error != "always" {
+		t.Fatalf("expect buried after 3 attempts, got %+v", letters)
+	}
+}
+
+func TestSchedulerPriority(t *testing.T) {
+	root := newTestRoot()
+	root.Scheduler.SetPool("one", 1)
+	release := make(chan struct{})
+	_, blocker := enqueueJob(t, root, func(ctx *TreeCallCtx) (interface{}, error) {
+		<-release
+		return nil, nil
+	}, &JobOptions{Pool: "one"})
+
+	var order []string
+	var mutex sync.Mutex
+	var results []chan *TreeCallReturn
+	for _, job := range []struct {
+		name     string
+		priority int
+	}{{"low", 1}, {"high", 5}, {"middle", 3}, {"high2", 5}} {
+		name := job.name
+		_, finished := enqueueJob(t, root, func(ctx *TreeCallCtx) (interface{}, error) {
+			mutex.Lock()
+			order = append(order, name)
+			mutex.Unlock()
+			return nil, nil
+		}, &JobOptions{Pool: "one", Priority: job.priority})
+		results = append(results, finished)
+	}
+	close(release)
+	waitResult(t, blocker)
+	for _, finished := range results {
+		waitResult(t, finished)
+	}
+	expect := []string{"high", "high2", "middle", "low"}
+	for i := range expect {
+		if order[i] != expect[i] {
+			t.Fatalf("expect %v, got %v", expect, order)
+		}
+	}
+}
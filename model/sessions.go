@@ -0,0 +1,141 @@
+package model
+
+import (
+	"errors"
+	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// SessionInfo is a snapshot of a live websocket
+type SessionInfo struct {
+	ID string `json:"id"`
+	// url path of websocket route
+	Route      string `json:"route"`
+	Username   string `json:"username,omitempty"`
+	UUID       string `json:"uuid,omitempty"`
+	RemoteAddr string `json:"remoteAddr"`
+	// timestamp of connecting
+	Ctime    int64 `json:"ctime"`
+	BytesIn  int64 `json:"bytesIn"`
+	BytesOut int64 `json:"bytesOut"`
+	// number of calls issued by this websocket which are not finished
+	Calls int32 `json:"calls"`
+	// number of messages waiting to be sent
+	Queued int `json:"queued"`
+}
+
+// SessionRegistry keeps live websockets of all routes, see Sessions.
+// A websocket is added when it is connected and removed when it is closed.
+type SessionRegistry struct {
+	sessions map[string]*WebsocketCtx
+	mutex    sync.RWMutex
+}
+
+// Sessions is the registry of live websockets
+var Sessions = NewSessionRegistry()
+
+func NewSessionRegistry() *SessionRegistry {
+	return &SessionRegistry{sessions: make(map[string]*WebsocketCtx)}
+}
+
+// Add adds a websocket, it is removed when the websocket is closed
+func (sr *SessionRegistry) Add(wsCtx *WebsocketCtx) {
+	sr.mutex.Lock()
+	sr.sessions[wsCtx.ID] = wsCtx
+	sr.mutex.Unlock()
+	Metrics.Incr("websocket.connected")
+	wsCtx.On("Close", "_sessions", func() {
+		sr.Remove(wsCtx.ID)
+	})
+}
+
+// Remove removes a websocket of id
+func (sr *SessionRegistry) Remove(id string) {
+	sr.mutex.Lock()
+	delete(sr.sessions, id)
+	sr.mutex.Unlock()
+}
+
+// Get returns the websocket of id, nil if not found
+func (sr *SessionRegistry) Get(id string) *WebsocketCtx {
+	sr.mutex.RLock()
+	defer sr.mutex.RUnlock()
+	return sr.sessions[id]
+}
+
+// List returns live websockets of route ("" for all routes), oldest first
+func (sr *SessionRegistry) List(route string) []*SessionInfo {
+	sr.mutex.RLock()
+	ret := make([]*SessionInfo, 0, len(sr.sessions))
+	for _, wsCtx := range sr.sessions {
+		if route == "" || wsCtx.Route == route {
+			ret = append(ret, wsCtx.Info())
+		}
+	}
+	sr.mutex.RUnlock()
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Ctime < ret[j].Ctime })
+	return ret
+}
+
+// ListUser returns live websockets of a user
+func (sr *SessionRegistry) ListUser(username string) []*SessionInfo {
+	ret := make([]*SessionInfo, 0)
+	for _, info := range sr.List("") {
+		if info.Username == username {
+			ret = append(ret, info)
+		}
+	}
+	return ret
+}
+
+// Disconnect closes the websocket of id, foreground calls of it are killed.
+// If it is attached to a resumable session (see SessionResumer), the session expires too,
+// so the browser can not resume it; expired tells if this happened.
+func (sr *SessionRegistry) Disconnect(id string) (expired bool, err error) {
+	wsCtx := sr.Get(id)
+	if wsCtx == nil {
+		return false, errors.New("not found")
+	}
+	Metrics.Incr("websocket.disconnected")
+	wsCtx.mutex.RLock()
+	session := wsCtx.session
+	wsCtx.mutex.RUnlock()
+	if session != nil && session.terminate(wsCtx) {
+		expired = true
+		log.Println("websocket", id, "disconnected, session", session.ID, "expired")
+	} else {
+		log.Println("websocket", id, "disconnected")
+	}
+	wsCtx.Close()
+	return expired, nil
+}
+
+// Count returns the number of live websockets
+func (sr *SessionRegistry) Count() int {
+	sr.mutex.RLock()
+	defer sr.mutex.RUnlock()
+	return len(sr.sessions)
+}
+
+// Info returns a snapshot of this websocket
+func (self *WebsocketCtx) Info() *SessionInfo {
+	info := &SessionInfo{
+		ID:         self.ID,
+		Route:      self.Route,
+		UUID:       self.UUID,
+		RemoteAddr: self.RemoteAddr,
+		Ctime:      self.Ctime.Unix(),
+		BytesIn:    atomic.LoadInt64(&self.bytesIn),
+		BytesOut:   atomic.LoadInt64(&self.bytesOut),
+		Calls:      atomic.LoadInt32(&self.calls),
+		Queued:     self.outbox.depth(),
+	}
+	self.mutex.RLock()
+	if self.User != nil {
+		info.Username = self.User.Username()
+	}
+	self.mutex.RUnlock()
+	return info
+}
@@ -0,0 +1,56 @@
+package model
+
+import (
+	"sync/atomic"
+	"testing"
+)
+
+func TestDisconnectExpiresResumableSession(t *testing.T) {
+	wsCtx := pipePair(t)
+	registry := NewSessionRegistry()
+	registry.Add(wsCtx)
+	resumer := NewSessionResumer()
+	session, _ := resumer.Attach(wsCtx, "")
+	var killed int32
+	session.On("Close", "call", CloseEventHandler(func() {
+		atomic.AddInt32(&killed, 1)
+	}))
+
+	expired, err := registry.Disconnect(wsCtx.ID)
+	if err != nil || !expired {
+		t.Fatalf("expect the session expired, got %v %v", expired, err)
+	}
+	if !wsCtx.IsClosed() || !session.IsClosed() {
+		t.Fatal("expect both the websocket and the session closed")
+	}
+	if n := atomic.LoadInt32(&killed); n != 1 {
+		t.Fatalf("expect calls of the session killed at once, got %d", n)
+	}
+	if resumer.Get(session.ID) != nil {
+		t.Fatal("expect the session removed")
+	}
+
+	// reconnecting with the id gets a new session
+	another := pipePair(t)
+	defer another.Close()
+	resumed, ok := resumer.Attach(another, session.ID)
+	if ok || resumed.ID == session.ID {
+		t.Fatal("expect the disconnected session not resumable")
+	}
+	if _, err := registry.Disconnect(wsCtx.ID); err == nil {
+		t.Fatal("expect the disconnected websocket not found")
+	}
+}
+
+func TestDisconnectWithoutSession(t *testing.T) {
+	wsCtx := pipePair(t)
+	registry := NewSessionRegistry()
+	registry.Add(wsCtx)
+	expired, err := registry.Disconnect(wsCtx.ID)
+	if err != nil || expired {
+		t.Fatalf("expect disconnected only, got %v %v", expired, err)
+	}
+	if registry.Count() != 0 {
+		t.Fatal("expect the websocket removed")
+	}
+}
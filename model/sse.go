@@ -0,0 +1,401 @@
+package model
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/valyala/fasthttp"
+)
+
+// sseEvent is an event of server-sent events, data is a JSONResult
+type sseEvent struct {
+	id uint64
+	// notify, resolve or reject
+	name string
+	data []byte
+}
+
+// sseFeed collects Notify, Resolve and Reject of a job (hooked to its Promise like $.Hook does),
+// or of all jobs of a user. It keeps recent events, so a reconnecting EventSource
+// gets what it has missed by Last-Event-ID.
+type sseFeed struct {
+	key string
+	hub *SSEHub
+	// username of the job, or of all jobs, of this feed
+	owner string
+	// true if this feed is of a single job
+	single bool
+	// true after the job of a single feed is resolved or rejected
+	finished      bool
+	events        []*sseEvent
+	subscribers   map[chan struct{}]bool
+	closeListener map[string]CloseEventHandler
+	closed        bool
+	timer         *time.Timer
+	mutex         sync.Mutex
+}
+
+// SSEHub streams progress of jobs by server-sent events, for read-only clients (ex. dashboards)
+// which do not need a websocket.
+//
+//	GET <prefix>             events of all jobs of the user, including jobs started later
+//	GET <prefix>/<cmd id>    events of a job, the stream ends after it is resolved or rejected
+//
+// Events are "notify", "resolve" and "reject", their data are JSONResult, ex.
+//
+//	id: 12
+//	event: notify
+//	data: {"id":5,"retcode":-2,"stdout":"50%"}
+//
+// Like $.Hook, only jobs in Root.Bank (ex. background jobs) could be streamed.
+// A job could be streamed by its owner or an administrator.
+type SSEHub struct {
+	Root   *TreeRoot
+	Prefix string
+	// number of recent events kept for Last-Event-ID, default is 100
+	History int
+	// how long a feed without readers is kept for reconnection, default is 1 minute
+	Retention time.Duration
+	// interval of comments which keep the connection alive, default is 15 seconds
+	KeepAlive time.Duration
+	feeds     map[string]*sseFeed
+	// last event id
+	serial uint64
+	mutex  sync.Mutex
+}
+
+// MountSSE serves server-sent events of jobs of this tree at prefix (ex. /events) with acl mode, see SSEHub
+func (self *TreeRoot) MountSSE(router *RouteRegister, prefix string, acl int) *SSEHub {
+	prefix = strings.TrimRight(prefix, "/")
+	hub := &SSEHub{
+		Root:      self,
+		Prefix:    prefix,
+		History:   100,
+		Retention: time.Minute,
+		KeepAlive: 15 * time.Second,
+		feeds:     make(map[string]*sseFeed),
+	}
+	router.Get(prefix, hub.handleUser, acl)
+	router.Get(prefix+"/{id}", hub.handleJob, acl)
+	return hub
+}
+
+// ownerOfCall returns username who issued a job in Root.Bank
+func ownerOfCall(tcCtx *TreeCallCtx) string {
+	if user := tcCtx.WsCtx.GetUser(); user != nil {
+		return user.Username()
+	}
+	if fields := strings.Split(tcCtx.CmdPath, "\t"); len(fields) > 1 {
+		return fields[1]
+	}
+	return ""
+}
+
+// lastEventID returns Last-Event-ID header (or ?lastEventId= for polyfills) of request
+func lastEventID(ctx *RequestCtx) uint64 {
+	value := string(ctx.Ctx.Request.Header.Peek("Last-Event-ID"))
+	if value == "" {
+		value = string(ctx.Ctx.QueryArgs().Peek("lastEventId"))
+	}
+	id, _ := strconv.ParseUint(value, 10, 64)
+	return id
+}
+
+func (hub *SSEHub) handleJob(ctx *RequestCtx) {
+	if ctx.User == nil {
+		respondError(ctx, RetcodeForbidden, fmt.Errorf("login required"))
+		return
+	}
+	value, _ := ctx.Ctx.UserValue("id").(string)
+	cmdID, err := strconv.ParseInt(value, 10, 32)
+	if err != nil {
+		respondError(ctx, RetcodeBadRequest, err)
+		return
+	}
+	key := "job:" + value
+	hub.mutex.Lock()
+	feed := hub.feeds[key]
+	hub.mutex.Unlock()
+	if feed == nil {
+		tcCtx := hub.Root.Bank.Get(int32(cmdID))
+		if tcCtx == nil || tcCtx.IsFinished() {
+			respondError(ctx, RetcodeNotFound, fmt.Errorf("job %v not found", cmdID))
+			return
+		}
+		owner := ownerOfCall(tcCtx)
+		if owner != ctx.User.Username() && !AdminChecker(ctx.User) {
+			respondError(ctx, RetcodeNotFound, fmt.Errorf("job %v not found", cmdID))
+			return
+		}
+		feed = hub.feed(key, owner, true)
+		feed.follow(tcCtx)
+	}
+	if feed.owner != ctx.User.Username() && !AdminChecker(ctx.User) {
+		respondError(ctx, RetcodeNotFound, fmt.Errorf("job %v not found", cmdID))
+		return
+	}
+	hub.stream(ctx, feed)
+}
+
+func (hub *SSEHub) handleUser(ctx *RequestCtx) {
+	if ctx.User == nil {
+		respondError(ctx, RetcodeForbidden, fmt.Errorf("login required"))
+		return
+	}
+	username := ctx.User.Username()
+	key := "user:" + username
+	hub.mutex.Lock()
+	feed := hub.feeds[key]
+	hub.mutex.Unlock()
+	if feed == nil {
+		feed = hub.feed(key, username, false)
+		hub.Root.Bank.Watch(username, key, func(tcCtx *TreeCallCtx) {
+			// Bank.Put() is called with the promise locked
+			go feed.follow(tcCtx)
+		})
+		feed.On("Close", "_sse", func() {
+			hub.Root.Bank.Unwatch(username, key)
+		})
+		if tcCtxs, err := hub.Root.Bank.ListUser(ctx.User); err == nil {
+			for _, tcCtx := range tcCtxs {
+				if tcCtx != nil {
+					feed.follow(tcCtx)
+				}
+			}
+		}
+	}
+	hub.stream(ctx, feed)
+}
+
+// feed returns the feed of key, it is created if not found
+func (hub *SSEHub) feed(key string, owner string, single bool) *sseFeed {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+	if feed, ok := hub.feeds[key]; ok {
+		return feed
+	}
+	feed := &sseFeed{
+		key:           key,
+		hub:           hub,
+		owner:         owner,
+		single:        single,
+		subscribers:   make(map[chan struct{}]bool),
+		closeListener: make(map[string]CloseEventHandler),
+	}
+	hub.feeds[key] = feed
+	// closed if nobody reads it
+	feed.timer = time.AfterFunc(hub.Retention, feed.expire)
+	return feed
+}
+
+// stream writes events of feed since Last-Event-ID until the client is gone
+func (hub *SSEHub) stream(ctx *RequestCtx, feed *sseFeed) {
+	since := lastEventID(ctx)
+	events, finished := feed.since(since)
+	if finished && len(events) == 0 {
+		// 204 tells EventSource not to reconnect
+		ctx.Ctx.SetStatusCode(fasthttp.StatusNoContent)
+		return
+	}
+	notify := feed.subscribe()
+	if notify == nil {
+		respondError(ctx, RetcodeNotFound, fmt.Errorf("feed is closed"))
+		return
+	}
+	ctx.Ctx.SetContentType("text/event-stream; charset=utf-8")
+	ctx.Ctx.Response.Header.Set("Cache-Control", "no-cache")
+	ctx.Ctx.Response.Header.Set("X-Accel-Buffering", "no")
+	Metrics.Add("sse.streams", 1)
+	ctx.Ctx.SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer func() {
+			feed.unsubscribe(notify)
+			Metrics.Add("sse.streams", -1)
+		}()
+		// tells EventSource how long to wait before reconnecting
+		fmt.Fprintf(w, "retry: %d\n\n", 3000)
+		keepAlive := time.NewTicker(hub.KeepAlive)
+		defer keepAlive.Stop()
+		for {
+			events, finished := feed.since(since)
+			for _, event := range events {
+				fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.id, event.name, event.data)
+				since = event.id
+			}
+			if err := w.Flush(); err != nil {
+				return
+			}
+			if finished {
+				return
+			}
+			select {
+			case <-notify:
+			case <-keepAlive.C:
+				w.WriteString(": keepalive\n\n")
+			}
+		}
+	})
+}
+
+// follow hooks this feed to the promise of a job, it is fine to follow a job twice
+func (feed *sseFeed) follow(tcCtx *TreeCallCtx) {
+	if tcCtx.IsFinished() || tcCtx.promise == nil {
+		return
+	}
+	if err := tcCtx.promise.Put(feed); err != nil {
+		log.Println("sse feed", feed.key, "does not follow job", tcCtx.CmdID, err)
+	}
+}
+
+// since returns events after id, and true if the job of this feed has finished
+func (feed *sseFeed) since(id uint64) ([]*sseEvent, bool) {
+	feed.mutex.Lock()
+	defer feed.mutex.Unlock()
+	idx := len(feed.events)
+	for idx > 0 && feed.events[idx-1].id > id {
+		idx--
+	}
+	return feed.events[idx:], feed.finished
+}
+
+// subscribe returns a channel which is signaled when an event arrives, nil if this feed is closed
+func (feed *sseFeed) subscribe() chan struct{} {
+	feed.mutex.Lock()
+	defer feed.mutex.Unlock()
+	if feed.closed {
+		return nil
+	}
+	if feed.timer != nil {
+		feed.timer.Stop()
+		feed.timer = nil
+	}
+	notify := make(chan struct{}, 1)
+	feed.subscribers[notify] = true
+	return notify
+}
+
+func (feed *sseFeed) unsubscribe(notify chan struct{}) {
+	feed.mutex.Lock()
+	defer feed.mutex.Unlock()
+	delete(feed.subscribers, notify)
+	if len(feed.subscribers) == 0 && !feed.closed && feed.timer == nil {
+		feed.timer = time.AfterFunc(feed.hub.Retention, feed.expire)
+	}
+}
+
+// expire closes this feed if nobody reads it
+func (feed *sseFeed) expire() {
+	feed.mutex.Lock()
+	if feed.closed || len(feed.subscribers) > 0 {
+		feed.mutex.Unlock()
+		return
+	}
+	feed.closed = true
+	feed.timer = nil
+	feed.events = nil
+	listeners := feed.closeListener
+	feed.closeListener = nil
+	feed.mutex.Unlock()
+	feed.hub.mutex.Lock()
+	delete(feed.hub.feeds, feed.key)
+	feed.hub.mutex.Unlock()
+	// unhooks this feed from promises
+	for _, fn := range listeners {
+		fn()
+	}
+}
+
+// push appends an event and wakes up subscribers
+func (feed *sseFeed) push(name string, data []byte, final bool) {
+	feed.mutex.Lock()
+	defer feed.mutex.Unlock()
+	if feed.closed || feed.finished {
+		return
+	}
+	feed.events = append(feed.events, &sseEvent{
+		id:   atomic.AddUint64(&feed.hub.serial, 1),
+		name: name,
+		data: data,
+	})
+	if n := len(feed.events) - feed.hub.History; n > 0 {
+		feed.events = feed.events[n:]
+	}
+	if final && feed.single {
+		feed.finished = true
+	}
+	for notify := range feed.subscribers {
+		select {
+		case notify <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// GetUser returns nil, a feed only reads results of jobs
+func (feed *sseFeed) GetUser() User {
+	return nil
+}
+func (feed *sseFeed) GenID() string {
+	return feed.key
+}
+func (feed *sseFeed) IsClosed() bool {
+	feed.mutex.Lock()
+	defer feed.mutex.Unlock()
+	return feed.closed
+}
+
+// On accepts "Close" only, which is fired when the feed expires
+func (feed *sseFeed) On(evtName string, token string, fn interface{}, args ...interface{}) bool {
+	if evtName != "Close" {
+		return false
+	}
+	feed.mutex.Lock()
+	defer feed.mutex.Unlock()
+	if feed.closed {
+		return false
+	}
+	if _, ok := feed.closeListener[token]; ok {
+		return false
+	}
+	feed.closeListener[token] = fn.(CloseEventHandler)
+	return true
+}
+func (feed *sseFeed) Off(evtName string, token string) bool {
+	if evtName != "Close" {
+		return false
+	}
+	feed.mutex.Lock()
+	defer feed.mutex.Unlock()
+	delete(feed.closeListener, token)
+	return true
+}
+func (feed *sseFeed) SendTreeCallReturn(ret *TreeCallReturn) (int, error) {
+	return feed.SendProtobufMessage(ResultOf(ret))
+}
+
+// SendProtobufMessage turns a Result into an event, other messages are ignored
+func (feed *sseFeed) SendProtobufMessage(message proto.Message) (int, error) {
+	result, ok := message.(*Result)
+	if !ok {
+		return 0, nil
+	}
+	data, err := MarshalJSONMessage(result)
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case result.Retcode < 0:
+		feed.push("notify", data, false)
+	case result.Retcode == 0:
+		feed.push("resolve", data, true)
+	default:
+		feed.push("reject", data, true)
+	}
+	return len(data), nil
+}
@@ -0,0 +1,212 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// DefaultStreamChunkSize is the max bytes of data in a Chunk sent by StreamWriter
+var DefaultStreamChunkSize = 64 * 1024
+
+// FlowController is implemented by PromiseStateListener which could hold a sender back
+// until its client has received what were sent, see StreamWriter.Write()
+type FlowController interface {
+	WaitWritable(ctx context.Context) error
+}
+
+// ErrStreamClosed is returned by StreamWriter.Write() after it is closed or the call is finished
+var ErrStreamClosed = errors.New("stream closed")
+
+// StreamWriter sends raw binary to the caller and hooked listeners of a call, in Result.chunk
+// of notifies, instead of JSON-encoding it into Result.stdout. See TreeCallCtx.StreamWriter().
+type StreamWriter struct {
+	ctx         *TreeCallCtx
+	contentType string
+	size        int64
+	offset      int64
+	started     bool
+	closed      bool
+	mutex       sync.Mutex
+}
+
+// StreamWriter starts a binary stream of contentType to browser, size is the total bytes
+// if it is known, otherwise -1. Close() it before Resolve(), ex.
+//
+//	func (self *MyBranch) Thumbnail(ctx *TreeCallCtx) {
+//		f, err := os.Open(ctx.Args[0])
+//		if err != nil {
+//			ctx.Reject(model.RetcodeNotFound, err)
+//			return
+//		}
+//		defer f.Close()
+//		info, _ := f.Stat()
+//		w := ctx.StreamWriter("image/png", info.Size())
+//		n, err := io.Copy(w, f)
+//		if err != nil {
+//			ctx.Reject(model.RetcodeBadRequest, err)
+//			return
+//		}
+//		w.Close()
+//		ctx.Resolve(n)
+//	}
+//
+// Data is sent in chunks of DefaultStreamChunkSize at most, they are not coalesced by SetNotifyPolicy().
+// Write() blocks while the caller is slow to receive, if its listener is a FlowController
+// (ex. a websocket whose outbound queue is half full), and returns error if the call is killed or timed out.
+func (tcCtx *TreeCallCtx) StreamWriter(contentType string, size int64) *StreamWriter {
+	return &StreamWriter{
+		ctx:         tcCtx,
+		contentType: contentType,
+		size:        size,
+	}
+}
+
+// Write implements io.Writer, p is copied
+func (w *StreamWriter) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed || w.ctx.IsFinished() {
+		return 0, ErrStreamClosed
+	}
+	if err := w.ctx.Context().Err(); err != nil {
+		return 0, err
+	}
+	n := 0
+	for len(p) > 0 {
+		size := len(p)
+		if size > DefaultStreamChunkSize {
+			size = DefaultStreamChunkSize
+		}
+		if err := w.wait(); err != nil {
+			return n, err
+		}
+		data := make([]byte, size)
+		copy(data, p[:size])
+		w.send(&Chunk{Data: data})
+		p = p[size:]
+		n += size
+	}
+	return n, nil
+}
+
+// Close sends the end of stream, it is a no-op if it has been closed
+func (w *StreamWriter) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	if w.ctx.IsFinished() {
+		return ErrStreamClosed
+	}
+	w.send(&Chunk{Eof: true})
+	return nil
+}
+
+// wait blocks until the caller could receive another chunk, see FlowController
+func (w *StreamWriter) wait() error {
+	fc, ok := w.ctx.WsCtx.(FlowController)
+	if !ok {
+		return nil
+	}
+	if err := fc.WaitWritable(w.ctx.Context()); err != nil {
+		return err
+	}
+	if w.ctx.IsFinished() {
+		return ErrStreamClosed
+	}
+	return nil
+}
+
+// send is called with w.mutex held, content type and size are given in the first chunk
+func (w *StreamWriter) send(chunk *Chunk) {
+	chunk.Id = w.ctx.CmdID
+	chunk.Offset = w.offset
+	if !w.started {
+		chunk.ContentType = w.contentType
+		chunk.Size = w.size
+		w.started = true
+	}
+	size := int64(len(chunk.Data))
+	w.offset += size
+	Metrics.Add("stream.bytes", size)
+	w.ctx.sendChunk(chunk)
+}
+
+func (tcCtx *TreeCallCtx) sendChunk(chunk *Chunk) {
+	// notifies coalesced before this chunk are sent first
+	tcCtx.flushNotify()
+	tcCtx.promise.Stream(chunk, tcCtx.RetcodeOfNotify)
+	tcCtx.observe(&TreeCallReturn{CmdID: tcCtx.CmdID, Retcode: tcCtx.RetcodeOfNotify, Chunk: chunk})
+}
+
+// AssembledStream is a binary stream reassembled by ChunkAssembler
+type AssembledStream struct {
+	// id of the call
+	Id          int32
+	ContentType string
+	// total bytes given by server, -1 if unknown
+	Size int64
+	Data []byte
+}
+
+// ChunkAssembler reassembles binary streams in Result.chunk by ids of calls, for Go clients, ex.
+//
+//	assembler := model.NewChunkAssembler()
+//	for {
+//		result, err := client.Recv()
+//		if err != nil {
+//			break
+//		}
+//		stream, err := assembler.Add(result.Chunk)
+//		if stream != nil {
+//			ioutil.WriteFile("thumbnail.png", stream.Data, 0644)
+//		}
+//	}
+type ChunkAssembler struct {
+	streams map[int32]*AssembledStream
+	mutex   sync.Mutex
+}
+
+// NewChunkAssembler returns an empty ChunkAssembler
+func NewChunkAssembler() *ChunkAssembler {
+	return &ChunkAssembler{streams: make(map[int32]*AssembledStream)}
+}
+
+// Add appends chunk to the stream of its call, the stream is returned when chunk is the last one.
+// A chunk out of order drops the stream and returns error. Add(nil) is a no-op.
+func (a *ChunkAssembler) Add(chunk *Chunk) (*AssembledStream, error) {
+	if chunk == nil {
+		return nil, nil
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	stream := a.streams[chunk.Id]
+	if stream == nil {
+		if chunk.Offset != 0 {
+			return nil, fmt.Errorf("chunk at %d of call %d has no start", chunk.Offset, chunk.Id)
+		}
+		stream = &AssembledStream{Id: chunk.Id, ContentType: chunk.ContentType, Size: chunk.Size}
+		a.streams[chunk.Id] = stream
+	}
+	if chunk.Offset != int64(len(stream.Data)) {
+		delete(a.streams, chunk.Id)
+		return nil, fmt.Errorf("chunk at %d of call %d is out of order, expect %d", chunk.Offset, chunk.Id, len(stream.Data))
+	}
+	stream.Data = append(stream.Data, chunk.Data...)
+	if !chunk.Eof {
+		return nil, nil
+	}
+	delete(a.streams, chunk.Id)
+	return stream, nil
+}
+
+// Drop discards the incomplete stream of a call, ex. the call is rejected
+func (a *ChunkAssembler) Drop(id int32) {
+	a.mutex.Lock()
+	delete(a.streams, id)
+	a.mutex.Unlock()
+}
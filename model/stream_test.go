@@ -0,0 +1,174 @@
+package model
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// chunkRecorder is a background call which records chunks streamed by it
+type chunkRecorder struct {
+	ctx    *TreeCallCtx
+	chunks []*Chunk
+	mutex  sync.Mutex
+}
+
+func newChunkRecorder(root *TreeRoot) *chunkRecorder {
+	recorder := &chunkRecorder{}
+	recorder.ctx = root.newInternalCallCtx(nil, nil, nil, func(ret *TreeCallReturn) {
+		if ret.Chunk != nil {
+			recorder.mutex.Lock()
+			recorder.chunks = append(recorder.chunks, ret.Chunk)
+			recorder.mutex.Unlock()
+		}
+	})
+	return recorder
+}
+
+func (recorder *chunkRecorder) received() []*Chunk {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	return append([]*Chunk{}, recorder.chunks...)
+}
+
+func TestStreamWriterChunks(t *testing.T) {
+	saved := DefaultStreamChunkSize
+	DefaultStreamChunkSize = 4
+	defer func() { DefaultStreamChunkSize = saved }()
+
+	recorder := newChunkRecorder(newTestRoot())
+	w := recorder.ctx.StreamWriter("text/plain", 10)
+	p := []byte("abcdefghij")
+	if n, err := w.Write(p); n != 10 || err != nil {
+		t.Fatalf("expect 10 bytes written, got %d %v", n, err)
+	}
+	// p is copied
+	p[0] = 'x'
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("expect closing twice a no-op, got %v", err)
+	}
+	if _, err := w.Write([]byte("k")); err != ErrStreamClosed {
+		t.Fatalf("expect closed, got %v", err)
+	}
+
+	chunks := recorder.received()
+	expect := []struct {
+		offset int64
+		data   string
+	}{{0, "abcd"}, {4, "efgh"}, {8, "ij"}, {10, ""}}
+	if len(chunks) != len(expect) {
+		t.Fatalf("expect %d chunks, got %v", len(expect), chunks)
+	}
+	for i, chunk := range chunks {
+		if chunk.Offset != expect[i].offset || string(chunk.Data) != expect[i].data || chunk.Eof != (i == len(chunks)-1) {
+			t.Errorf("chunk %d: unexpected %v", i, chunk)
+		}
+		if first := i == 0; (chunk.ContentType == "text/plain" && chunk.Size == 10) != first {
+			t.Errorf("chunk %d: expect content type and size in the first chunk only, got %v", i, chunk)
+		}
+	}
+
+	assembler := NewChunkAssembler()
+	for i, chunk := range chunks {
+		stream, err := assembler.Add(chunk)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (stream != nil) != (i == len(chunks)-1) {
+			t.Fatalf("chunk %d: unexpected stream %v", i, stream)
+		}
+		if stream != nil && (string(stream.Data) != "abcdefghij" || stream.ContentType != "text/plain" || stream.Size != 10) {
+			t.Fatalf("unexpected stream %v", stream)
+		}
+	}
+
+	// nothing is streamed after the call is finished
+	recorder = newChunkRecorder(newTestRoot())
+	w = recorder.ctx.StreamWriter("text/plain", -1)
+	recorder.ctx.Resolve(nil)
+	if _, err := w.Write(p); err != ErrStreamClosed {
+		t.Fatalf("expect closed, got %v", err)
+	}
+	if err := w.Close(); err != ErrStreamClosed {
+		t.Fatalf("expect closed, got %v", err)
+	}
+	if chunks := recorder.received(); len(chunks) != 0 {
+		t.Fatalf("expect nothing streamed, got %v", chunks)
+	}
+}
+
+func TestChunkAssemblerErrors(t *testing.T) {
+	assembler := NewChunkAssembler()
+	if stream, err := assembler.Add(nil); stream != nil || err != nil {
+		t.Fatalf("expect Add(nil) a no-op, got %v %v", stream, err)
+	}
+	if _, err := assembler.Add(&Chunk{Id: 1, Offset: 4, Data: []byte("efgh")}); err == nil || !strings.Contains(err.Error(), "no start") {
+		t.Fatalf("expect no start, got %v", err)
+	}
+	assembler.Add(&Chunk{Id: 1, Data: []byte("abcd")})
+	assembler.Add(&Chunk{Id: 2, Data: []byte("ABCD")})
+	if _, err := assembler.Add(&Chunk{Id: 1, Offset: 8, Data: []byte("ij")}); err == nil || !strings.Contains(err.Error(), "out of order") {
+		t.Fatalf("expect out of order, got %v", err)
+	}
+	// the stream is dropped, others are kept
+	if _, err := assembler.Add(&Chunk{Id: 1, Offset: 4, Data: []byte("efgh")}); err == nil {
+		t.Fatal("expect the stream dropped")
+	}
+	stream, err := assembler.Add(&Chunk{Id: 2, Offset: 4, Eof: true})
+	if err != nil || stream == nil || string(stream.Data) != "ABCD" {
+		t.Fatalf("expect ABCD, got %v %v", stream, err)
+	}
+	assembler.Add(&Chunk{Id: 3, Data: []byte("abcd")})
+	assembler.Drop(3)
+	if _, err := assembler.Add(&Chunk{Id: 3, Offset: 4, Eof: true}); err == nil {
+		t.Fatal("expect the stream dropped")
+	}
+}
+
+// flowListener is a FlowController which lets a chunk through per room
+type flowListener struct {
+	*SimplePromiseStateListener
+	room chan struct{}
+}
+
+func (l *flowListener) WaitWritable(ctx context.Context) error {
+	select {
+	case <-l.room:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func TestStreamWriterWaitsWritable(t *testing.T) {
+	saved := DefaultStreamChunkSize
+	DefaultStreamChunkSize = 4
+	defer func() { DefaultStreamChunkSize = saved }()
+
+	listener := &flowListener{&SimplePromiseStateListener{id: "flow"}, make(chan struct{})}
+	kw := map[string]string{}
+	ctx := NewTreeCallCtx(newTestRoot(), 1, listener, nil, &kw, nil)
+	w := ctx.StreamWriter("", -1)
+	written := make(chan int, 1)
+	go func() {
+		n, _ := w.Write([]byte("abcdefghij"))
+		written <- n
+	}()
+	listener.room <- struct{}{}
+	listener.room <- struct{}{}
+	select {
+	case n := <-written:
+		t.Fatalf("expect blocked, got %d bytes written", n)
+	case <-time.After(20 * time.Millisecond):
+	}
+	// a killed call stops waiting
+	ctx.Kill()
+	if n := <-written; n != 8 {
+		t.Fatalf("expect 8 bytes written, got %d", n)
+	}
+}
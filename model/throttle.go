@@ -0,0 +1,170 @@
+package model
+
+import (
+	"sync"
+	"time"
+)
+
+// modes of NotifyPolicy
+const (
+	// only the latest message of an interval is sent
+	NotifyLatest = "latest"
+	// messages of an interval are sent together, see NotifyPolicy.Merge
+	NotifyBatch = "batch"
+)
+
+// NotifyPolicy coalesces Notify() and Progress() of a call, see TreeCallCtx.SetNotifyPolicy().
+// Messages are sent by another goroutine, so a slow websocket does not block the caller of Notify().
+type NotifyPolicy struct {
+	// min interval between two messages
+	Interval time.Duration
+	// NotifyLatest or NotifyBatch, default is NotifyLatest
+	Mode string
+	// max number of pending messages of NotifyBatch, they are sent at once when it is reached,
+	// so the caller of Notify() is blocked until they are sent (back-pressure). default is 1000
+	MaxPending int
+	// merges pending messages of NotifyBatch into one message, default sends them as an array
+	Merge func(pending []interface{}) interface{}
+}
+
+// MergeStrings is a Merge of NotifyPolicy which concatenates string messages
+func MergeStrings(pending []interface{}) interface{} {
+	buf := make([]byte, 0)
+	for _, stdout := range pending {
+		if s, ok := stdout.(string); ok {
+			buf = append(buf, s...)
+		}
+	}
+	return string(buf)
+}
+
+// notifyThrottle keeps pending messages of a call until they are sent
+type notifyThrottle struct {
+	ctx     *TreeCallCtx
+	policy  NotifyPolicy
+	pending []interface{}
+	// the latest progress which has not been sent
+	progress *Progress
+	// time of last sending
+	last time.Time
+	// true if a flush has been scheduled
+	scheduled bool
+	mutex     sync.Mutex
+	// keeps the order of messages between flushes
+	sending sync.Mutex
+}
+
+func newNotifyThrottle(ctx *TreeCallCtx, policy *NotifyPolicy) *notifyThrottle {
+	throttle := &notifyThrottle{ctx: ctx, policy: *policy}
+	if throttle.policy.Mode == "" {
+		throttle.policy.Mode = NotifyLatest
+	}
+	if throttle.policy.MaxPending <= 0 {
+		throttle.policy.MaxPending = 1000
+	}
+	return throttle
+}
+
+func (throttle *notifyThrottle) add(stdout interface{}) {
+	throttle.mutex.Lock()
+	if throttle.policy.Mode == NotifyBatch {
+		if len(throttle.pending) >= throttle.policy.MaxPending {
+			// flush early instead of dropping messages
+			throttle.mutex.Unlock()
+			Metrics.Incr("notify.overflow")
+			throttle.flush()
+			throttle.mutex.Lock()
+		}
+		throttle.pending = append(throttle.pending, stdout)
+	} else {
+		if len(throttle.pending) > 0 {
+			Metrics.Incr("notify.coalesced")
+		}
+		throttle.pending = []interface{}{stdout}
+	}
+	throttle.schedule()
+	throttle.mutex.Unlock()
+}
+
+func (throttle *notifyThrottle) addProgress(progress *Progress) {
+	throttle.mutex.Lock()
+	throttle.progress = progress
+	throttle.schedule()
+	throttle.mutex.Unlock()
+}
+
+// schedule starts a flush after interval, caller should hold throttle.mutex
+func (throttle *notifyThrottle) schedule() {
+	if throttle.scheduled {
+		return
+	}
+	throttle.scheduled = true
+	delay := throttle.policy.Interval - time.Since(throttle.last)
+	if delay < 0 {
+		delay = 0
+	}
+	time.AfterFunc(delay, throttle.flush)
+}
+
+// flush sends pending messages
+func (throttle *notifyThrottle) flush() {
+	throttle.sending.Lock()
+	defer throttle.sending.Unlock()
+	throttle.mutex.Lock()
+	pending, progress := throttle.pending, throttle.progress
+	throttle.pending, throttle.progress = nil, nil
+	throttle.scheduled = false
+	throttle.last = time.Now()
+	throttle.mutex.Unlock()
+
+	ctx := throttle.ctx
+	if ctx.IsFinished() {
+		return
+	}
+	if progress != nil {
+		ctx.sendProgress(progress)
+	}
+	if len(pending) == 0 {
+		return
+	}
+	var stdout interface{}
+	if throttle.policy.Mode == NotifyBatch {
+		if throttle.policy.Merge != nil {
+			stdout = throttle.policy.Merge(pending)
+		} else {
+			stdout = pending
+		}
+	} else {
+		stdout = pending[0]
+	}
+	ctx.sendNotify(stdout)
+}
+
+// SetNotifyPolicy coalesces messages of Notify() and Progress() of this call by policy, nil to stop it.
+// Pending messages are sent before Resolve() and Reject().
+//
+//	// send output of a command at most 5 times per second
+//	ctx.SetNotifyPolicy(&model.NotifyPolicy{Interval: 200 * time.Millisecond, Mode: model.NotifyBatch, Merge: model.MergeStrings})
+func (tcCtx *TreeCallCtx) SetNotifyPolicy(policy *NotifyPolicy) {
+	tcCtx.mutex.Lock()
+	throttle := tcCtx.throttle
+	if policy == nil {
+		tcCtx.throttle = nil
+	} else {
+		tcCtx.throttle = newNotifyThrottle(tcCtx, policy)
+	}
+	tcCtx.mutex.Unlock()
+	if throttle != nil {
+		throttle.flush()
+	}
+}
+
+// flushNotify sends pending messages of NotifyPolicy, it is called before the call is finished
+func (tcCtx *TreeCallCtx) flushNotify() {
+	tcCtx.mutex.RLock()
+	throttle := tcCtx.throttle
+	tcCtx.mutex.RUnlock()
+	if throttle != nil {
+		throttle.flush()
+	}
+}
@@ -0,0 +1,107 @@
+package model
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// notifyRecorder is a background call which records its notifies and progresses in order
+type notifyRecorder struct {
+	ctx      *TreeCallCtx
+	messages []interface{}
+	mutex    sync.Mutex
+}
+
+// newNotifyRecorder returns a call with policy, whose first flush is not due until policy.Interval passes
+func newNotifyRecorder(root *TreeRoot, policy *NotifyPolicy) *notifyRecorder {
+	recorder := &notifyRecorder{}
+	recorder.ctx = root.newInternalCallCtx(nil, nil, nil, func(ret *TreeCallReturn) {
+		recorder.mutex.Lock()
+		defer recorder.mutex.Unlock()
+		switch {
+		case ret.Retcode >= 0:
+			recorder.messages = append(recorder.messages, "finished")
+		case ret.Progress != nil:
+			recorder.messages = append(recorder.messages, ret.Progress.Percent)
+		default:
+			recorder.messages = append(recorder.messages, ret.Stdout)
+		}
+	})
+	recorder.ctx.SetNotifyPolicy(policy)
+	recorder.ctx.throttle.last = time.Now()
+	return recorder
+}
+
+func (recorder *notifyRecorder) received() []interface{} {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	return append([]interface{}{}, recorder.messages...)
+}
+
+func TestThrottleLatest(t *testing.T) {
+	recorder := newNotifyRecorder(newTestRoot(), &NotifyPolicy{Interval: time.Hour})
+	for i := 1; i <= 10; i++ {
+		recorder.ctx.Notify(i)
+		recorder.ctx.Progress(&Progress{Percent: float32(i * 10), Eta: -1})
+	}
+	if received := recorder.received(); len(received) != 0 {
+		t.Fatalf("expect nothing sent before the interval, got %v", received)
+	}
+	// pending messages are sent before the result
+	recorder.ctx.Resolve(nil)
+	expect := []interface{}{float32(100), 10, "finished"}
+	if received := recorder.received(); !reflect.DeepEqual(received, expect) {
+		t.Fatalf("expect %v, got %v", expect, received)
+	}
+}
+
+func TestThrottleBatch(t *testing.T) {
+	recorder := newNotifyRecorder(newTestRoot(), &NotifyPolicy{Interval: time.Hour, Mode: NotifyBatch, MaxPending: 2})
+	for _, s := range []string{"a", "b", "c", "d", "e"} {
+		recorder.ctx.Notify(s)
+	}
+	recorder.ctx.Resolve(nil)
+	// it is flushed early when MaxPending is reached
+	expect := []interface{}{[]interface{}{"a", "b"}, []interface{}{"c", "d"}, []interface{}{"e"}, "finished"}
+	if received := recorder.received(); !reflect.DeepEqual(received, expect) {
+		t.Fatalf("expect %v, got %v", expect, received)
+	}
+
+	recorder = newNotifyRecorder(newTestRoot(), &NotifyPolicy{Interval: time.Hour, Mode: NotifyBatch, Merge: MergeStrings})
+	for _, s := range []string{"a", "b", "c"} {
+		recorder.ctx.Notify(s)
+	}
+	recorder.ctx.Reject(RetcodeJobFailed, nil)
+	expect = []interface{}{"abc", "finished"}
+	if received := recorder.received(); !reflect.DeepEqual(received, expect) {
+		t.Fatalf("expect %v, got %v", expect, received)
+	}
+}
+
+func TestThrottleInterval(t *testing.T) {
+	recorder := newNotifyRecorder(newTestRoot(), &NotifyPolicy{Interval: 20 * time.Millisecond})
+	recorder.ctx.Notify("x")
+	waitFor(t, "the flush", func() bool { return len(recorder.received()) == 1 })
+
+	// nothing is sent after it was finished
+	recorder.ctx.Notify("y")
+	recorder.ctx.Resolve(nil)
+	recorder.ctx.Notify("z")
+	time.Sleep(50 * time.Millisecond)
+	expect := []interface{}{"x", "y", "finished"}
+	if received := recorder.received(); !reflect.DeepEqual(received, expect) {
+		t.Fatalf("expect %v, got %v", expect, received)
+	}
+
+	// stopping the policy sends pending messages
+	recorder = newNotifyRecorder(newTestRoot(), &NotifyPolicy{Interval: time.Hour})
+	recorder.ctx.Notify("x")
+	recorder.ctx.SetNotifyPolicy(nil)
+	recorder.ctx.Notify("y")
+	expect = []interface{}{"x", "y"}
+	if received := recorder.received(); !reflect.DeepEqual(received, expect) {
+		t.Fatalf("expect %v, got %v", expect, received)
+	}
+}
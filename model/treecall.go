@@ -24,11 +24,14 @@ Task vs gRPC
 		document, images, authentication, .....
 	- gPRC has no idea of communities, but Task is based on the community of a web server.
 		gPRC has no idea of "user" and "inter-users" feature, which Task/Web take for granted.
+	- Still, backend services could call branches over gRPC as a user, see package rpc.
 */
 
 package model
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
@@ -36,9 +39,11 @@ import (
 	"path/filepath"
 	"reflect"
 	"runtime"
+	"runtime/debug"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	proto "github.com/golang/protobuf/proto"
@@ -47,6 +52,10 @@ import (
 
 type TreeOptions struct{
     WebsocketOptions *WebsocketOptions
+	// if not empty, calls are also served over HTTP at this path (ex. /tree), see HTTPGateway
+	HTTPPath string
+	// if not empty, progress of jobs is streamed by server-sent events at this path (ex. /events), see SSEHub
+	SSEPath string
 }
 
 type TreeCallReturn struct {
@@ -54,6 +63,10 @@ type TreeCallReturn struct {
 	Retcode int32
 	Stderr  error
 	Stdout  interface{}
+	// structured progress, see TreeCallCtx.Progress()
+	Progress *Progress
+	// a piece of binary stream, see TreeCallCtx.StreamWriter()
+	Chunk *Chunk
 }
 
 // TreeCallCtxBank temporary stores TreeCallCtx before it is termincalted.
@@ -61,7 +74,9 @@ type TreeCallReturn struct {
 type TreeCallCtxBank struct {
 	storeByID   map[int32]*TreeCallCtx
 	storeByUser map[string][]int32 //username : []tcCtx.CmdID
-	Mutex       sync.RWMutex
+	// username : token : callback, see Watch()
+	watchers map[string]map[string]func(*TreeCallCtx)
+	Mutex    sync.RWMutex
 }
 
 func (bank *TreeCallCtxBank) Put(tcCtx *TreeCallCtx) error {
@@ -77,9 +92,36 @@ func (bank *TreeCallCtxBank) Put(tcCtx *TreeCallCtx) error {
 		} else {
 			bank.storeByUser[username] = []int32{tcCtx.CmdID}
 		}
+		for _, fn := range bank.watchers[username] {
+			fn(tcCtx)
+		}
     }
     return nil
 }
+
+// Watch calls fn with every call of username put into this bank, until Unwatch(username, token).
+// fn is called with the bank and the promise of the call locked, it should not block.
+func (bank *TreeCallCtxBank) Watch(username string, token string, fn func(*TreeCallCtx)) {
+	bank.Mutex.Lock()
+	defer bank.Mutex.Unlock()
+	if bank.watchers == nil {
+		bank.watchers = make(map[string]map[string]func(*TreeCallCtx))
+	}
+	if _, ok := bank.watchers[username]; !ok {
+		bank.watchers[username] = make(map[string]func(*TreeCallCtx))
+	}
+	bank.watchers[username][token] = fn
+}
+func (bank *TreeCallCtxBank) Unwatch(username string, token string) {
+	bank.Mutex.Lock()
+	defer bank.Mutex.Unlock()
+	if watchers, ok := bank.watchers[username]; ok {
+		delete(watchers, token)
+		if len(watchers) == 0 {
+			delete(bank.watchers, username)
+		}
+	}
+}
 func (bank *TreeCallCtxBank) Get(CmdID int32) *TreeCallCtx {
 	bank.Mutex.RLock()
 	defer bank.Mutex.RUnlock()
@@ -371,6 +413,38 @@ func (p *Promise) Resolve(stdout interface{}, retcode int32) {
 	}
 	p.mutex.RUnlock()
 }
+// Progress sends structured progress with retcode of notify (-1 or -2)
+func (p *Promise) Progress(progress *Progress, retcode int32) {
+	ret := TreeCallReturn{
+		CmdID:    p.CmdID,
+		Retcode:  retcode,
+		Progress: progress,
+	}
+	p.mutex.RLock()
+	if p.stateListener != nil && !p.stateListener.IsClosed() {
+		p.stateListener.SendTreeCallReturn(&ret)
+	}
+	for _, stateListener := range p.hookedStateListeners {
+		stateListener.SendTreeCallReturn(&ret)
+	}
+	p.mutex.RUnlock()
+}
+// Stream sends a piece of binary stream with retcode of notify (-1 or -2)
+func (p *Promise) Stream(chunk *Chunk, retcode int32) {
+	ret := TreeCallReturn{
+		CmdID:   p.CmdID,
+		Retcode: retcode,
+		Chunk:   chunk,
+	}
+	p.mutex.RLock()
+	if p.stateListener != nil && !p.stateListener.IsClosed() {
+		p.stateListener.SendTreeCallReturn(&ret)
+	}
+	for _, stateListener := range p.hookedStateListeners {
+		stateListener.SendTreeCallReturn(&ret)
+	}
+	p.mutex.RUnlock()
+}
 func (p *Promise) Reject(retcode int32, err error) {
 	defer p.clean()
 	ret := TreeCallReturn{
@@ -427,6 +501,46 @@ type TreeCallCtx struct {
 	// Call's path and username, <TreeName.BranchName,FuncName>\t<username>
 	// username is for checking before killing this task when it goes to background
 	CmdPath string
+	// Call's path, <TreeName>.<branch path>.<FuncName>, set by TreeRoot.Call
+	NodePath string
+	// observers of Notify, Resolve and Reject, see Observe()
+	observers []TreeCallObserver
+	mutex     sync.RWMutex
+	// 1 after Resolve or Reject
+	finished int32
+	// cancelled when this call is finished, killed or timed out, see Context()
+	context context.Context
+	cancel  context.CancelFunc
+	// fires when deadline is exceeded, see SetDeadline()
+	timer *time.Timer
+	// true if this call has been put into Root.Bank
+	inBank bool
+	// the job in Root.Scheduler, see Enqueue()
+	job *scheduledJob
+	// calls the exportable (with middlewares), set by TreeRoot.Call for retrying
+	invoke func()
+	// see Attempts()
+	attempts int32
+	// the call which issued this call, see CallChild()
+	parent *TreeCallCtx
+	// the workflow run by this call, see RunWorkflow()
+	workflow *workflowRun
+	// the saved state of a resumable call, see Checkpoint()
+	checkpoint *Checkpoint
+	// the latest progress, see Progress()
+	progress *Progress
+	// coalesces Notify() and Progress(), see SetNotifyPolicy()
+	throttle *notifyThrottle
+	// the websocket which counts this call as active until it is finished
+	countedBy *WebsocketCtx
+	// true if this call could be paused, see Pausable()
+	pausable bool
+	// not nil while this call is paused, it is closed by Resume()
+	resumed        chan struct{}
+	pauseListener  []func()
+	resumeListener []func()
+	// the binary stream sent along with this call, see Upload()
+	upload *Upload
 }
 
 // SetBackground
@@ -472,25 +586,84 @@ The call-style (or "routing" rules) of a call from browser are: (ObjshSDK.Tree.c
 
 func (tcCtx *TreeCallCtx) Resolve(stdout interface{}) {
     fmt.Println("ctx resolved",tcCtx.CmdID)
+	tcCtx.flushNotify()
+	atomic.StoreInt32(&tcCtx.finished, 1)
 	tcCtx.promise.Resolve(stdout, 0)
 	tcCtx.clean()
+	tcCtx.observe(&TreeCallReturn{CmdID: tcCtx.CmdID, Retcode: 0, Stdout: stdout})
 }
 func (tcCtx *TreeCallCtx) Notify(stdout interface{}) {
+	tcCtx.mutex.RLock()
+	throttle := tcCtx.throttle
+	tcCtx.mutex.RUnlock()
+	if throttle != nil {
+		// coalesced and sent later, see SetNotifyPolicy()
+		throttle.add(stdout)
+		return
+	}
+	tcCtx.sendNotify(stdout)
+}
+func (tcCtx *TreeCallCtx) sendNotify(stdout interface{}) {
 	tcCtx.promise.Resolve(stdout, tcCtx.RetcodeOfNotify)
+	tcCtx.observe(&TreeCallReturn{CmdID: tcCtx.CmdID, Retcode: tcCtx.RetcodeOfNotify, Stdout: stdout})
 }
 func (tcCtx *TreeCallCtx) Reject(retcode int32, err error) {
+	// a background call might be retried, see RetryPolicy
+	if tcCtx.retryOrBury(retcode, err) {
+		return
+	}
+	tcCtx.flushNotify()
+	atomic.StoreInt32(&tcCtx.finished, 1)
 	tcCtx.promise.Reject(retcode, err)
 	tcCtx.clean()
+	tcCtx.observe(&TreeCallReturn{CmdID: tcCtx.CmdID, Retcode: retcode, Stderr: err})
 }
 
 // DirectResult is called to avoid stdout, stderr been jsonize again.
 // For exmaple: python branch's call result.
 // "clean" should be true, for resolve and reject
 func (tcCtx *TreeCallCtx) DirectResult(result *Result, clean bool) {
+	if clean {
+		tcCtx.flushNotify()
+		atomic.StoreInt32(&tcCtx.finished, 1)
+	}
 	tcCtx.promise.DirectResult(result)
 	if clean {
 		tcCtx.clean()
 	}
+	ret := &TreeCallReturn{CmdID: tcCtx.CmdID, Retcode: result.Retcode}
+	if result.Retcode <= 0 {
+		ret.Stdout = json.RawMessage(result.Stdout)
+	} else {
+		ret.Stderr = errors.New(result.Stderr)
+	}
+	tcCtx.observe(ret)
+}
+
+// IsFinished tells if Resolve or Reject has been called
+func (tcCtx *TreeCallCtx) IsFinished() bool {
+	return atomic.LoadInt32(&tcCtx.finished) == 1
+}
+
+// Go runs fn in a goroutine, a panic in fn rejects this call (RetcodePanic) instead of crashing the server.
+func (tcCtx *TreeCallCtx) Go(fn func()) {
+	go func() {
+		defer tcCtx.recoverPanic()
+		fn()
+	}()
+}
+
+// recoverPanic turns a panic to Reject(RetcodePanic), it should be deferred
+func (tcCtx *TreeCallCtx) recoverPanic() {
+	r := recover()
+	if r == nil {
+		return
+	}
+	log.Printf("[panic] %v (%v): %v\n%s", tcCtx.NodePath, tcCtx.CmdID, r, debug.Stack())
+	Metrics.Incr("treecall.panic")
+	if !tcCtx.IsFinished() {
+		tcCtx.Reject(RetcodePanic, NewTreeCallError(RetcodePanic, "internal error", map[string]string{"path": tcCtx.NodePath}))
+	}
 }
 
 /*
@@ -510,14 +683,21 @@ func (tcCtx *TreeCallCtx) DirectResult(result *Result, clean bool) {
 func (tcCtx *TreeCallCtx) On(evtName string, callback func()) error{
 	if evtName == "Kill" {
         tcCtx.promise.mutex.Lock()
-		if len(tcCtx.killListener) == 0 {
-            error := tcCtx.Root.Bank.Put(tcCtx); 
-            if error != nil{
-                panic("On(Kill) Error")
-            }
+		if err := tcCtx.putInBank(); err != nil {
+			panic("On(Kill) Error")
 		}
         tcCtx.killListener = append(tcCtx.killListener, callback)
         tcCtx.promise.mutex.Unlock()
+	} else if evtName == "Pause" || evtName == "Resume" {
+		// listeners of Pause() and Resume(), the call turns to be pausable
+		tcCtx.Pausable()
+		tcCtx.mutex.Lock()
+		if evtName == "Pause" {
+			tcCtx.pauseListener = append(tcCtx.pauseListener, callback)
+		} else {
+			tcCtx.resumeListener = append(tcCtx.resumeListener, callback)
+		}
+		tcCtx.mutex.Unlock()
 	} else {
 		panic("TreeCallCtx has no event named: " + evtName)
     }
@@ -526,17 +706,22 @@ func (tcCtx *TreeCallCtx) On(evtName string, callback func()) error{
 
 //Kill is called by user or auto called when websocket closed (for foreground task)
 func (tcCtx *TreeCallCtx) Kill() {
+	tcCtx.kill(RetcodeKilled, errors.New("job killed"))
+}
+
+// kill rejects this call with retcode, cancels its context and fires the "Kill" listeners
+func (tcCtx *TreeCallCtx) kill(retcode int32, err error) {
 	// When WebSocket is closed, Kill() of all TreeCallCtx will be called.
 	// But if one of TreeCallCtx.Kill() is called, TreeCallCtx is not necessary Closed
 	if tcCtx.promise != nil {
         // tcCtx.Reject() will call "tcCtx.clean()", which will
         // call "tcCtx.promise.clean()" 
 		// and " tcCtx.Root.Bank.Del(tcCtx.CmdID) "
-        tcCtx.Reject(500,errors.New("job killed"))
+        tcCtx.Reject(retcode, err)
 	}else{
-        //目前，只有當On(Kill)有listeners時才會放到bank內
+        //目前，只有當On(Kill)或Context()被呼叫時才會放到bank內
         tcCtx.Root.Bank.Del(tcCtx.CmdID)
-
+		tcCtx.cancelContext()
     }
 
 	if tcCtx.killListener != nil {
@@ -550,6 +735,108 @@ func (tcCtx *TreeCallCtx) Kill() {
 	tcCtx.killListener = nil
 }
 
+// putInBank puts this call into Root.Bank once, then it can be killed by KillPeer
+// and be listed in $.ListUserTasks. Caller should hold promise.mutex.
+func (tcCtx *TreeCallCtx) putInBank() error {
+	// a child call (ex. a step of workflow) is killed by its parent
+	if tcCtx.inBank || tcCtx.Root == nil || tcCtx.parent != nil {
+		return nil
+	}
+	if err := tcCtx.Root.Bank.Put(tcCtx); err != nil {
+		return err
+	}
+	tcCtx.inBank = true
+	return nil
+}
+
+// Context returns a context.Context which is cancelled when this call is
+// resolved, rejected, killed (by user or by losing connection of a foreground call)
+// or its deadline is exceeded. Pass it to DB queries, HTTP requests and so on:
+//
+//	func (self *MyBranch) Query(ctx *TreeCallCtx) {
+//		rows, err := self.db.QueryContext(ctx.Context(), "SELECT ...")
+//		...
+//	}
+//
+// Calling Context() makes this call killable by user, like On("Kill") does.
+func (tcCtx *TreeCallCtx) Context() context.Context {
+	tcCtx.killable()
+	tcCtx.mutex.RLock()
+	defer tcCtx.mutex.RUnlock()
+	return tcCtx.context
+}
+
+// killable puts this call into Root.Bank, then user can kill it
+func (tcCtx *TreeCallCtx) killable() {
+	// Bank.Del() needs CmdPath when user is gone, it is set by TreeRoot.Call
+	if tcCtx.promise == nil || tcCtx.CmdPath == "" {
+		return
+	}
+	tcCtx.promise.mutex.Lock()
+	defer tcCtx.promise.mutex.Unlock()
+	if err := tcCtx.putInBank(); err != nil {
+		log.Println("TreeCallCtx.killable():", err)
+	}
+}
+
+// SetTimeout is a shortcut of SetDeadline(time.Now().Add(timeout))
+func (tcCtx *TreeCallCtx) SetTimeout(timeout time.Duration) {
+	tcCtx.SetDeadline(time.Now().Add(timeout))
+}
+
+// SetDeadline sets the time by which this call should be completed.
+// When it is exceeded, the context is cancelled and the call is rejected with RetcodeTimeout.
+// The earliest one is effective if it is called more than once.
+func (tcCtx *TreeCallCtx) SetDeadline(deadline time.Time) {
+	if tcCtx.IsFinished() {
+		return
+	}
+	tcCtx.mutex.Lock()
+	defer tcCtx.mutex.Unlock()
+	if current, ok := tcCtx.context.Deadline(); ok && !deadline.Before(current) {
+		return
+	}
+	ctx, cancel := context.WithDeadline(tcCtx.context, deadline)
+	parentCancel := tcCtx.cancel
+	tcCtx.context = ctx
+	tcCtx.cancel = func() {
+		cancel()
+		parentCancel()
+	}
+	if tcCtx.timer != nil {
+		tcCtx.timer.Stop()
+	}
+	timeout := time.Until(deadline)
+	tcCtx.timer = time.AfterFunc(timeout, func() {
+		if tcCtx.IsFinished() {
+			return
+		}
+		// NodePath is set by TreeRoot.Call, which might be later than SetDeadline()
+		tcCtx.mutex.RLock()
+		nodePath := tcCtx.NodePath
+		tcCtx.mutex.RUnlock()
+		log.Println("[timeout]", nodePath, tcCtx.CmdID)
+		Metrics.Incr("treecall.timeout")
+		tcCtx.kill(RetcodeTimeout, NewTreeCallError(RetcodeTimeout, "deadline exceeded", map[string]string{
+			"path":    nodePath,
+			"timeout": timeout.String(),
+		}))
+	})
+}
+
+// cancelContext cancels the context and stops the deadline timer
+func (tcCtx *TreeCallCtx) cancelContext() {
+	tcCtx.mutex.Lock()
+	defer tcCtx.mutex.Unlock()
+	if tcCtx.timer != nil {
+		tcCtx.timer.Stop()
+		tcCtx.timer = nil
+	}
+	if tcCtx.cancel != nil {
+		tcCtx.cancel()
+	}
+}
+
 //KillPeer kill other existing TreeCallCtx
 // usually, this kill is oriented from browser
 func (tcCtx *TreeCallCtx) KillPeer(cmdID int32) error {
@@ -575,6 +862,16 @@ func (tcCtx *TreeCallCtx) clean() {
     
     // remove initially setup tcCtx.Kill by default
     tcCtx.WsCtx.Off("Close", onAndOffID)
+
+	tcCtx.cancelContext()
+
+	tcCtx.mutex.Lock()
+	ws := tcCtx.countedBy
+	tcCtx.countedBy = nil
+	tcCtx.mutex.Unlock()
+	if ws != nil {
+		atomic.AddInt32(&ws.calls, -1)
+	}
     
     /*
     if tcCtx.background{
@@ -602,6 +899,7 @@ func NewTreeCallCtx(root *TreeRoot, CmdID int32, wsCtx PromiseStateListener, arg
 		promise:         NewPromise(CmdID, wsCtx),
 		RetcodeOfNotify: int32(-1),
 	}
+	tcCtx.context, tcCtx.cancel = context.WithCancel(context.Background())
 	if message != nil {
 		tcCtx.Message = message
 	}
@@ -609,6 +907,16 @@ func NewTreeCallCtx(root *TreeRoot, CmdID int32, wsCtx PromiseStateListener, arg
 		tcCtx.Root = root
 	}
 
+	ws, _ := wsCtx.(*WebsocketCtx)
+	if session, ok := wsCtx.(*ResumableSession); ok {
+		ws = session.Websocket()
+	}
+	if ws != nil {
+		// see SessionInfo.Calls
+		atomic.AddInt32(&ws.calls, 1)
+		tcCtx.countedBy = ws
+	}
+
 	tcCtx.background = true //enforce SetBackground(true) to work
 	tcCtx.SetBackground(false)
 
@@ -624,6 +932,7 @@ func NewSimpleTreeCallCtx(root *TreeRoot, CmdID int32, wsCtx PromiseStateListene
 			stateListener: wsCtx,
 		},
 	}
+	tcCtx.context, tcCtx.cancel = context.WithCancel(context.Background())
 	if root != nil {
 		tcCtx.Root = root
 	}
@@ -653,6 +962,32 @@ type TreeRoot struct {
 	Bank     *TreeCallCtxBank
 	Docs     map[string]*DocItem // "branch-name.func-name" to DocItem
 	IsReady  bool
+	// see Use()
+	middlewares []Middleware
+	// concurrency limits of calls, see CallLimiter
+	Limiter *CallLimiter
+	// worker pools of background jobs, see TreeCallCtx.Enqueue()
+	Scheduler *Scheduler
+	// scheduled calls on cron expressions
+	Cron *Cron
+	// retry policies and dead letters of background calls
+	Retry *RetryManager
+	// persistent storage of the tree, see SetStorage()
+	Storage Dict
+	// resumable calls in Storage, see TreeCallCtx.Checkpoint()
+	checkpoints *checkpoints
+	// max times a resumable call is resumed after restarts, default is 3, 0 for unlimited.
+	// A call which is still not finished is moved to the dead-letter list, see ResumeJobs()
+	MaxResumes int
+	// announcements to connections of the tree
+	Push *PushHub
+	// sessions which survive reconnections of websockets
+	Resumer *SessionResumer
+	// binary streams sent along with calls, see TreeCallCtx.Upload()
+	uploads *uploads
+	// server-side deadline of every call, 0 for no deadline.
+	// A client can set a shorter one by Command.timeout
+	CallTimeout time.Duration
 }
 
 func NewTreeRoot() *TreeRoot {
@@ -677,7 +1012,16 @@ func NewTreeRootWithName(name string) *TreeRoot {
 		Wg:       sync.WaitGroup{},
 		Bank:     NewTreeCallCtxBank(),
 		Docs:     make(map[string]*DocItem),
-	}
+		Limiter:  NewCallLimiter(),
+		Scheduler: NewScheduler(),
+		Push:     NewPushHub(),
+		Resumer:  NewSessionResumer(),
+		uploads:  &uploads{items: make(map[string]*Upload)},
+	}
+	rootTree.Cron = NewCron(&rootTree)
+	rootTree.Retry = NewRetryManager(&rootTree)
+	rootTree.checkpoints = &checkpoints{root: &rootTree}
+	rootTree.MaxResumes = 3
 	return &rootTree
 }
 
@@ -701,10 +1045,15 @@ func (self *TreeRoot) BeReady() {
 		self.Ready <- true
 		return
 	}
-    log.Println("** Waiting branches to be ready of number",len(self.Branches))
-	if len(self.Branches) > 0 {
-		self.Wg.Add(len(self.Branches))
-		for _, branch := range self.Branches {
+	// sub-branches are counted, they call SureReady() too
+	branches := make([]Branch, 0)
+	self.WalkBranches(func(path string, branch Branch) {
+		branches = append(branches, branch)
+	})
+    log.Println("** Waiting branches to be ready of number",len(branches))
+	if len(branches) > 0 {
+		self.Wg.Add(len(branches))
+		for _, branch := range branches {
 			go branch.BeReady(self)
 		}
 		self.Wg.Wait()
@@ -717,21 +1066,34 @@ func (self *TreeRoot) BeReady() {
 	// 2. a branch might set its exporting name (path) at "BeReady()",
 	//    it might be differnt from default name (ex. ChatBranch vs $chat),
 	//    so here we re-correct the mapping key to their new branch name.
-	changedBranchNames := make(map[string]string)
-	for bname, branch := range self.Branches {
+	//    This is done at every level of the tree.
+	rekeyBranches(self.Branches)
 
+	self.ScanAllAPIInfo()
+
+	if self.Storage != nil {
+		// calls interrupted by last shutdown
+		go self.ResumeJobs()
+	}
+}
+
+// rekeyBranches re-correct the mappig key for name-changed branches
+func rekeyBranches(branches map[string]Branch) {
+	changedBranchNames := make(map[string]string)
+	for bname, branch := range branches {
 		if bname != branch.Name() {
 			changedBranchNames[bname] = branch.Name()
 		}
 	}
-	// re-correct the mappig key for name-changed branches
 	for oldname, newname := range changedBranchNames {
-		self.Branches[newname] = self.Branches[oldname]
-		delete(self.Branches, oldname)
+		branches[newname] = branches[oldname]
+		delete(branches, oldname)
+	}
+	for _, branch := range branches {
+		if container, ok := branch.(BranchContainer); ok {
+			rekeyBranches(container.SubBranches())
+		}
 	}
-
-	self.ScanAllAPIInfo()
-
 }
 
 func (self *TreeRoot) ScanAllAPIInfo() {
@@ -760,7 +1122,7 @@ func (self *TreeRoot) ScanAllAPIInfo() {
 		log.Printf("searchingPath=%v\n", spath)
 	}
 	uniqueSourcePaths := make(map[string][][2]string) // source file/folder to exported func
-	for bname, branch := range self.Branches {
+	self.WalkBranches(func(bname string, branch Branch) {
 		var branchname string
 		if t := reflect.TypeOf(branch); t.Kind() == reflect.Ptr {
 			branchname = t.Elem().Name()
@@ -803,7 +1165,7 @@ func (self *TreeRoot) ScanAllAPIInfo() {
 			}
 			uniqueSourcePaths[srcpath] = funcs
 		}
-	}
+	})
 	for srcpath, funcs := range uniqueSourcePaths {
 		finfo, _ := os.Stat(srcpath)
 		ret, err := GetGoDocs(srcpath)
@@ -896,26 +1258,140 @@ func (self *TreeRoot) SetAPIInfo(apiName string, docitem *DocItem) {
 // tree.sys.network.SetInterface 解析的時候，會這樣解析：
 // (tree).(key=第二個～倒數第二個).(最後一個), ex
 // (tree).(sys.network).(SetInterface)
+// A multi-level key adds the branch as a sub-branch of its parent ("sys"),
+// the parent is created as a GroupBranch if it does not exist.
+// The ACL of a parent also restricts its sub-branches, see BaseBranch.SetACL().
 func (self *TreeRoot) AddBranchWithName(branch Branch,name string) {
-	if branch.Name() != name {
-		branch.SetName(name)
+	if idx := strings.LastIndex(name, "."); idx > 0 {
+		parentPath, childName := name[:idx], name[idx+1:]
+		parent, ok := self.GetBranch(parentPath)
+		if !ok {
+			parent = &GroupBranch{}
+			self.AddBranchWithName(parent, parentPath)
+		}
+		container, ok := parent.(branchAdder)
+		if !ok {
+			panic(fmt.Sprintf("%T can not have sub-branches", parent))
+		}
+		container.AddBranchWithName(branch, childName)
+	} else {
+		if branch.Name() != name {
+			branch.SetName(name)
+		}
+		self.Branches[name] = branch
 	}
-	self.Branches[name] = branch
 
 	//如果是reload module的情況，會有此情況發生
 	if self.IsReady {
 		branch.BeReady(self)
+		if container, ok := branch.(BranchContainer); ok {
+			walkBranches("", container.SubBranches(), func(path string, sub Branch) {
+				sub.BeReady(self)
+			})
+		}
 	}
 }
 func (self *TreeRoot) AddBranch(branch Branch) {
-	var name string
+	self.AddBranchWithName(branch, defaultBranchName(branch))
+}
+
+func defaultBranchName(branch Branch) string {
 	if branch.Name() == "" {
 		nameFull := fmt.Sprintf("%T", branch)
-		name = strings.TrimPrefix(filepath.Ext(nameFull), ".")
-	} else {
-		name = branch.Name()
+		return strings.TrimPrefix(filepath.Ext(nameFull), ".")
+	}
+	return branch.Name()
+}
+
+// WalkBranches calls fn with every branch on the tree, parents before their sub-branches.
+// @path is the full path of the branch, ex. "sys.network"
+func (self *TreeRoot) WalkBranches(fn func(path string, branch Branch)) {
+	walkBranches("", self.Branches, fn)
+}
+func walkBranches(prefix string, branches map[string]Branch, fn func(string, Branch)) {
+	for name, branch := range branches {
+		path := prefix + name
+		fn(path, branch)
+		if container, ok := branch.(BranchContainer); ok {
+			walkBranches(path+".", container.SubBranches(), fn)
+		}
+	}
+}
+
+// branchChain returns branches from the top level down to the branch of given path,
+// or nil if the path is not found. ex. "sys.network" returns [sys, network]
+func (self *TreeRoot) branchChain(path string) []Branch {
+	branches := self.Branches
+	names := strings.Split(path, ".")
+	chain := make([]Branch, 0, len(names))
+	for _, name := range names {
+		branch, ok := branches[name]
+		if !ok {
+			return nil
+		}
+		chain = append(chain, branch)
+		if container, ok := branch.(BranchContainer); ok {
+			branches = container.SubBranches()
+		} else {
+			branches = nil
+		}
+	}
+	return chain
+}
+
+// GetBranch returns the branch of given path, ex. "sys.network"
+func (self *TreeRoot) GetBranch(path string) (Branch, bool) {
+	if chain := self.branchChain(path); chain != nil {
+		return chain[len(chain)-1], true
+	}
+	return nil, false
+}
+
+// Accessible tells if caller (ex. a WebsocketCtx) can call the branch of given path.
+// The ACL of every branch in the path is checked as the ACL mode of a route,
+// ex. "sys.network" is not accessible by a guest if "sys" is of ProtectMode. See BaseBranch.SetACL().
+func (self *TreeRoot) Accessible(path string, caller PromiseStateListener) bool {
+	chain := self.branchChain(path)
+	if chain == nil {
+		return false
+	}
+	return chainAccessible(chain, caller.GetUser(), traceIDOf(caller))
+}
+
+// tracedListener is implemented by listeners which know the uuid of a traced guest
+type tracedListener interface {
+	traceID() string
+}
+
+// traceIDOf returns uuid of the traced guest of listener, "" if unknown
+func traceIDOf(listener PromiseStateListener) string {
+	if traced, ok := listener.(tracedListener); ok {
+		return traced.traceID()
+	}
+	return ""
+}
+func chainAccessible(chain []Branch, user User, traceID string) bool {
+	for _, branch := range chain {
+		b, ok := branch.(BranchWithACL)
+		if !ok {
+			continue
+		}
+		switch b.ACL() {
+		case 0, PublicMode:
+		case TraceMode:
+			if user == nil && traceID == "" {
+				return false
+			}
+		case ProtectMode:
+			if user == nil {
+				return false
+			}
+		default:
+			// unknown modes are denied, see SetACL()
+			return false
+		}
 	}
-	self.AddBranchWithName(branch,name)
+	return true
 }
 
 func (self *TreeRoot) Dump() {
@@ -938,9 +1414,10 @@ func (ai *APIInfo) String() string {
 	return ai.Name
 }
 
+// Layout returns exported names of every branch, keyed by full path of branch (ex. "sys.network")
 func (self *TreeRoot) Layout() map[string][]*APIInfo {
 	layout := make(map[string][]*APIInfo)
-	for name, n := range self.Branches {
+	self.WalkBranches(func(name string, n Branch) {
 		apinames := n.GetExportableNames(self)
 		layout[name] = make([]*APIInfo, len(apinames))
 		for i, funcName := range apinames {
@@ -949,25 +1426,95 @@ func (self *TreeRoot) Layout() map[string][]*APIInfo {
 				layout[name][i].Comment = docitem.Comment
 			}
 		}
-	}
+	})
 	return layout
 }
 
+// Call routes <TreeName>.<branch path>.<FuncName> to the branch,
+// ex. Tree.sys.network.SetInterface
+// A panic in the call (middlewares included) is recovered to Reject(RetcodePanic).
 func (self *TreeRoot) Call(nodePath string, ctx *TreeCallCtx) {
+	defer ctx.recoverPanic()
 	paths := strings.Split(nodePath, ".")
-	if paths[0] != self.Name {
+	if paths[0] != self.Name || len(paths) < 3 {
 		ctx.Reject(1, errors.New(nodePath+" Not Found"))
-	} else if n, ok := self.Branches[paths[1]]; ok {
-		var username string
-		user := ctx.WsCtx.GetUser()
-		if user != nil {
-			username = user.Username()
-		}
-		ctx.CmdPath = nodePath + "\t" + username
-		n.Call(paths[2], ctx)
-	} else {
+		return
+	}
+	chain := self.branchChain(strings.Join(paths[1:len(paths)-1], "."))
+	if chain == nil {
 		ctx.Reject(1, errors.New(nodePath+" Not Found"))
+		return
+	}
+	var username string
+	user := ctx.WsCtx.GetUser()
+	if user != nil {
+		username = user.Username()
+	}
+	traceID := traceIDOf(ctx.WsCtx)
+	ctx.mutex.Lock()
+	ctx.CmdPath = nodePath + "\t" + username
+	ctx.NodePath = nodePath
+	ctx.mutex.Unlock()
+	if !chainAccessible(chain, user, traceID) {
+		ctx.Reject(RetcodeForbidden, NewTreeCallError(RetcodeForbidden, "forbidden", map[string]string{"path": nodePath}))
+		return
+	}
+	if self.CallTimeout > 0 {
+		ctx.SetTimeout(self.CallTimeout)
+	}
+	// middlewares of root, then middlewares of branches from top level down
+	middlewares := append([]Middleware{}, self.middlewares...)
+	for _, branch := range chain {
+		if b, ok := branch.(BranchWithMiddleware); ok {
+			middlewares = append(middlewares, b.Middlewares()...)
+		}
+	}
+	branch, apiName := chain[len(chain)-1], paths[len(paths)-1]
+	ctx.invoke = func() {
+		runMiddlewares(middlewares, ctx, func() {
+			branch.Call(apiName, ctx)
+		})
+	}
+	atomic.StoreInt32(&ctx.attempts, 1)
+	// a call might be queued by the limiter, then it is run in another goroutine later
+	self.Limiter.Run(ctx, strings.Join(paths[1:len(paths)-1], "."), apiName, ctx.invoke)
+}
+
+// SetStorage sets persistent storage of the tree, ex. an authleveldb.LevelDbDict.
+// Cron entries and checkpoints of resumable calls are loaded from and saved to it.
+func (self *TreeRoot) SetStorage(storage Dict) {
+	self.Storage = storage
+	self.Cron.SetStorage(storage)
+	if self.IsReady {
+		go self.ResumeJobs()
+	}
+}
+
+// CallAs calls nodePath as user from server side (ex. by cron), the call is run in background.
+// @observer is called with every result of this call, see TreeCallCtx.Observe()
+func (self *TreeRoot) CallAs(user User, nodePath string, args []string, kw map[string]string, observer TreeCallObserver) *TreeCallCtx {
+	ctx := self.newInternalCallCtx(user, args, kw, observer)
+	go self.Call(nodePath, ctx)
+	return ctx
+}
+
+func (self *TreeRoot) newInternalCallCtx(user User, args []string, kw map[string]string, observer TreeCallObserver) *TreeCallCtx {
+	cmdID := NextInternalCmdID()
+	listener := NewInternalCallPromiseListener(user, "internal"+strconv.FormatInt(int64(cmdID), 10), nil)
+	ctx := NewTreeCallCtx(self, cmdID, listener, args, &kw, nil)
+	ctx.SetBackground(true)
+	if observer != nil {
+		ctx.Observe(observer)
 	}
+	return ctx
+}
+
+var internalCmdID int32
+
+// NextInternalCmdID returns CmdID for calls from server side,
+// they are negative to not conflict with CmdIDs from browsers.
+func NextInternalCmdID() int32 {
+	return atomic.AddInt32(&internalCmdID, -1)
 }
 
 type Branch interface {
@@ -979,12 +1526,29 @@ type Branch interface {
 }
 type Exportable = func(*TreeCallCtx)
 
+// BranchContainer is implemented by a branch which has sub-branches (BaseBranch does)
+type BranchContainer interface {
+	SubBranches() map[string]Branch
+}
+type branchAdder interface {
+	AddBranchWithName(Branch, string)
+}
+
+// BranchWithACL is implemented by a branch which restricts its callers (BaseBranch does)
+type BranchWithACL interface {
+	ACL() int
+}
+
 // BaseBranch is an implementation of interface Branch
 type BaseBranch struct {
 	name            string
 	Ready           bool
 	Exportables     map[string]Exportable
 	ExportableNames []string
+	// sub-branches, name to branch
+	Branches    map[string]Branch
+	acl         int
+	middlewares []Middleware
 }
 
 // InitBaseBranch is an example implementation of a generic node
@@ -1000,6 +1564,41 @@ func (bb *BaseBranch) Name() string {
 func (bb *BaseBranch) SetName(name string) {
 	bb.name = name
 }
+// AddBranchWithName adds a sub-branch, it is called by <TreeName>.<this branch>.<name>.<FuncName>
+// Sub-branch should be added before the tree is ready, or use TreeRoot.AddBranchWithName("<this branch>.<name>")
+func (bb *BaseBranch) AddBranchWithName(branch Branch, name string) {
+	if branch.Name() != name {
+		branch.SetName(name)
+	}
+	if bb.Branches == nil {
+		bb.Branches = make(map[string]Branch)
+	}
+	bb.Branches[name] = branch
+}
+func (bb *BaseBranch) AddBranch(branch Branch) {
+	bb.AddBranchWithName(branch, defaultBranchName(branch))
+}
+func (bb *BaseBranch) SubBranches() map[string]Branch {
+	return bb.Branches
+}
+
+// SetACL restricts callers of this branch and its sub-branches, as the ACL mode of a route.
+// @acl: 0 (default) or PublicMode means no restriction, TraceMode requires the caller to be
+// logged in or a traced guest, ProtectMode requires the caller to be logged in.
+// Other values are rejected and the ACL is not changed. For roles (ex. admin only),
+// use middlewares, ex. Use(RequireAdmin).
+func (bb *BaseBranch) SetACL(acl int) error {
+	switch acl {
+	case 0, PublicMode, TraceMode, ProtectMode:
+		bb.acl = acl
+		return nil
+	}
+	log.Printf("branch %s: unsupported ACL mode %d is ignored\n", bb.name, acl)
+	return fmt.Errorf("unsupported ACL mode %d", acl)
+}
+func (bb *BaseBranch) ACL() int {
+	return bb.acl
+}
 func (bb *BaseBranch) Export(callables ...Exportable) {
 	//miso
 	for _, callable := range callables {
@@ -1029,3 +1628,14 @@ func (bb *BaseBranch) Call(apiName string, ctx *TreeCallCtx) {
 		ctx.Reject(1, errors.New(apiName+" not found"))
 	}
 }
+
+// GroupBranch exports nothing, it holds sub-branches only.
+// It is created when AddBranchWithName("sys.network") is called but "sys" does not exist.
+type GroupBranch struct {
+	BaseBranch
+}
+
+func (gb *GroupBranch) BeReady(treeroot *TreeRoot) {
+	gb.InitBaseBranch()
+	treeroot.SureReady(gb)
+}
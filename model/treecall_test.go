@@ -0,0 +1,95 @@
+package model
+
+import (
+	"testing"
+)
+
+// aclListener is a caller of given user and uuid of traced guest
+type aclListener struct {
+	*SimplePromiseStateListener
+	uuid string
+}
+
+// testUser is a logged in user of username only
+type testUser struct {
+	User
+	name string
+}
+
+func (u *testUser) Username() string {
+	return u.name
+}
+
+func (l *aclListener) traceID() string {
+	return l.uuid
+}
+
+func newACLRoot(t *testing.T, parentACL, childACL int) *TreeRoot {
+	root := newTestRoot()
+	parent := &GroupBranch{}
+	parent.InitBaseBranch()
+	if err := parent.SetACL(parentACL); err != nil {
+		t.Fatal(err)
+	}
+	child := &testBranch{}
+	child.InitBaseBranch()
+	child.Export(child.Echo)
+	if err := child.SetACL(childACL); err != nil {
+		t.Fatal(err)
+	}
+	root.AddBranchWithName(parent, "sys")
+	root.AddBranchWithName(child, "sys.network")
+	return root
+}
+
+func TestAccessibleByACLModes(t *testing.T) {
+	user := &testUser{name: "alice"}
+	cases := []struct {
+		parent, child int
+		user          User
+		uuid          string
+		expect        bool
+	}{
+		{0, 0, nil, "", true},
+		{PublicMode, 0, nil, "", true},
+		{TraceMode, 0, nil, "", false},
+		{TraceMode, 0, nil, "guest", true},
+		{0, TraceMode, user, "", true},
+		{ProtectMode, 0, nil, "guest", false},
+		{0, ProtectMode, nil, "guest", false},
+		{0, ProtectMode, user, "", true},
+		{TraceMode, ProtectMode, nil, "guest", false},
+	}
+	for i, c := range cases {
+		root := newACLRoot(t, c.parent, c.child)
+		caller := &aclListener{&SimplePromiseStateListener{user: c.user, id: "acl"}, c.uuid}
+		if got := root.Accessible("sys.network", caller); got != c.expect {
+			t.Errorf("case %d: expect %v, got %v", i, c.expect, got)
+		}
+	}
+}
+
+func TestCallRejectedByACL(t *testing.T) {
+	root := newACLRoot(t, ProtectMode, 0)
+	kw := map[string]string{}
+	var retcode int32 = -1
+	ctx := NewTreeCallCtx(root, 1, NewInternalCallPromiseListener(nil, "acl", nil), nil, &kw, nil)
+	ctx.Observe(func(ret *TreeCallReturn) {
+		retcode = ret.Retcode
+	})
+	root.Call("Tree.sys.network.Echo", ctx)
+	if retcode != RetcodeForbidden {
+		t.Fatalf("expect forbidden, got %d", retcode)
+	}
+}
+
+func TestSetACLRejectsUnknownMode(t *testing.T) {
+	branch := &BaseBranch{}
+	branch.SetACL(ProtectMode)
+	if err := branch.SetACL(42); err == nil {
+		t.Fatal("expect an unknown mode rejected")
+	}
+	if branch.ACL() != ProtectMode {
+		t.Fatalf("expect the ACL unchanged, got %d", branch.ACL())
+	}
+}
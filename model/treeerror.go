@@ -0,0 +1,63 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// Retcodes of Result which are used by the tree system itself.
+// Exportables are free to use other codes (> 0) for their own errors.
+const (
+	RetcodeBadRequest = 400
+	RetcodeForbidden  = 403
+	RetcodeNotFound   = 404
+	// the call was killed by user or by losing connection (foreground call)
+	RetcodeKilled = 500
+	// too many calls are queued, see CallLimiter
+	RetcodeBusy = 503
+	// the deadline of the call was exceeded, see TreeCallCtx.SetDeadline()
+	RetcodeTimeout = 504
+	// the exportable panicked
+	RetcodePanic = 520
+	// the job returned an error after all retries, see Scheduler
+	RetcodeJobFailed = 530
+)
+
+// TreeCallError is a structured error of a call,
+// it is serialized as JSON into Result.stderr like this:
+//	{"code":520,"message":"internal error","details":{"path":"Tree.sys.Reboot"}}
+type TreeCallError struct {
+	Code    int32       `json:"code"`
+	Message string      `json:"message"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+func (e *TreeCallError) Error() string {
+	return e.Message
+}
+
+func NewTreeCallError(code int32, message string, details interface{}) *TreeCallError {
+	return &TreeCallError{Code: code, Message: message, Details: details}
+}
+
+// AsTreeCallError returns err as a *TreeCallError, a plain error is wrapped with given retcode.
+func AsTreeCallError(retcode int32, err error) *TreeCallError {
+	var tcErr *TreeCallError
+	if errors.As(err, &tcErr) {
+		return tcErr
+	}
+	if err == nil {
+		return &TreeCallError{Code: retcode}
+	}
+	return &TreeCallError{Code: retcode, Message: err.Error()}
+}
+
+// MarshalStderr serializes err to the content of Result.stderr
+func MarshalStderr(retcode int32, err error) string {
+	data, jsonErr := json.Marshal(AsTreeCallError(retcode, err))
+	if jsonErr != nil {
+		// details is not serializable
+		data, _ = json.Marshal(&TreeCallError{Code: retcode, Message: err.Error()})
+	}
+	return string(data)
+}
@@ -0,0 +1,213 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+)
+
+// DefaultUploadWindow is how many bytes browser could send ahead of the reader of an Upload
+var DefaultUploadWindow int64 = 256 * 1024
+
+// ErrUploadAborted is returned by Upload.Read() when browser cancels the upload
+var ErrUploadAborted = errors.New("upload aborted")
+
+// ErrUploadUnsupported is returned by Upload.Read() when the client of a call could not send Chunks,
+// ex. gRPC streams results only, and internal calls have no client
+var ErrUploadUnsupported = errors.New("upload is not supported by the transport of this call")
+
+// ChunkCarrier is implemented by PromiseStateListener whose client could send Chunks along with calls
+type ChunkCarrier interface {
+	CarriesChunks() bool
+}
+
+// Upload is a binary stream sent by browser along with a call, in Chunk messages.
+// It is read by the exportable as an io.Reader, see TreeCallCtx.Upload().
+//
+// Browser does not send until it is granted by a Chunk of limit, then it sends data in order
+// until limit. Server grants more when the reader has consumed half of the window,
+// so a slow reader holds at most DefaultUploadWindow bytes in memory.
+type Upload struct {
+	ctx *TreeCallCtx
+	key string
+	// bytes could be sent ahead of the reader
+	window   int64
+	chunks   [][]byte
+	received int64
+	consumed int64
+	limit    int64
+	eof      bool
+	err      error
+	mutex    sync.Mutex
+	cond     *sync.Cond
+}
+
+// uploads are uploads of running calls, keyed by their listeners and ids
+type uploads struct {
+	items map[string]*Upload
+	mutex sync.Mutex
+}
+
+func uploadKey(listener PromiseStateListener, cmdID int32) string {
+	return listener.GenID() + "\t" + strconv.FormatInt(int64(cmdID), 10)
+}
+
+// Upload starts receiving the binary stream sent by browser along with this call, ex.
+//
+//	func (self *MyBranch) Save(ctx *TreeCallCtx) {
+//		f, _ := os.Create(ctx.Args[0])
+//		defer f.Close()
+//		n, err := io.Copy(f, ctx.Upload())
+//		if err != nil {
+//			ctx.Reject(model.RetcodeBadRequest, err)
+//			return
+//		}
+//		ctx.Resolve(n)
+//	}
+//
+// Read() returns error if browser aborts the upload, or the call is killed or timed out,
+// or ErrUploadUnsupported if the call is not issued by a ChunkCarrier.
+// Calling Upload() again returns the same Upload.
+func (tcCtx *TreeCallCtx) Upload() *Upload {
+	tcCtx.mutex.Lock()
+	if tcCtx.upload != nil {
+		tcCtx.mutex.Unlock()
+		return tcCtx.upload
+	}
+	u := &Upload{
+		ctx:    tcCtx,
+		key:    uploadKey(tcCtx.WsCtx, tcCtx.CmdID),
+		window: DefaultUploadWindow,
+	}
+	u.cond = sync.NewCond(&u.mutex)
+	u.limit = u.window
+	tcCtx.upload = u
+	tcCtx.mutex.Unlock()
+
+	// fails fast instead of waiting for Chunks which never come
+	if carrier, ok := tcCtx.WsCtx.(ChunkCarrier); !ok || !carrier.CarriesChunks() {
+		Metrics.Incr("upload.unsupported")
+		u.fail(ErrUploadUnsupported)
+		return u
+	}
+
+	if tcCtx.Root != nil {
+		registry := tcCtx.Root.uploads
+		registry.mutex.Lock()
+		registry.items[u.key] = u
+		registry.mutex.Unlock()
+		tcCtx.Observe(func(ret *TreeCallReturn) {
+			if ret.Retcode >= 0 {
+				registry.mutex.Lock()
+				delete(registry.items, u.key)
+				registry.mutex.Unlock()
+			}
+		})
+	}
+	// cancellation is tied to Kill, deadline and the end of this call
+	ctx := tcCtx.Context()
+	go func() {
+		<-ctx.Done()
+		u.fail(ctx.Err())
+	}()
+	u.grant(u.limit)
+	return u
+}
+
+// ReceiveChunk passes a Chunk from listener to the Upload of its call, it is dropped if not found
+func (self *TreeRoot) ReceiveChunk(listener PromiseStateListener, chunk *Chunk) {
+	self.uploads.mutex.Lock()
+	u := self.uploads.items[uploadKey(listener, chunk.Id)]
+	self.uploads.mutex.Unlock()
+	if u == nil {
+		Metrics.Incr("upload.dropped")
+		return
+	}
+	u.receive(chunk)
+}
+
+// grant tells browser it could send data until limit
+func (u *Upload) grant(limit int64) {
+	if _, err := u.ctx.WsCtx.SendProtobufMessage(&Chunk{Id: u.ctx.CmdID, Limit: limit}); err != nil {
+		u.fail(err)
+	}
+}
+
+func (u *Upload) receive(chunk *Chunk) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	if u.err != nil || u.eof {
+		return
+	}
+	size := int64(len(chunk.Data))
+	switch {
+	case chunk.Abort:
+		u.err = ErrUploadAborted
+	case chunk.Offset != u.received:
+		u.err = fmt.Errorf("chunk at %d is out of order, expect %d", chunk.Offset, u.received)
+	case u.received+size > u.limit:
+		u.err = fmt.Errorf("chunk at %d of %d bytes exceeds limit %d", chunk.Offset, size, u.limit)
+	default:
+		if size > 0 {
+			u.chunks = append(u.chunks, chunk.Data)
+			u.received += size
+			Metrics.Add("upload.bytes", size)
+		}
+		u.eof = chunk.Eof
+	}
+	u.cond.Broadcast()
+}
+
+// fail stops the upload with err, it is a no-op after the upload is completed
+func (u *Upload) fail(err error) {
+	u.mutex.Lock()
+	if u.err == nil && !u.eof {
+		u.err = err
+		u.chunks = nil
+	}
+	u.cond.Broadcast()
+	u.mutex.Unlock()
+}
+
+// Read implements io.Reader, it blocks until data arrives
+func (u *Upload) Read(p []byte) (int, error) {
+	u.mutex.Lock()
+	for len(u.chunks) == 0 && !u.eof && u.err == nil {
+		u.cond.Wait()
+	}
+	if u.err != nil {
+		u.mutex.Unlock()
+		return 0, u.err
+	}
+	if len(u.chunks) == 0 {
+		u.mutex.Unlock()
+		return 0, io.EOF
+	}
+	n := copy(p, u.chunks[0])
+	if n == len(u.chunks[0]) {
+		u.chunks[0] = nil
+		u.chunks = u.chunks[1:]
+	} else {
+		u.chunks[0] = u.chunks[0][n:]
+	}
+	u.consumed += int64(n)
+	limit := int64(-1)
+	if !u.eof && u.limit-u.consumed < u.window/2 {
+		u.limit = u.consumed + u.window
+		limit = u.limit
+	}
+	u.mutex.Unlock()
+	if limit > 0 {
+		u.grant(limit)
+	}
+	return n, nil
+}
+
+// Received returns number of bytes received
+func (u *Upload) Received() int64 {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	return u.received
+}
@@ -0,0 +1,134 @@
+package model
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// chunkListener is a caller which could send Chunks, it records limits granted to it
+type chunkListener struct {
+	*SimplePromiseStateListener
+	limits []int64
+	mutex  sync.Mutex
+}
+
+func (l *chunkListener) CarriesChunks() bool {
+	return true
+}
+
+func (l *chunkListener) SendProtobufMessage(msg proto.Message) (int, error) {
+	if chunk, ok := msg.(*Chunk); ok {
+		l.mutex.Lock()
+		l.limits = append(l.limits, chunk.Limit)
+		l.mutex.Unlock()
+	}
+	return 0, nil
+}
+
+func (l *chunkListener) granted() []int64 {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return append([]int64{}, l.limits...)
+}
+
+// newUpload starts an Upload of a call issued by a chunkListener, with a window of window bytes
+func newUpload(t *testing.T, window int64) (*TreeRoot, *chunkListener, *TreeCallCtx, *Upload) {
+	saved := DefaultUploadWindow
+	DefaultUploadWindow = window
+	defer func() { DefaultUploadWindow = saved }()
+	root := newTestRoot()
+	listener := &chunkListener{SimplePromiseStateListener: &SimplePromiseStateListener{id: "upload"}}
+	kw := map[string]string{}
+	ctx := NewTreeCallCtx(root, 1, listener, nil, &kw, nil)
+	return root, listener, ctx, ctx.Upload()
+}
+
+func readString(t *testing.T, u *Upload, n int) string {
+	buf := make([]byte, n)
+	n, err := u.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(buf[:n])
+}
+
+func TestUploadGrantWindow(t *testing.T) {
+	root, listener, ctx, u := newUpload(t, 8)
+	if u != ctx.Upload() {
+		t.Fatal("expect the same upload")
+	}
+	if limits := listener.granted(); len(limits) != 1 || limits[0] != 8 {
+		t.Fatalf("expect granted a window, got %v", limits)
+	}
+	root.ReceiveChunk(listener, &Chunk{Id: 1, Data: []byte("abcd")})
+	if s := readString(t, u, 3); s != "abc" {
+		t.Fatalf("expect abc, got %s", s)
+	}
+	if s := readString(t, u, 3); s != "d" {
+		t.Fatalf("expect d, got %s", s)
+	}
+	// more is granted when half of the window is consumed
+	root.ReceiveChunk(listener, &Chunk{Id: 1, Offset: 4, Data: []byte("efgh")})
+	if limits := listener.granted(); len(limits) != 1 {
+		t.Fatalf("expect not granted yet, got %v", limits)
+	}
+	if s := readString(t, u, 2); s != "ef" {
+		t.Fatalf("expect ef, got %s", s)
+	}
+	if limits := listener.granted(); len(limits) != 2 || limits[1] != 14 {
+		t.Fatalf("expect granted until 14, got %v", limits)
+	}
+	root.ReceiveChunk(listener, &Chunk{Id: 1, Offset: 8, Data: []byte("ij"), Eof: true})
+	rest, err := ioutil.ReadAll(u)
+	if err != nil || string(rest) != "ghij" {
+		t.Fatalf("expect ghij, got %q %v", rest, err)
+	}
+	if n := u.Received(); n != 10 {
+		t.Fatalf("expect 10 bytes received, got %d", n)
+	}
+
+	ctx.Resolve(nil)
+	root.uploads.mutex.Lock()
+	n := len(root.uploads.items)
+	root.uploads.mutex.Unlock()
+	if n != 0 {
+		t.Fatalf("expect the upload unregistered, got %d", n)
+	}
+}
+
+func TestUploadErrors(t *testing.T) {
+	cases := []struct {
+		chunk  *Chunk
+		expect string
+	}{
+		{&Chunk{Id: 1, Offset: 2, Data: []byte("ab")}, "out of order"},
+		{&Chunk{Id: 1, Data: []byte("abcdefghi")}, "exceeds limit"},
+		{&Chunk{Id: 1, Abort: true}, ErrUploadAborted.Error()},
+	}
+	for i, c := range cases {
+		root, listener, _, u := newUpload(t, 8)
+		root.ReceiveChunk(listener, c.chunk)
+		if _, err := u.Read(make([]byte, 8)); err == nil || !strings.Contains(err.Error(), c.expect) {
+			t.Errorf("case %d: expect %q, got %v", i, c.expect, err)
+		}
+	}
+
+	// a killed call stops its upload
+	_, _, ctx, u := newUpload(t, 8)
+	go ctx.Kill()
+	if _, err := u.Read(make([]byte, 8)); err != context.Canceled {
+		t.Fatalf("expect canceled, got %v", err)
+	}
+
+	root := newTestRoot()
+	kw := map[string]string{}
+	ctx = NewTreeCallCtx(root, 1, NewInternalCallPromiseListener(nil, "upload", nil), nil, &kw, nil)
+	if _, err := ctx.Upload().Read(make([]byte, 8)); err != ErrUploadUnsupported {
+		t.Fatalf("expect unsupported, got %v", err)
+	}
+}
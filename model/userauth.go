@@ -291,6 +291,14 @@ func (bap *BaseAuthProvider) Cache(user User) {
 	bap.TokenCache[user.Username()] = user
 }
 
+// GetUser finds user by its AccountProvider, nil if not found
+func (bap *BaseAuthProvider) GetUser(username string) User {
+	if bap.AccountProvider == nil {
+		return nil
+	}
+	return bap.AccountProvider.GetUser(username)
+}
+
 func (bap *BaseAuthProvider) SetAccountProvider(obj interface{}) {
 	if ap, ok := obj.(PersitentAccountProvider); ok {
 		bap.AccountProvider = ap
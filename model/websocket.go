@@ -1,6 +1,7 @@
 package model
 
 import (
+	"context"
 	"crypto/md5"
 	"encoding/json"
 	"errors"
@@ -8,9 +9,11 @@ import (
 	"io"
 	"log"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/dgrr/fastws"
+	"github.com/google/uuid"
 	proto "github.com/golang/protobuf/proto"
 	"github.com/golang/protobuf/ptypes"
 	"github.com/golang/protobuf/ptypes/any"
@@ -31,11 +34,30 @@ type WebsocketCtx struct {
 	Conn                    *fastws.Conn
 	mode                    fastws.Mode
 	Closed                  bool
-	closeListener           map[string]CloseEventHandler
-	messageBinaryListener   map[string]BinaryMessageHandler
-	messageListener         map[string]MessageHandler
-	messageProtoBufListener map[string]ProtoBufMessageHandler
-	mutex                   sync.RWMutex
+	// listeners of Close, Message, BinaryMessage and Protobuf, see On()
+	listeners map[string]listenerSet
+	mutex     sync.RWMutex
+	// unique id of this connection, see Sessions
+	ID string
+	// url path of the websocket route
+	Route      string
+	RemoteAddr string
+	// time of connecting
+	Ctime    time.Time
+	bytesIn  int64
+	bytesOut int64
+	// number of unfinished calls issued by this websocket
+	calls int32
+	// options of the websocket route, see WebsocketOptions
+	options *WebsocketOptions
+	// queue of messages to send
+	outbox *outbox
+	// subprotocol requested by browser, see WebsocketProtocols
+	Subprotocol string
+	// true if messages are sent in JSON, see SetJSON()
+	json bool
+	// the resumable session attached, see SessionResumer
+	session *ResumableSession
 }
 
 func NewWebsocketCtx(user User, UUID string, args *fasthttp.Args, conn *fastws.Conn) *WebsocketCtx {
@@ -45,11 +67,14 @@ func NewWebsocketCtx(user User, UUID string, args *fasthttp.Args, conn *fastws.C
 		Conn:                    conn,
 		mode:                    fastws.ModeText,
 		Closed:                  false,
-		closeListener:           make(map[string]CloseEventHandler, 0),
-		messageListener:         make(map[string]MessageHandler, 0),
-		messageBinaryListener:   make(map[string]BinaryMessageHandler, 0),
-		messageProtoBufListener: make(map[string]ProtoBufMessageHandler, 0),
+		listeners:               make(map[string]listenerSet),
+		ID:                      uuid.New().String(),
+		Ctime:                   time.Now(),
 	}
+	if conn != nil {
+		wsCtx.RemoteAddr = conn.RemoteAddr().String()
+	}
+	wsCtx.outbox = newOutbox(&wsCtx, conn, nil)
 	// user and UUID in arguments are mutual exclusive
 	if user != nil {
 		wsCtx.User = user
@@ -67,17 +92,15 @@ func (self *WebsocketCtx) Close() {
 	}
     //log.Println("Websocket closed -------")
 	self.Closed = true
+	closeListener := self.listeners["Close"]
 	self.mutex.Unlock()
 
-	self.mutex.RLock()
     // 2020-10-19T13:10:26+00:00
     // 這裡有問題，有太多的close handler，存在記憶體中
-	for token, fn := range self.closeListener {
-		//log.Println("call close handler", token)
-        _ = token
-		fn()
+	// no lock is held, close handlers could call Off() or close other websockets
+	for _, fn := range closeListener {
+		fn.(CloseEventHandler)()
 	}
-	self.mutex.RUnlock()
 
 
 	// 在Server side先關閉時，要送這行讓自己聽訊息那裡能中斷跳出來（只有Close不會中斷跳出來）
@@ -85,13 +108,13 @@ func (self *WebsocketCtx) Close() {
     //      dgrr 把這個函式拿掉了，先這樣看看
 	//self.Conn.SetDeadline(time.Now())
 
+	self.outbox.stop()
 	self.Conn.Close()
-	self.closeListener = nil
-	self.messageListener = nil
-	self.messageBinaryListener = nil
-	self.messageProtoBufListener = nil
+	self.mutex.Lock()
+	self.listeners = nil
 	self.Conn = nil
 	self.User = nil
+	self.mutex.Unlock()
 }
 func (self *WebsocketCtx) SetBinary(yes bool) {
 	if yes {
@@ -103,91 +126,103 @@ func (self *WebsocketCtx) SetBinary(yes bool) {
 func (self *WebsocketCtx) GetUser() User {
 	return self.User
 }
+func (self *WebsocketCtx) traceID() string {
+	return self.UUID
+}
 
 //GenID ，用途 此websocket加入chat room時，作為識別
 func (self *WebsocketCtx) GenID() string {
 	m := md5.New()
-	io.WriteString(m, self.Conn.RemoteAddr().String())
+	io.WriteString(m, self.RemoteAddr)
 	return fmt.Sprintf("%x", m.Sum(nil))
 }
 
+// On adds a listener of evtName (Close, Message, BinaryMessage or Protobuf) with token for Off(),
+// returns false if token has been used. It is safe to be called from any goroutine,
+// including from a listener.
 func (self *WebsocketCtx) On(evtName string, token string, fn interface{}, args ...interface{}) bool {
 	//evtname := strings.ToLower(evtName)
-	if self.IsClosed() {
-		log.Printf("listen on closed websocket event %s with token=%s \n", evtName, token)
-		return false
-	}
-	//log.Printf("listen on %s with token=%s\n", evtName, token)
-	self.mutex.Lock()
+	var listener interface{}
 	switch evtName {
 	case "Close":
-		if _, ok := self.closeListener[token]; ok {
-			self.mutex.Unlock()
-			//panic(fmt.Sprintf("listen on close failed, due to token %s has registered", token))
-			fmt.Sprintf("listen on close failed, due to token %s has registered", token)
-			return false
-		}
-		self.closeListener[token] = fn.(CloseEventHandler)
+		listener = fn.(CloseEventHandler)
 	case "Message":
-		if _, ok := self.messageListener[token]; ok {
-			self.mutex.Unlock()
-			panic(fmt.Sprintf("listen on message failed, due to token %s has registered", token))
-		}
-		self.messageListener[token] = fn.(MessageHandler)
+		listener = fn.(MessageHandler)
 	case "BinaryMessage":
-		if _, ok := self.messageBinaryListener[token]; ok {
-			self.mutex.Unlock()
-			panic(fmt.Sprintf("listen on binary message failed, due to token %s has registered", token))
-		}
-		self.messageBinaryListener[token] = fn.(BinaryMessageHandler)
+		listener = fn.(BinaryMessageHandler)
 	case "Protobuf":
-		if _, ok := self.messageProtoBufListener[token]; ok {
-			self.mutex.Unlock()
-			panic(fmt.Sprintf("listen on protobuf message failed, due to token %s has registered", token))
-		}
-		self.messageProtoBufListener[token] = fn.(ProtoBufMessageHandler)
+		listener = fn.(ProtoBufMessageHandler)
 	default:
 		panic("Websocket has not event:" + evtName + ", accept Close, Message, BinaryMessage, ProtoBuf")
 	}
-
-	self.mutex.Unlock()
+	//log.Printf("listen on %s with token=%s\n", evtName, token)
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if self.Closed {
+		log.Printf("listen on closed websocket event %s with token=%s \n", evtName, token)
+		return false
+	}
+	set := self.listeners[evtName]
+	if _, ok := set[token]; ok {
+		if evtName != "Close" {
+			panic(fmt.Sprintf("listen on %s failed, due to token %s has registered", evtName, token))
+		}
+		// the same close handler is registered twice
+		return false
+	}
+	self.listeners[evtName] = set.with(token, listener)
 	return true
 }
+
+// Off removes the listener of token, returns false if it is not found
 func (self *WebsocketCtx) Off(evtName string, token string) bool {
-	if self.IsClosed() {
-		log.Printf("listen off closed websocket event %s with token=%s is ignored \n", evtName, token)
-		return false
+	switch evtName {
+	case "Close", "Message", "BinaryMessage", "Protobuf":
+	default:
+		panic("Websocket has not event:" + evtName + ", accept Close, Message, BinaryMessage, ProtoBuf")
 	}
 	//log.Printf("listen on %s with token=%s is off\n", evtName, token)
 	self.mutex.Lock()
-	//evtname := strings.ToLower(evtName)
-	found := false
-	switch evtName {
-	case "Close":
-		if _, ok := self.closeListener[token]; ok {
-			delete(self.closeListener, token)
-			found = true
-		}
-	case "Message":
-		if _, ok := self.messageListener[token]; ok {
-			delete(self.messageListener, token)
-			found = true
-		}
-	case "BinaryMessage":
-		if _, ok := self.messageBinaryListener[token]; ok {
-			delete(self.messageBinaryListener, token)
-			found = true
-		}
-	case "Protobuf":
-		if _, ok := self.messageProtoBufListener[token]; ok {
-			delete(self.messageProtoBufListener, token)
-			found = true
+	defer self.mutex.Unlock()
+	if self.Closed {
+		// listeners are dropped when it is closed
+		return false
+	}
+	set := self.listeners[evtName]
+	if _, ok := set[token]; !ok {
+		return false
+	}
+	self.listeners[evtName] = set.without(token)
+	return true
+}
+
+// listenersOf returns listeners of evtName, which could be iterated without lock
+func (self *WebsocketCtx) listenersOf(evtName string) listenerSet {
+	self.mutex.RLock()
+	defer self.mutex.RUnlock()
+	return self.listeners[evtName]
+}
+
+// listenerSet is listeners of an event by tokens. It is copy-on-write, a published set is never modified,
+// so listeners are called without lock and can be added or removed by themselves.
+type listenerSet map[string]interface{}
+
+func (set listenerSet) with(token string, fn interface{}) listenerSet {
+	ret := make(listenerSet, len(set)+1)
+	for k, v := range set {
+		ret[k] = v
+	}
+	ret[token] = fn
+	return ret
+}
+func (set listenerSet) without(token string) listenerSet {
+	ret := make(listenerSet, len(set))
+	for k, v := range set {
+		if k != token {
+			ret[k] = v
 		}
-	default:
-		panic("Websocket has not event:" + evtName + ", accept Close, Message, BinaryMessage, ProtoBuf")
 	}
-	self.mutex.Unlock()
-	return found
+	return ret
 }
 
 /*
@@ -215,31 +250,36 @@ func (self *WebsocketCtx) IsClosed() bool {
 	self.mutex.RUnlock()
 	return c
 }
+// WaitWritable implements FlowController, it blocks while the outbound queue is half full
+func (self *WebsocketCtx) WaitWritable(ctx context.Context) error {
+	return self.outbox.waitRoom(ctx)
+}
+
+// CarriesChunks implements ChunkCarrier, browser sends Chunks in both protobuf and JSON protocol
+func (self *WebsocketCtx) CarriesChunks() bool {
+	return true
+}
 func (self *WebsocketCtx) errorOnIO(err error) {
 	log.Printf("ws IO error: %s\n", err)
 	if !self.IsClosed() {self.Close()}
 }
+// Send queues a message to send, it is written by another goroutine (see outbox).
+// Returns the size of data if it is queued.
 func (self *WebsocketCtx) Send(data string) (int, error) {
-	if self.IsClosed() {
-		return 0, errors.New("disconnected")
-	}
-	size, err := self.Conn.Write([]byte(data))
-	if err != nil {
-		self.errorOnIO(err)
-		return 0, err
-	}
-	return size, nil
+	return self.send(&outbound{mode: self.mode, data: []byte(data)})
 }
 func (self *WebsocketCtx) SendBinary(data []byte) (int, error) {
+	return self.send(&outbound{mode: fastws.ModeBinary, data: data})
+}
+func (self *WebsocketCtx) send(message *outbound) (int, error) {
 	if self.IsClosed() {
 		return 0, errors.New("disconnected")
 	}
-	size, err := self.Conn.WriteMessage(fastws.ModeBinary, data)
-	if err != nil {
+	if err := self.outbox.push(message); err != nil {
 		self.errorOnIO(err)
 		return 0, err
 	}
-	return size, nil
+	return len(message.data), nil
 }
 
 func (self *WebsocketCtx) SendProtobuf(obj proto.Message) (int, error) {
@@ -251,7 +291,19 @@ func (self *WebsocketCtx) SendProtobuf(obj proto.Message) (int, error) {
 		return 0, err
 	}
 }
+
 func (self *WebsocketCtx) SendProtobufMessage(obj proto.Message) (int, error) {
+	// notifies (retcode < 0) could be dropped when the queue is full, see OverflowDropNotify,
+	// but chunks are not since a stream with a hole is useless
+	result, ok := obj.(*Result)
+	notify := ok && result.Retcode < 0 && result.Chunk == nil
+	if self.IsJSON() {
+		data, err := MarshalJSONMessage(obj)
+		if err != nil {
+			return 0, err
+		}
+		return self.send(&outbound{mode: fastws.ModeText, data: data, droppable: notify})
+	}
 	//send proto.Message object encaptured as any.Any
 	// 會被包在Any當中傳送，browser端需使用 objshsdk.js 解開此message
 	data, err := proto.Marshal(obj)
@@ -262,47 +314,106 @@ func (self *WebsocketCtx) SendProtobufMessage(obj proto.Message) (int, error) {
 		TypeUrl: proto.MessageName(obj),
 		Value:   data,
 	}
-	return self.SendProtobuf(&anymsg)
+	binaryBytes, err := proto.Marshal(&anymsg)
+	if err != nil {
+		return 0, err
+	}
+	return self.send(&outbound{mode: fastws.ModeBinary, data: binaryBytes, droppable: notify})
 }
 
 func (self *WebsocketCtx) SendTreeCallReturn(ret *TreeCallReturn) (int, error) {
+	return self.SendProtobufMessage(ResultOf(ret))
+}
+
+// ResultOf converts ret to the Result message which is sent to browser
+func ResultOf(ret *TreeCallReturn) *Result {
 	result := Result{
 		Id:      ret.CmdID,
 		Retcode: ret.Retcode,
 	}
-	if ret.Retcode <= 0 { //0 (success) -1 (in progress), -2 (in progress of background task)
+	if ret.Progress != nil {
+		// structured progress, stdout is empty unless it is given too
+		result.Progress = ret.Progress
+	}
+	if ret.Chunk != nil {
+		// binary stream, stdout is empty unless it is given too
+		result.Chunk = ret.Chunk
+	}
+	if ret.Retcode <= 0 && (ret.Stdout != nil || (ret.Progress == nil && ret.Chunk == nil)) { //0 (success) -1 (in progress), -2 (in progress of background task)
 		jsonstring, err := json.Marshal(ret.Stdout)
 		if err != nil {
 			fmt.Println("Convert ret.stdout error", err)
 		}
 		result.Stdout = jsonstring
-	} else {
-		result.Stderr = ret.Stderr.Error()
+	} else if ret.Retcode > 0 {
+		// structured error, see TreeCallError
+		result.Stderr = MarshalStderr(ret.Retcode, ret.Stderr)
+	}
+	return &result
+}
+// heartbeat pings the peer every interval until this websocket is closed.
+// Pings are written by the outbox, as other messages.
+// Pongs are consumed by fastws, but every frame received restarts the read timeout,
+// so a peer which stops responding is closed by Handle() when the read times out.
+func (self *WebsocketCtx) heartbeat(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if self.IsClosed() {
+			return
+		}
+		if err := self.outbox.ping(); err != nil {
+			self.errorOnIO(err)
+			return
+		}
 	}
-	return self.SendProtobufMessage(&result)
-
 }
 func (self *WebsocketCtx) Handle() {
-	self.Conn.ReadTimeout = time.Second * 3600
+	// Conn is set to nil by Close()
+	conn := self.Conn
+	options := self.options
+	if options == nil {
+		options = DefaultWebsocketOptions.withDefaults()
+	}
+	if options.PingInterval > 0 {
+		missed := options.MaxMissedPongs
+		if missed <= 0 {
+			missed = 3
+		}
+		conn.ReadTimeout = options.PingInterval * time.Duration(missed)
+		go self.heartbeat(options.PingInterval)
+	} else if options.ReadTimeout > 0 {
+		conn.ReadTimeout = options.ReadTimeout
+	} else {
+		conn.ReadTimeout = time.Second * 3600
+	}
+	if options.WriteTimeout > 0 {
+		conn.WriteTimeout = options.WriteTimeout
+	}
 	var msg []byte
 	var err error
 	defer self.Close()
 	for !self.IsClosed() {
-		_, msg, err = self.Conn.ReadMessage(msg[:0])
+		_, msg, err = conn.ReadMessage(msg[:0])
 		if err != nil {
 			if err != fastws.EOF {
 				//log.Printf("error reading message: %s", err)
+				if err.Error() == "i/o timeout" {
+					// the peer is gone without closing
+					Metrics.Incr("websocket.timeout")
+				}
 				self.errorOnIO(err)
 			}
 			break
 		}
-		for _, listener := range self.messageListener {
-			listener(string(msg))
+		atomic.AddInt64(&self.bytesIn, int64(len(msg)))
+		for _, listener := range self.listenersOf("Message") {
+			listener.(MessageHandler)(string(msg))
 		}
-		for _, blistener := range self.messageBinaryListener {
-			blistener(msg)
+		for _, blistener := range self.listenersOf("BinaryMessage") {
+			blistener.(BinaryMessageHandler)(msg)
 		}
-		if len(self.messageProtoBufListener) > 0 {
+		if pblisteners := self.listenersOf("Protobuf"); len(pblisteners) > 0 {
 			anyMsg := any.Any{}
 			var err error
 			var obj proto.Message
@@ -313,8 +424,8 @@ func (self *WebsocketCtx) Handle() {
 					}
 				}
 			}
-			for _, pblistener := range self.messageProtoBufListener {
-				pblistener(obj, err)
+			for _, pblistener := range pblisteners {
+				pblistener.(ProtoBufMessageHandler)(obj, err)
 			}
 		}
 	}
@@ -0,0 +1,205 @@
+package model
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dgrr/fastws"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+// servePair returns a WebsocketCtx served by Handle() and the client connected to it
+func servePair(t *testing.T) (*WebsocketCtx, *fastws.Conn, func()) {
+	ln := fasthttputil.NewInmemoryListener()
+	served := make(chan *WebsocketCtx, 1)
+	handled := make(chan struct{})
+	server := &fasthttp.Server{
+		Handler: fastws.Upgrade(func(conn *fastws.Conn) {
+			wsCtx := NewWebsocketCtx(nil, "", nil, conn)
+			served <- wsCtx
+			wsCtx.Handle()
+			close(handled)
+		}),
+	}
+	go server.Serve(ln)
+	c, err := ln.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := fastws.Client(c, "ws://localhost/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wsCtx *WebsocketCtx
+	select {
+	case wsCtx = <-served:
+	case <-time.After(5 * time.Second):
+		t.Fatal("websocket is not served")
+	}
+	return wsCtx, client, func() {
+		client.Close()
+		select {
+		case <-handled:
+		case <-time.After(5 * time.Second):
+			t.Error("Handle() does not return")
+		}
+		ln.Close()
+	}
+}
+
+// pipePair returns a WebsocketCtx over net.Pipe() which is not served by Handle(),
+// the peer answers the handshake, discards messages and hangs up when the close frame arrives.
+// Unlike servePair, it could be closed by server side without racing inside fasthttp.
+func pipePair(t *testing.T) *WebsocketCtx {
+	c, peer := net.Pipe()
+	go func() {
+		defer peer.Close()
+		br := bufio.NewReader(peer)
+		var req fasthttp.Request
+		if err := req.Read(br); err != nil {
+			return
+		}
+		peer.Write([]byte("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n"))
+		for {
+			fr := fastws.AcquireFrame()
+			fr.SetPayloadSize(0)
+			_, err := fr.ReadFrom(br)
+			closing := fr.IsClose()
+			fastws.ReleaseFrame(fr)
+			if err != nil || closing {
+				return
+			}
+		}
+	}()
+	conn, err := fastws.Client(c, "ws://localhost/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewWebsocketCtx(nil, "", nil, conn)
+}
+
+// waitFor polls cond until it is true or timed out
+func waitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for " + what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestListenerOnOffWhileDispatching(t *testing.T) {
+	wsCtx, client, done := servePair(t)
+	defer done()
+
+	var received int32
+	wsCtx.On("Message", "counter", MessageHandler(func(string) {
+		atomic.AddInt32(&received, 1)
+	}))
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for n := 0; ; n++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				token := strconv.Itoa(i) + "-" + strconv.Itoa(n)
+				if !wsCtx.On("Message", token, MessageHandler(func(string) {})) {
+					t.Error("On failed for " + token)
+					return
+				}
+				if !wsCtx.Off("Message", token) {
+					t.Error("Off failed for " + token)
+					return
+				}
+			}
+		}(i)
+	}
+
+	const messages = 200
+	for i := 0; i < messages; i++ {
+		if _, err := client.WriteString("hello"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, "messages", func() bool { return atomic.LoadInt32(&received) == messages })
+	close(stop)
+	wg.Wait()
+	if n := len(wsCtx.listenersOf("Message")); n != 1 {
+		t.Fatalf("expect 1 listener left, got %d", n)
+	}
+}
+
+func TestListenerOffInsideCallback(t *testing.T) {
+	wsCtx, client, done := servePair(t)
+	defer done()
+
+	var once, every int32
+	wsCtx.On("Message", "once", MessageHandler(func(string) {
+		atomic.AddInt32(&once, 1)
+		if !wsCtx.Off("Message", "once") {
+			t.Error("Off from inside the callback failed")
+		}
+	}))
+	wsCtx.On("Message", "every", MessageHandler(func(string) {
+		atomic.AddInt32(&every, 1)
+	}))
+
+	for i := 0; i < 3; i++ {
+		if _, err := client.WriteString("hello"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, "messages", func() bool { return atomic.LoadInt32(&every) == 3 })
+	if n := atomic.LoadInt32(&once); n != 1 {
+		t.Fatalf("expect the removed listener to be called once, got %d", n)
+	}
+}
+
+func TestCloseListenerOffDuringClose(t *testing.T) {
+	wsCtx := pipePair(t)
+
+	var fired int32
+	for i := 0; i < 10; i++ {
+		token := strconv.Itoa(i)
+		other := strconv.Itoa((i + 1) % 10)
+		wsCtx.On("Close", token, CloseEventHandler(func() {
+			atomic.AddInt32(&fired, 1)
+			// removing itself and another listener, like Promise.clean() does
+			wsCtx.Off("Close", token)
+			wsCtx.Off("Close", other)
+			wsCtx.On("Close", "late"+token, CloseEventHandler(func() {}))
+		}))
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		wsCtx.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close() deadlocks")
+	}
+	// every listener published before Close() is called exactly once
+	if n := atomic.LoadInt32(&fired); n != 10 {
+		t.Fatalf("expect 10 close listeners fired, got %d", n)
+	}
+	if !wsCtx.IsClosed() {
+		t.Fatal("expect closed")
+	}
+}
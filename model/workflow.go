@@ -0,0 +1,377 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// WorkflowStep is a tree call in a workflow
+type WorkflowStep struct {
+	// unique name in the workflow
+	Name string `json:"name"`
+	// path of the API, the tree name could be omitted, ex. "reports.Build"
+	Path string            `json:"path"`
+	Args []string          `json:"args,omitempty"`
+	Kw   map[string]string `json:"kw,omitempty"`
+	// names of steps which should be completed before this step
+	DependsOn []string `json:"dependsOn,omitempty"`
+}
+
+// Workflow is a DAG of tree calls. A step is started when all steps it depends on are completed.
+// The output of an upstream step can be used in args and kw of a step by placeholders:
+//
+//	{{fetch.output}}        the whole output of step "fetch", a string as is, others in JSON
+//	{{fetch.output.url}}    a field of the output of step "fetch"
+//
+// ex. in JSON:
+//
+//	{"steps":[
+//		{"name":"fetch", "path":"data.Fetch", "args":["2019-12"]},
+//		{"name":"build", "path":"reports.Build", "args":["{{fetch.output.file}}"], "dependsOn":["fetch"]}
+//	]}
+type Workflow struct {
+	Steps []*WorkflowStep `json:"steps"`
+}
+
+var placeholderRe = regexp.MustCompile(`\{\{\s*([\w$-]+)\.output((?:\.[\w$-]+)*)\s*\}\}`)
+
+// ParseWorkflow parses a workflow in JSON and validates it
+func ParseWorkflow(data []byte) (*Workflow, error) {
+	wf := &Workflow{}
+	if err := json.Unmarshal(data, wf); err != nil {
+		return nil, err
+	}
+	return wf, wf.Validate()
+}
+
+// Validate checks names, dependencies, placeholders and cycles of steps
+func (wf *Workflow) Validate() error {
+	if len(wf.Steps) == 0 {
+		return errors.New("workflow has no steps")
+	}
+	steps := make(map[string]*WorkflowStep, len(wf.Steps))
+	for _, step := range wf.Steps {
+		if step.Name == "" || step.Path == "" {
+			return errors.New("name and path of step are required")
+		}
+		if _, ok := steps[step.Name]; ok {
+			return errors.New("duplicated step " + step.Name)
+		}
+		steps[step.Name] = step
+	}
+	// ancestors of every step, it also finds cycles
+	ancestors := make(map[string]map[string]bool, len(steps))
+	var visit func(name string, visiting map[string]bool) error
+	visit = func(name string, visiting map[string]bool) error {
+		if _, ok := ancestors[name]; ok {
+			return nil
+		}
+		if visiting[name] {
+			return errors.New("cyclic dependency at step " + name)
+		}
+		visiting[name] = true
+		set := make(map[string]bool)
+		for _, dep := range steps[name].DependsOn {
+			if _, ok := steps[dep]; !ok {
+				return fmt.Errorf("step %s depends on unknown step %s", name, dep)
+			}
+			if err := visit(dep, visiting); err != nil {
+				return err
+			}
+			set[dep] = true
+			for ancestor := range ancestors[dep] {
+				set[ancestor] = true
+			}
+		}
+		delete(visiting, name)
+		ancestors[name] = set
+		return nil
+	}
+	for _, step := range wf.Steps {
+		if err := visit(step.Name, make(map[string]bool)); err != nil {
+			return err
+		}
+		values := append([]string{}, step.Args...)
+		for _, v := range step.Kw {
+			values = append(values, v)
+		}
+		for _, v := range values {
+			for _, match := range placeholderRe.FindAllStringSubmatch(v, -1) {
+				if !ancestors[step.Name][match[1]] {
+					return fmt.Errorf("step %s uses output of %s, which is not its upstream", step.Name, match[1])
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// step states
+const (
+	StepPending   = "pending"
+	StepRunning   = "running"
+	StepCompleted = "completed"
+	StepFailed    = "failed"
+	StepKilled    = "killed"
+)
+
+// workflowRun is a running workflow of a TreeCallCtx
+type workflowRun struct {
+	ctx     *TreeCallCtx
+	wf      *Workflow
+	states  map[string]string
+	outputs map[string]interface{}
+	// calls of running steps
+	calls map[string]*TreeCallCtx
+	// number of completed steps
+	completed int
+	failed    bool
+	mutex     sync.Mutex
+}
+
+// RunWorkflow runs a workflow as a background task, it is resolved with outputs of all steps
+// or rejected when a step is failed. Steps are called as the user of this call,
+// they are killed when this call is killed. Every change of step state is notified like this:
+//
+//	{"step":"build","state":"completed","output":...}
+func (tcCtx *TreeCallCtx) RunWorkflow(wf *Workflow) error {
+	if err := wf.Validate(); err != nil {
+		return err
+	}
+	run := &workflowRun{
+		ctx:     tcCtx,
+		wf:      wf,
+		states:  make(map[string]string, len(wf.Steps)),
+		outputs: make(map[string]interface{}, len(wf.Steps)),
+		calls:   make(map[string]*TreeCallCtx),
+	}
+	for _, step := range wf.Steps {
+		run.states[step.Name] = StepPending
+	}
+	tcCtx.mutex.Lock()
+	tcCtx.workflow = run
+	tcCtx.mutex.Unlock()
+
+	tcCtx.SetBackground(true)
+	// the workflow is one entry in Root.Bank, steps are not
+	tcCtx.killable()
+	tcCtx.Observe(func(ret *TreeCallReturn) {
+		if tcCtx.IsFinished() {
+			run.killAll()
+		}
+	})
+	tcCtx.Notify(map[string]interface{}{"workflow": "started", "steps": len(wf.Steps)})
+	run.startReady()
+	return nil
+}
+
+// startReady starts pending steps whose upstreams are completed
+func (run *workflowRun) startReady() {
+	type start struct {
+		step *WorkflowStep
+		args []string
+		kw   map[string]string
+	}
+	starts := make([]start, 0)
+	run.mutex.Lock()
+	if run.failed {
+		run.mutex.Unlock()
+		return
+	}
+	for _, step := range run.wf.Steps {
+		if run.states[step.Name] != StepPending {
+			continue
+		}
+		ready := true
+		for _, dep := range step.DependsOn {
+			if run.states[dep] != StepCompleted {
+				ready = false
+				break
+			}
+		}
+		if !ready {
+			continue
+		}
+		run.states[step.Name] = StepRunning
+		s := start{step: step, args: make([]string, len(step.Args)), kw: make(map[string]string, len(step.Kw))}
+		for i, arg := range step.Args {
+			s.args[i] = run.substitute(arg)
+		}
+		for k, v := range step.Kw {
+			s.kw[k] = run.substitute(v)
+		}
+		starts = append(starts, s)
+	}
+	run.mutex.Unlock()
+
+	root := run.ctx.Root
+	for _, s := range starts {
+		step := s.step
+		path := step.Path
+		if !strings.HasPrefix(path, root.Name+".") {
+			path = root.Name + "." + path
+		}
+		run.ctx.Notify(map[string]interface{}{"step": step.Name, "state": StepRunning})
+		call := root.CallChild(run.ctx, path, s.args, s.kw, func(ret *TreeCallReturn) {
+			run.onResult(step, ret)
+		})
+		run.mutex.Lock()
+		state := run.states[step.Name]
+		if state == StepRunning {
+			run.calls[step.Name] = call
+		}
+		run.mutex.Unlock()
+		if state == StepKilled {
+			// the workflow was finished while starting
+			call.Kill()
+		}
+	}
+}
+
+// substitute replaces placeholders in v with outputs, caller should hold run.mutex
+func (run *workflowRun) substitute(v string) string {
+	return placeholderRe.ReplaceAllStringFunc(v, func(placeholder string) string {
+		match := placeholderRe.FindStringSubmatch(placeholder)
+		value := run.outputs[match[1]]
+		for _, field := range strings.Split(match[2], ".")[1:] {
+			if object, ok := value.(map[string]interface{}); ok {
+				value = object[field]
+			} else {
+				value = nil
+				break
+			}
+		}
+		if s, ok := value.(string); ok {
+			return s
+		}
+		data, _ := json.Marshal(value)
+		return string(data)
+	})
+}
+
+// onResult handles results of a step
+func (run *workflowRun) onResult(step *WorkflowStep, ret *TreeCallReturn) {
+	if ret.Retcode < 0 {
+		// progress of step
+		if ret.Progress != nil {
+			run.ctx.Notify(map[string]interface{}{"step": step.Name, "state": StepRunning, "progress": FormatProgress(ret.Progress), "percent": ret.Progress.Percent})
+		} else if ret.Chunk != nil {
+			// binary stream of a step is not relayed
+		} else {
+			run.ctx.Notify(map[string]interface{}{"step": step.Name, "state": StepRunning, "progress": ret.Stdout})
+		}
+		return
+	}
+	run.mutex.Lock()
+	if run.states[step.Name] != StepRunning {
+		// killed by workflow
+		run.mutex.Unlock()
+		return
+	}
+	delete(run.calls, step.Name)
+	if ret.Retcode > 0 {
+		run.states[step.Name] = StepFailed
+		run.failed = true
+		run.mutex.Unlock()
+		message := ""
+		if ret.Stderr != nil {
+			message = ret.Stderr.Error()
+		}
+		run.ctx.Notify(map[string]interface{}{"step": step.Name, "state": StepFailed, "retcode": ret.Retcode, "error": message})
+		// other running steps are killed by the observer of RunWorkflow
+		run.ctx.Reject(ret.Retcode, NewTreeCallError(ret.Retcode, "step "+step.Name+" failed: "+message, map[string]interface{}{
+			"step":  step.Name,
+			"error": AsTreeCallError(ret.Retcode, ret.Stderr),
+		}))
+		return
+	}
+	output := normalizeOutput(ret.Stdout)
+	run.states[step.Name] = StepCompleted
+	run.outputs[step.Name] = output
+	run.completed++
+	done := run.completed == len(run.wf.Steps)
+	run.mutex.Unlock()
+	run.ctx.Notify(map[string]interface{}{"step": step.Name, "state": StepCompleted, "output": output})
+	if done {
+		run.mutex.Lock()
+		outputs := make(map[string]interface{}, len(run.outputs))
+		for k, v := range run.outputs {
+			outputs[k] = v
+		}
+		run.mutex.Unlock()
+		run.ctx.Resolve(outputs)
+		return
+	}
+	run.startReady()
+}
+
+// normalizeOutput converts stdout to plain values (map, slice, string, float64 ...) by JSON,
+// then fields of it can be used by placeholders
+func normalizeOutput(stdout interface{}) interface{} {
+	data, err := json.Marshal(stdout)
+	if err != nil {
+		return fmt.Sprint(stdout)
+	}
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return string(data)
+	}
+	return value
+}
+
+// killAll kills running steps, it is called when the workflow is finished
+func (run *workflowRun) killAll() {
+	run.mutex.Lock()
+	run.failed = true
+	calls := make([]*TreeCallCtx, 0, len(run.calls))
+	for name, call := range run.calls {
+		run.states[name] = StepKilled
+		calls = append(calls, call)
+	}
+	// steps which are being started
+	for name, state := range run.states {
+		if _, ok := run.calls[name]; !ok && state == StepRunning {
+			run.states[name] = StepKilled
+		}
+	}
+	run.calls = make(map[string]*TreeCallCtx)
+	run.mutex.Unlock()
+	for _, call := range calls {
+		call.Kill()
+	}
+}
+
+// status returns a readable progress, ex. "workflow 2/5 completed"
+func (run *workflowRun) status() string {
+	run.mutex.Lock()
+	defer run.mutex.Unlock()
+	running := make([]string, 0, len(run.calls))
+	for name := range run.calls {
+		running = append(running, name)
+	}
+	status := fmt.Sprintf("workflow %d/%d completed", run.completed, len(run.wf.Steps))
+	if len(running) > 0 {
+		status += ", running " + strings.Join(running, ", ")
+	}
+	return status
+}
+
+// CallChild calls nodePath as the user of parent in background.
+// The child is cancelled with the context of parent, and it is not put into Root.Bank.
+func (self *TreeRoot) CallChild(parent *TreeCallCtx, nodePath string, args []string, kw map[string]string, observer TreeCallObserver) *TreeCallCtx {
+	ctx := self.newInternalCallCtx(parent.WsCtx.GetUser(), args, kw, observer)
+	ctx.parent = parent
+	// replace the context by a child of parent's
+	parent.mutex.RLock()
+	parentContext := parent.context
+	parent.mutex.RUnlock()
+	ctx.cancel()
+	ctx.context, ctx.cancel = context.WithCancel(parentContext)
+	go self.Call(nodePath, ctx)
+	return ctx
+}
@@ -0,0 +1,105 @@
+package model
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWorkflowValidate(t *testing.T) {
+	cases := []struct {
+		json string
+		err  string
+	}{
+		{`{"steps":[]}`, "no steps"},
+		{`{"steps":[{"name":"a"}]}`, "required"},
+		{`{"steps":[{"name":"a","path":"x.A"},{"name":"a","path":"x.B"}]}`, "duplicated step a"},
+		{`{"steps":[{"name":"a","path":"x.A","dependsOn":["z"]}]}`, "unknown step z"},
+		{`{"steps":[{"name":"a","path":"x.A","dependsOn":["a"]}]}`, "cyclic"},
+		{`{"steps":[
+			{"name":"a","path":"x.A","dependsOn":["c"]},
+			{"name":"b","path":"x.B","dependsOn":["a"]},
+			{"name":"c","path":"x.C","dependsOn":["b"]}]}`, "cyclic"},
+		// placeholders refer to upstreams only, directly or not
+		{`{"steps":[{"name":"a","path":"x.A","args":["{{b.output}}"]},{"name":"b","path":"x.B"}]}`, "not its upstream"},
+		{`{"steps":[
+			{"name":"a","path":"x.A"},
+			{"name":"b","path":"x.B","dependsOn":["a"]},
+			{"name":"c","path":"x.C","kw":{"k":"{{ b.output.url }}"},"dependsOn":["a"]}]}`, "not its upstream"},
+		{`{"steps":[
+			{"name":"a","path":"x.A"},
+			{"name":"b","path":"x.B","dependsOn":["a"]},
+			{"name":"c","path":"x.C","args":["{{a.output.url}}","{{b.output}}"],"dependsOn":["b"]}]}`, ""},
+		// a diamond is not a cycle
+		{`{"steps":[
+			{"name":"a","path":"x.A"},
+			{"name":"b","path":"x.B","dependsOn":["a"]},
+			{"name":"c","path":"x.C","dependsOn":["a"]},
+			{"name":"d","path":"x.D","dependsOn":["b","c"]}]}`, ""},
+	}
+	for i, c := range cases {
+		_, err := ParseWorkflow([]byte(c.json))
+		switch {
+		case c.err == "" && err != nil:
+			t.Errorf("case %d: expect valid, got %v", i, err)
+		case c.err != "" && (err == nil || !strings.Contains(err.Error(), c.err)):
+			t.Errorf("case %d: expect error %q, got %v", i, c.err, err)
+		}
+	}
+}
+
+// runWorkflow runs wf in a background call of root, returns the call and the channel of its final result
+func runWorkflow(t *testing.T, root *TreeRoot, wf string) (*TreeCallCtx, chan *TreeCallReturn) {
+	workflow, err := ParseWorkflow([]byte(wf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	finished := make(chan *TreeCallReturn, 1)
+	ctx := root.newInternalCallCtx(nil, nil, nil, func(ret *TreeCallReturn) {
+		if ret.Retcode >= 0 {
+			finished <- ret
+		}
+	})
+	if err := ctx.RunWorkflow(workflow); err != nil {
+		t.Fatal(err)
+	}
+	return ctx, finished
+}
+
+func TestWorkflowPassesOutputs(t *testing.T) {
+	_, finished := runWorkflow(t, newTestRoot(), `{"steps":[
+		{"name":"a","path":"test.Echo","args":["x"]},
+		{"name":"b","path":"Tree.test.Echo","args":["{{a.output}}"],"dependsOn":["a"]},
+		{"name":"c","path":"test.Echo","args":["<{{b.output}}>"],"dependsOn":["b"]}]}`)
+	ret := waitResult(t, finished)
+	if ret.Retcode != 0 {
+		t.Fatalf("expect resolved, got %d %v", ret.Retcode, ret.Stderr)
+	}
+	outputs := ret.Stdout.(map[string]interface{})
+	if c, _ := outputs["c"].([]interface{}); len(c) != 1 || c[0] != `<["[\"x\"]"]>` {
+		t.Fatalf("unexpected outputs %v", outputs)
+	}
+}
+
+func TestWorkflowFailedStepKillsOthers(t *testing.T) {
+	ctx, finished := runWorkflow(t, newTestRoot(), `{"steps":[
+		{"name":"wait","path":"test.Wait"},
+		{"name":"echo","path":"test.Echo"},
+		{"name":"bad","path":"test.Missing","dependsOn":["echo"]},
+		{"name":"never","path":"test.Echo","dependsOn":["wait"]}]}`)
+	ret := waitResult(t, finished)
+	if ret.Retcode == 0 || !strings.Contains(ret.Stderr.Error(), "step bad failed") {
+		t.Fatalf("expect rejected by step bad, got %d %v", ret.Retcode, ret.Stderr)
+	}
+	run := ctx.workflow
+	run.mutex.Lock()
+	defer run.mutex.Unlock()
+	expect := map[string]string{"wait": StepKilled, "echo": StepCompleted, "bad": StepFailed, "never": StepPending}
+	for name, state := range expect {
+		if run.states[name] != state {
+			t.Errorf("expect step %s %s, got %s", name, state, run.states[name])
+		}
+	}
+	if len(run.calls) != 0 {
+		t.Errorf("expect no running step, got %d", len(run.calls))
+	}
+}
@@ -0,0 +1,486 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// source: grpc_style.proto
+
+package rpc
+
+import (
+	context "context"
+	fmt "fmt"
+	proto "github.com/golang/protobuf/proto"
+	model "github.com/iapyeh/fastjob/model"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+	math "math"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.ProtoPackageIsVersion3 // please upgrade the proto package
+
+type KillRequest struct {
+	// id of the job, see Result.id
+	Id                   int32    `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *KillRequest) Reset()         { *m = KillRequest{} }
+func (m *KillRequest) String() string { return proto.CompactTextString(m) }
+func (*KillRequest) ProtoMessage()    {}
+func (*KillRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_31584da4b4fc07a5, []int{0}
+}
+
+func (m *KillRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_KillRequest.Unmarshal(m, b)
+}
+func (m *KillRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_KillRequest.Marshal(b, m, deterministic)
+}
+func (m *KillRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_KillRequest.Merge(m, src)
+}
+func (m *KillRequest) XXX_Size() int {
+	return xxx_messageInfo_KillRequest.Size(m)
+}
+func (m *KillRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_KillRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_KillRequest proto.InternalMessageInfo
+
+func (m *KillRequest) GetId() int32 {
+	if m != nil {
+		return m.Id
+	}
+	return 0
+}
+
+type KillReply struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *KillReply) Reset()         { *m = KillReply{} }
+func (m *KillReply) String() string { return proto.CompactTextString(m) }
+func (*KillReply) ProtoMessage()    {}
+func (*KillReply) Descriptor() ([]byte, []int) {
+	return fileDescriptor_31584da4b4fc07a5, []int{1}
+}
+
+func (m *KillReply) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_KillReply.Unmarshal(m, b)
+}
+func (m *KillReply) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_KillReply.Marshal(b, m, deterministic)
+}
+func (m *KillReply) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_KillReply.Merge(m, src)
+}
+func (m *KillReply) XXX_Size() int {
+	return xxx_messageInfo_KillReply.Size(m)
+}
+func (m *KillReply) XXX_DiscardUnknown() {
+	xxx_messageInfo_KillReply.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_KillReply proto.InternalMessageInfo
+
+type ListJobsRequest struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ListJobsRequest) Reset()         { *m = ListJobsRequest{} }
+func (m *ListJobsRequest) String() string { return proto.CompactTextString(m) }
+func (*ListJobsRequest) ProtoMessage()    {}
+func (*ListJobsRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_31584da4b4fc07a5, []int{2}
+}
+
+func (m *ListJobsRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ListJobsRequest.Unmarshal(m, b)
+}
+func (m *ListJobsRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ListJobsRequest.Marshal(b, m, deterministic)
+}
+func (m *ListJobsRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ListJobsRequest.Merge(m, src)
+}
+func (m *ListJobsRequest) XXX_Size() int {
+	return xxx_messageInfo_ListJobsRequest.Size(m)
+}
+func (m *ListJobsRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_ListJobsRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ListJobsRequest proto.InternalMessageInfo
+
+type Job struct {
+	Id int32 `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	// <TreeName>.<branch path>.<FuncName>
+	Path string            `protobuf:"bytes,2,opt,name=path,proto3" json:"path,omitempty"`
+	Args []string          `protobuf:"bytes,3,rep,name=args,proto3" json:"args,omitempty"`
+	Kw   map[string]string `protobuf:"bytes,4,rep,name=kw,proto3" json:"kw,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	// timestamp of creation
+	Ctime uint32 `protobuf:"varint,5,opt,name=ctime,proto3" json:"ctime,omitempty"`
+	// state of the job, ex. queued, running or paused, see TreeCallCtx.JobStatus()
+	Status               string          `protobuf:"bytes,6,opt,name=status,proto3" json:"status,omitempty"`
+	Progress             *model.Progress `protobuf:"bytes,7,opt,name=progress,proto3" json:"progress,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}        `json:"-"`
+	XXX_unrecognized     []byte          `json:"-"`
+	XXX_sizecache        int32           `json:"-"`
+}
+
+func (m *Job) Reset()         { *m = Job{} }
+func (m *Job) String() string { return proto.CompactTextString(m) }
+func (*Job) ProtoMessage()    {}
+func (*Job) Descriptor() ([]byte, []int) {
+	return fileDescriptor_31584da4b4fc07a5, []int{3}
+}
+
+func (m *Job) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Job.Unmarshal(m, b)
+}
+func (m *Job) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Job.Marshal(b, m, deterministic)
+}
+func (m *Job) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Job.Merge(m, src)
+}
+func (m *Job) XXX_Size() int {
+	return xxx_messageInfo_Job.Size(m)
+}
+func (m *Job) XXX_DiscardUnknown() {
+	xxx_messageInfo_Job.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Job proto.InternalMessageInfo
+
+func (m *Job) GetId() int32 {
+	if m != nil {
+		return m.Id
+	}
+	return 0
+}
+
+func (m *Job) GetPath() string {
+	if m != nil {
+		return m.Path
+	}
+	return ""
+}
+
+func (m *Job) GetArgs() []string {
+	if m != nil {
+		return m.Args
+	}
+	return nil
+}
+
+func (m *Job) GetKw() map[string]string {
+	if m != nil {
+		return m.Kw
+	}
+	return nil
+}
+
+func (m *Job) GetCtime() uint32 {
+	if m != nil {
+		return m.Ctime
+	}
+	return 0
+}
+
+func (m *Job) GetStatus() string {
+	if m != nil {
+		return m.Status
+	}
+	return ""
+}
+
+func (m *Job) GetProgress() *model.Progress {
+	if m != nil {
+		return m.Progress
+	}
+	return nil
+}
+
+type ListJobsReply struct {
+	Jobs                 []*Job   `protobuf:"bytes,1,rep,name=jobs,proto3" json:"jobs,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ListJobsReply) Reset()         { *m = ListJobsReply{} }
+func (m *ListJobsReply) String() string { return proto.CompactTextString(m) }
+func (*ListJobsReply) ProtoMessage()    {}
+func (*ListJobsReply) Descriptor() ([]byte, []int) {
+	return fileDescriptor_31584da4b4fc07a5, []int{4}
+}
+
+func (m *ListJobsReply) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ListJobsReply.Unmarshal(m, b)
+}
+func (m *ListJobsReply) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ListJobsReply.Marshal(b, m, deterministic)
+}
+func (m *ListJobsReply) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ListJobsReply.Merge(m, src)
+}
+func (m *ListJobsReply) XXX_Size() int {
+	return xxx_messageInfo_ListJobsReply.Size(m)
+}
+func (m *ListJobsReply) XXX_DiscardUnknown() {
+	xxx_messageInfo_ListJobsReply.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ListJobsReply proto.InternalMessageInfo
+
+func (m *ListJobsReply) GetJobs() []*Job {
+	if m != nil {
+		return m.Jobs
+	}
+	return nil
+}
+
+func init() {
+	proto.RegisterType((*KillRequest)(nil), "objsh.KillRequest")
+	proto.RegisterType((*KillReply)(nil), "objsh.KillReply")
+	proto.RegisterType((*ListJobsRequest)(nil), "objsh.ListJobsRequest")
+	proto.RegisterType((*Job)(nil), "objsh.Job")
+	proto.RegisterMapType((map[string]string)(nil), "objsh.Job.KwEntry")
+	proto.RegisterType((*ListJobsReply)(nil), "objsh.ListJobsReply")
+}
+
+func init() { proto.RegisterFile("grpc_style.proto", fileDescriptor_31584da4b4fc07a5) }
+
+var fileDescriptor_31584da4b4fc07a5 = []byte{
+	// 370 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x64, 0x52, 0x4d, 0x8f, 0xd3, 0x30,
+	0x10, 0x95, 0xf3, 0xd1, 0xdd, 0x4e, 0x94, 0xdd, 0x32, 0x5a, 0xad, 0xac, 0x48, 0xa0, 0x28, 0x17,
+	0x22, 0x81, 0x02, 0x2a, 0x42, 0x5a, 0x71, 0x64, 0xc5, 0xa5, 0xcb, 0x01, 0x59, 0x9c, 0xb8, 0xa0,
+	0xa4, 0xb5, 0xba, 0x69, 0xdd, 0xda, 0xd8, 0x0e, 0x55, 0x7e, 0x09, 0x3f, 0x94, 0x3f, 0x80, 0xe2,
+	0xb8, 0x14, 0xba, 0x27, 0xcf, 0x7b, 0x33, 0xf6, 0x9b, 0x37, 0x63, 0x98, 0xad, 0xb5, 0x5a, 0x7e,
+	0x37, 0xb6, 0x17, 0xbc, 0x52, 0x5a, 0x5a, 0x89, 0xb1, 0x6c, 0x36, 0xe6, 0x31, 0x4b, 0xdd, 0xa1,
+	0x9a, 0x91, 0x2d, 0x9e, 0x43, 0xf2, 0xd0, 0x0a, 0xc1, 0xf8, 0x8f, 0x8e, 0x1b, 0x8b, 0x57, 0x10,
+	0xb4, 0x2b, 0x4a, 0x72, 0x52, 0xc6, 0x2c, 0x68, 0x57, 0x45, 0x02, 0xd3, 0x31, 0xad, 0x44, 0x5f,
+	0x3c, 0x83, 0xeb, 0xcf, 0xad, 0xb1, 0x0b, 0xd9, 0x18, 0x5f, 0x5f, 0xfc, 0x26, 0x10, 0x2e, 0x64,
+	0x73, 0x7e, 0x0f, 0x11, 0x22, 0x55, 0xdb, 0x47, 0x1a, 0xe4, 0xa4, 0x9c, 0x32, 0x17, 0x0f, 0x5c,
+	0xad, 0xd7, 0x86, 0x86, 0x79, 0x38, 0x70, 0x43, 0x8c, 0x05, 0x04, 0xdb, 0x03, 0x8d, 0xf2, 0xb0,
+	0x4c, 0xe6, 0x58, 0xb9, 0xd6, 0xaa, 0x85, 0x6c, 0xaa, 0x87, 0xc3, 0xa7, 0xbd, 0xd5, 0x3d, 0x0b,
+	0xb6, 0x07, 0xbc, 0x81, 0x78, 0x69, 0xdb, 0x1d, 0xa7, 0x71, 0x4e, 0xca, 0x94, 0x8d, 0x00, 0x6f,
+	0x61, 0x62, 0x6c, 0x6d, 0x3b, 0x43, 0x27, 0x4e, 0xc3, 0x23, 0x7c, 0x05, 0x97, 0x4a, 0xcb, 0xb5,
+	0xe6, 0xc6, 0xd0, 0x8b, 0x9c, 0x94, 0xc9, 0xfc, 0xda, 0xbf, 0xfb, 0xc5, 0xd3, 0xec, 0x6f, 0x41,
+	0xf6, 0x1e, 0x2e, 0xbc, 0x12, 0xce, 0x20, 0xdc, 0xf2, 0xde, 0x59, 0x98, 0xb2, 0x21, 0x1c, 0x74,
+	0x7f, 0xd6, 0xa2, 0xe3, 0xde, 0xc4, 0x08, 0x3e, 0x04, 0x77, 0xa4, 0x78, 0x03, 0xe9, 0x69, 0x10,
+	0x4a, 0xf4, 0xf8, 0x02, 0xa2, 0x8d, 0x6c, 0x0c, 0x25, 0xce, 0x08, 0x9c, 0x8c, 0x30, 0xc7, 0xcf,
+	0x7f, 0x11, 0x88, 0xbe, 0x6a, 0xce, 0xf1, 0x25, 0x44, 0xf7, 0xb5, 0x10, 0x78, 0xe5, 0x4b, 0xee,
+	0xe5, 0x6e, 0x57, 0xef, 0x57, 0x59, 0xea, 0x31, 0xe3, 0xa6, 0x13, 0xf6, 0x2d, 0xc1, 0xd7, 0x10,
+	0x0d, 0x83, 0xc7, 0xe3, 0x50, 0xfe, 0x59, 0x52, 0x36, 0xfb, 0x8f, 0x1b, 0xf4, 0xef, 0xe0, 0xf2,
+	0xd8, 0x10, 0xde, 0xfa, 0xec, 0xd9, 0xaa, 0xb2, 0x9b, 0x27, 0xbc, 0x12, 0xfd, 0xc7, 0xf8, 0x5b,
+	0xa8, 0xd5, 0xb2, 0x99, 0xb8, 0xdf, 0xf0, 0xee, 0xcf, 0x00, 0x7c, 0xb4, 0xf8, 0x35, 0x37, 0x02,
+	0x00, 0x00,
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConn
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion4
+
+// TreeClient is the client API for Tree service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
+type TreeClient interface {
+	// calls Command.name (ex. Tree.$exec.Run), results are streamed until
+	// it is resolved or rejected (retcode >= 0). Result.id is the id of the job,
+	// which is also given by header "x-cmd-id".
+	Call(ctx context.Context, in *model.Command, opts ...grpc.CallOption) (Tree_CallClient, error)
+	// kills a job of the caller
+	Kill(ctx context.Context, in *KillRequest, opts ...grpc.CallOption) (*KillReply, error)
+	// lists jobs of the caller, like $.ListUserTasks
+	ListJobs(ctx context.Context, in *ListJobsRequest, opts ...grpc.CallOption) (*ListJobsReply, error)
+}
+
+type treeClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewTreeClient(cc *grpc.ClientConn) TreeClient {
+	return &treeClient{cc}
+}
+
+func (c *treeClient) Call(ctx context.Context, in *model.Command, opts ...grpc.CallOption) (Tree_CallClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_Tree_serviceDesc.Streams[0], "/objsh.Tree/Call", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &treeCallClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Tree_CallClient interface {
+	Recv() (*model.Result, error)
+	grpc.ClientStream
+}
+
+type treeCallClient struct {
+	grpc.ClientStream
+}
+
+func (x *treeCallClient) Recv() (*model.Result, error) {
+	m := new(model.Result)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *treeClient) Kill(ctx context.Context, in *KillRequest, opts ...grpc.CallOption) (*KillReply, error) {
+	out := new(KillReply)
+	err := c.cc.Invoke(ctx, "/objsh.Tree/Kill", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *treeClient) ListJobs(ctx context.Context, in *ListJobsRequest, opts ...grpc.CallOption) (*ListJobsReply, error) {
+	out := new(ListJobsReply)
+	err := c.cc.Invoke(ctx, "/objsh.Tree/ListJobs", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// TreeServer is the server API for Tree service.
+type TreeServer interface {
+	// calls Command.name (ex. Tree.$exec.Run), results are streamed until
+	// it is resolved or rejected (retcode >= 0). Result.id is the id of the job,
+	// which is also given by header "x-cmd-id".
+	Call(*model.Command, Tree_CallServer) error
+	// kills a job of the caller
+	Kill(context.Context, *KillRequest) (*KillReply, error)
+	// lists jobs of the caller, like $.ListUserTasks
+	ListJobs(context.Context, *ListJobsRequest) (*ListJobsReply, error)
+}
+
+// UnimplementedTreeServer can be embedded to have forward compatible implementations.
+type UnimplementedTreeServer struct {
+}
+
+func (*UnimplementedTreeServer) Call(req *model.Command, srv Tree_CallServer) error {
+	return status.Errorf(codes.Unimplemented, "method Call not implemented")
+}
+func (*UnimplementedTreeServer) Kill(ctx context.Context, req *KillRequest) (*KillReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Kill not implemented")
+}
+func (*UnimplementedTreeServer) ListJobs(ctx context.Context, req *ListJobsRequest) (*ListJobsReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListJobs not implemented")
+}
+
+func RegisterTreeServer(s *grpc.Server, srv TreeServer) {
+	s.RegisterService(&_Tree_serviceDesc, srv)
+}
+
+func _Tree_Call_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(model.Command)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(TreeServer).Call(m, &treeCallServer{stream})
+}
+
+type Tree_CallServer interface {
+	Send(*model.Result) error
+	grpc.ServerStream
+}
+
+type treeCallServer struct {
+	grpc.ServerStream
+}
+
+func (x *treeCallServer) Send(m *model.Result) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Tree_Kill_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(KillRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TreeServer).Kill(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/objsh.Tree/Kill",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TreeServer).Kill(ctx, req.(*KillRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Tree_ListJobs_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListJobsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TreeServer).ListJobs(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/objsh.Tree/ListJobs",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TreeServer).ListJobs(ctx, req.(*ListJobsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _Tree_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "objsh.Tree",
+	HandlerType: (*TreeServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Kill",
+			Handler:    _Tree_Kill_Handler,
+		},
+		{
+			MethodName: "ListJobs",
+			Handler:    _Tree_ListJobs_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Call",
+			Handler:       _Tree_Call_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "grpc_style.proto",
+}
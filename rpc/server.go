@@ -0,0 +1,289 @@
+/*
+Package rpc serves calls of a TreeRoot over gRPC, for backend services which call branches
+with the same semantics the browser gets, see protobuf/grpc_style.proto.
+
+	server := grpc.NewServer()
+	rpc.Register(server, treeRoot)
+	lis, _ := net.Listen("tcp", ":50051")
+	server.Serve(lis)
+
+A client authenticates by metadata "authorization: Bearer <token>", the token is the
+same as the one in cookie of a logged-in browser. Calls without token are issued as a guest.
+
+Binary streams sent by TreeCallCtx.StreamWriter() come in Result.chunk, a client could
+reassemble them by model.ChunkAssembler.
+*/
+package rpc
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/iapyeh/fastjob/model"
+	"github.com/valyala/fasthttp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Authenticator returns the user of an incoming call, nil for a guest
+type Authenticator func(ctx context.Context) (model.User, error)
+
+// Server implements TreeServer over a TreeRoot
+type Server struct {
+	Root *model.TreeRoot
+	// default is TokenAuthenticator
+	Authenticate Authenticator
+}
+
+// Register serves calls of root on server
+func Register(server *grpc.Server, root *model.TreeRoot) *Server {
+	s := &Server{Root: root, Authenticate: TokenAuthenticator}
+	RegisterTreeServer(server, s)
+	return s
+}
+
+// TokenAuthenticator finds the user by the token in metadata "authorization" (Bearer <token>),
+// it is verified by model.AuthProvierSingleton as a cookie of browser.
+func TokenAuthenticator(ctx context.Context) (model.User, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get("authorization")
+	if len(values) == 0 {
+		return nil, nil
+	}
+	token := strings.TrimSpace(strings.TrimPrefix(values[0], "Bearer "))
+	if token == "" || model.AuthProvierSingleton == nil {
+		return nil, errors.New("invalid token")
+	}
+	reqCtx := &fasthttp.RequestCtx{}
+	reqCtx.Request.Header.SetCookie(model.AuthTokenName, token)
+	if user := model.AuthProvierSingleton.UserFromRequest(reqCtx); user != nil {
+		return user, nil
+	}
+	return nil, errors.New("invalid token")
+}
+
+func (s *Server) user(ctx context.Context) (model.User, error) {
+	user, err := s.Authenticate(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	return user, nil
+}
+
+// Call runs cmd and streams its results until it is resolved or rejected.
+// A foreground call is killed when the client cancels, like a browser goes away.
+func (s *Server) Call(cmd *model.Command, stream Tree_CallServer) error {
+	user, err := s.user(stream.Context())
+	if err != nil {
+		return err
+	}
+	nodePath := cmd.Name
+	if !strings.HasPrefix(nodePath, s.Root.Name+".") {
+		nodePath = s.Root.Name + "." + nodePath
+	}
+	var pbMsg proto.Message
+	if cmd.Message != nil {
+		if pbMsg, err = ptypes.Empty(cmd.Message); err == nil {
+			err = proto.Unmarshal(cmd.Message.Value, pbMsg)
+		}
+		if err != nil {
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+	// ids of browsers are given by browsers, they might conflict with ids of other clients
+	cmdID := model.NextInternalCmdID()
+	stream.SendHeader(metadata.Pairs("x-cmd-id", strconv.FormatInt(int64(cmdID), 10)))
+
+	listener := newCallStream(user, cmdID, stream)
+	callCtx := model.NewTreeCallCtx(s.Root, cmdID, listener, cmd.Args, &cmd.Kw, &pbMsg)
+	if cmd.Timeout > 0 {
+		callCtx.SetTimeout(time.Duration(cmd.Timeout) * time.Millisecond)
+	}
+	go s.Root.Call(nodePath, callCtx)
+
+	select {
+	case <-listener.done:
+		listener.close()
+		return nil
+	case <-stream.Context().Done():
+		// foreground call is killed, background call keeps running
+		listener.close()
+		return status.FromContextError(stream.Context().Err()).Err()
+	}
+}
+
+// job returns a job of user in Root.Bank, an administrator could access any job
+func (s *Server) job(user model.User, cmdID int32) *model.TreeCallCtx {
+	if user == nil {
+		return nil
+	}
+	if model.AdminChecker(user) {
+		return s.Root.Bank.Get(cmdID)
+	}
+	jobs, _ := s.Root.Bank.ListUser(user)
+	for _, job := range jobs {
+		if job != nil && job.CmdID == cmdID {
+			return job
+		}
+	}
+	return nil
+}
+
+// Kill kills a job of the caller
+func (s *Server) Kill(ctx context.Context, req *KillRequest) (*KillReply, error) {
+	user, err := s.user(ctx)
+	if err != nil {
+		return nil, err
+	}
+	job := s.job(user, req.Id)
+	if job == nil {
+		return nil, status.Errorf(codes.NotFound, "job %d not found", req.Id)
+	}
+	job.Kill()
+	return &KillReply{}, nil
+}
+
+// ListJobs lists jobs of the caller in Root.Bank
+func (s *Server) ListJobs(ctx context.Context, req *ListJobsRequest) (*ListJobsReply, error) {
+	user, err := s.user(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reply := &ListJobsReply{}
+	if user == nil {
+		return reply, nil
+	}
+	jobs, _ := s.Root.Bank.ListUser(user)
+	for _, job := range jobs {
+		if job == nil {
+			continue
+		}
+		kw := make(map[string]string)
+		job.Kw.VisitAll(func(key, value []byte) {
+			kw[string(key)] = string(value)
+		})
+		reply.Jobs = append(reply.Jobs, &Job{
+			Id:       job.CmdID,
+			Path:     strings.Split(job.CmdPath, "\t")[0],
+			Args:     job.Args,
+			Kw:       kw,
+			Ctime:    job.Ctime,
+			Status:   job.JobStatus(),
+			Progress: job.LastProgress(),
+		})
+	}
+	return reply, nil
+}
+
+// callStream is the PromiseStateListener of a Call, as WebsocketCtx of a browser.
+// It is not a ChunkCarrier since Call streams results only, so Upload() fails at once.
+type callStream struct {
+	id     string
+	cmdID  int32
+	user   model.User
+	stream Tree_CallServer
+	// closed after the final result is sent
+	done          chan struct{}
+	finished      bool
+	closed        bool
+	closeListener map[string]model.CloseEventHandler
+	mutex         sync.Mutex
+}
+
+func newCallStream(user model.User, cmdID int32, stream Tree_CallServer) *callStream {
+	return &callStream{
+		id:            "grpc" + strconv.FormatInt(int64(cmdID), 10),
+		cmdID:         cmdID,
+		user:          user,
+		stream:        stream,
+		done:          make(chan struct{}),
+		closeListener: make(map[string]model.CloseEventHandler),
+	}
+}
+
+// close fires Close listeners, the call is killed if it is not in background
+func (cs *callStream) close() {
+	cs.mutex.Lock()
+	if cs.closed {
+		cs.mutex.Unlock()
+		return
+	}
+	cs.closed = true
+	listeners := cs.closeListener
+	cs.closeListener = nil
+	cs.mutex.Unlock()
+	for _, fn := range listeners {
+		fn()
+	}
+}
+
+func (cs *callStream) GetUser() model.User {
+	return cs.user
+}
+func (cs *callStream) GenID() string {
+	return cs.id
+}
+func (cs *callStream) IsClosed() bool {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+	return cs.closed
+}
+
+// On accepts "Close" only
+func (cs *callStream) On(evtName string, token string, fn interface{}, args ...interface{}) bool {
+	if evtName != "Close" {
+		return false
+	}
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+	if cs.closed {
+		return false
+	}
+	if _, ok := cs.closeListener[token]; ok {
+		return false
+	}
+	cs.closeListener[token] = fn.(model.CloseEventHandler)
+	return true
+}
+func (cs *callStream) Off(evtName string, token string) bool {
+	if evtName != "Close" {
+		return false
+	}
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+	delete(cs.closeListener, token)
+	return true
+}
+func (cs *callStream) SendTreeCallReturn(ret *model.TreeCallReturn) (int, error) {
+	return cs.SendProtobufMessage(model.ResultOf(ret))
+}
+
+// SendProtobufMessage sends a Result, messages of other types are ignored
+func (cs *callStream) SendProtobufMessage(message proto.Message) (int, error) {
+	result, ok := message.(*model.Result)
+	if !ok {
+		return 0, nil
+	}
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+	if cs.closed || cs.finished {
+		return 0, errors.New("call is closed")
+	}
+	// Send() of a stream should not be called concurrently
+	if err := cs.stream.Send(result); err != nil {
+		return 0, err
+	}
+	if result.Id == cs.cmdID && result.Retcode >= 0 {
+		cs.finished = true
+		close(cs.done)
+	}
+	return proto.Size(result), nil
+}
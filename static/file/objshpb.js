@@ -663,7 +663,9 @@ var global = Function('return this')();
 
 var google_protobuf_Any_pb = require('google-protobuf/google/protobuf/Any_pb.js');
 goog.object.extend(proto, google_protobuf_Any_pb);
+goog.exportSymbol('proto.objsh.Chunk', null, global);
 goog.exportSymbol('proto.objsh.Command', null, global);
+goog.exportSymbol('proto.objsh.Progress', null, global);
 goog.exportSymbol('proto.objsh.Result', null, global);
 /**
  * Generated by JsPbCodeGenerator.
@@ -707,6 +709,27 @@ if (goog.DEBUG && !COMPILED) {
    */
   proto.objsh.Result.displayName = 'proto.objsh.Result';
 }
+/**
+ * Generated by JsPbCodeGenerator.
+ * @param {Array=} opt_data Optional initial data array, typically from a
+ * server response, or constructed directly in Javascript. The array is used
+ * in place and becomes part of the constructed object. It is not cloned.
+ * If no data is provided, the constructed object will be empty, but still
+ * valid.
+ * @extends {jspb.Message}
+ * @constructor
+ */
+proto.objsh.Progress = function(opt_data) {
+  jspb.Message.initialize(this, opt_data, 0, -1, null, null);
+};
+goog.inherits(proto.objsh.Progress, jspb.Message);
+if (goog.DEBUG && !COMPILED) {
+  /**
+   * @public
+   * @override
+   */
+  proto.objsh.Progress.displayName = 'proto.objsh.Progress';
+}
 
 /**
  * List of repeated fields within this message type.
@@ -751,7 +774,10 @@ proto.objsh.Command.toObject = function(includeInstance, msg) {
     argsList: (f = jspb.Message.getRepeatedField(msg, 3)) == null ? undefined : f,
     kwMap: (f = msg.getKwMap()) ? f.toObject(includeInstance, undefined) : [],
     message: (f = msg.getMessage()) && google_protobuf_Any_pb.Any.toObject(includeInstance, f),
-    kill: jspb.Message.getBooleanFieldWithDefault(msg, 8, false)
+    kill: jspb.Message.getBooleanFieldWithDefault(msg, 8, false),
+    timeout: jspb.Message.getFieldWithDefault(msg, 9, 0),
+    pause: jspb.Message.getBooleanFieldWithDefault(msg, 10, false),
+    resume: jspb.Message.getBooleanFieldWithDefault(msg, 11, false)
   };
 
   if (includeInstance) {
@@ -815,6 +841,18 @@ proto.objsh.Command.deserializeBinaryFromReader = function(msg, reader) {
       var value = /** @type {boolean} */ (reader.readBool());
       msg.setKill(value);
       break;
+    case 9:
+      var value = /** @type {number} */ (reader.readInt32());
+      msg.setTimeout(value);
+      break;
+    case 10:
+      var value = /** @type {boolean} */ (reader.readBool());
+      msg.setPause(value);
+      break;
+    case 11:
+      var value = /** @type {boolean} */ (reader.readBool());
+      msg.setResume(value);
+      break;
     default:
       reader.skipField();
       break;
@@ -884,6 +922,27 @@ proto.objsh.Command.serializeBinaryToWriter = function(message, writer) {
       f
     );
   }
+  f = message.getTimeout();
+  if (f !== 0) {
+    writer.writeInt32(
+      9,
+      f
+    );
+  }
+  f = message.getPause();
+  if (f) {
+    writer.writeBool(
+      10,
+      f
+    );
+  }
+  f = message.getResume();
+  if (f) {
+    writer.writeBool(
+      11,
+      f
+    );
+  }
 };
 
 
@@ -1018,6 +1077,51 @@ proto.objsh.Command.prototype.setKill = function(value) {
 };
 
 
+/**
+ * optional int32 timeout = 9;
+ * @return {number}
+ */
+proto.objsh.Command.prototype.getTimeout = function() {
+  return /** @type {number} */ (jspb.Message.getFieldWithDefault(this, 9, 0));
+};
+
+
+/** @param {number} value */
+proto.objsh.Command.prototype.setTimeout = function(value) {
+  jspb.Message.setProto3IntField(this, 9, value);
+};
+
+
+/**
+ * optional bool pause = 10;
+ * @return {boolean}
+ */
+proto.objsh.Command.prototype.getPause = function() {
+  return /** @type {boolean} */ (jspb.Message.getBooleanFieldWithDefault(this, 10, false));
+};
+
+
+/** @param {boolean} value */
+proto.objsh.Command.prototype.setPause = function(value) {
+  jspb.Message.setProto3BooleanField(this, 10, value);
+};
+
+
+/**
+ * optional bool resume = 11;
+ * @return {boolean}
+ */
+proto.objsh.Command.prototype.getResume = function() {
+  return /** @type {boolean} */ (jspb.Message.getBooleanFieldWithDefault(this, 11, false));
+};
+
+
+/** @param {boolean} value */
+proto.objsh.Command.prototype.setResume = function(value) {
+  jspb.Message.setProto3BooleanField(this, 11, value);
+};
+
+
 
 
 
@@ -1053,7 +1157,9 @@ proto.objsh.Result.toObject = function(includeInstance, msg) {
     id: jspb.Message.getFieldWithDefault(msg, 1, 0),
     retcode: jspb.Message.getFieldWithDefault(msg, 2, 0),
     stdout: msg.getStdout_asB64(),
-    stderr: jspb.Message.getFieldWithDefault(msg, 4, "")
+    stderr: jspb.Message.getFieldWithDefault(msg, 4, ""),
+    progress: (f = msg.getProgress()) && proto.objsh.Progress.toObject(includeInstance, f),
+    chunk: (f = msg.getChunk()) && proto.objsh.Chunk.toObject(includeInstance, f)
   };
 
   if (includeInstance) {
@@ -1106,6 +1212,16 @@ proto.objsh.Result.deserializeBinaryFromReader = function(msg, reader) {
       var value = /** @type {string} */ (reader.readString());
       msg.setStderr(value);
       break;
+    case 5:
+      var value = new proto.objsh.Progress;
+      reader.readMessage(value,proto.objsh.Progress.deserializeBinaryFromReader);
+      msg.setProgress(value);
+      break;
+    case 6:
+      var value = new proto.objsh.Chunk;
+      reader.readMessage(value,proto.objsh.Chunk.deserializeBinaryFromReader);
+      msg.setChunk(value);
+      break;
     default:
       reader.skipField();
       break;
@@ -1162,6 +1278,21 @@ proto.objsh.Result.serializeBinaryToWriter = function(message, writer) {
       4,
       f
     );
+  }  f = message.getProgress();
+  if (f != null) {
+    writer.writeMessage(
+      5,
+      f,
+      proto.objsh.Progress.serializeBinaryToWriter
+    );
+  }
+  f = message.getChunk();
+  if (f != null) {
+    writer.writeMessage(
+      6,
+      f,
+      proto.objsh.Chunk.serializeBinaryToWriter
+    );
   }
 };
 
@@ -1250,6 +1381,666 @@ proto.objsh.Result.prototype.setStderr = function(value) {
 };
 
 
+/**
+ * optional Progress progress = 5;
+ * @return {?proto.objsh.Progress}
+ */
+proto.objsh.Result.prototype.getProgress = function() {
+  return /** @type{?proto.objsh.Progress} */ (
+    jspb.Message.getWrapperField(this, proto.objsh.Progress, 5));
+};
+
+
+/** @param {?proto.objsh.Progress|undefined} value */
+proto.objsh.Result.prototype.setProgress = function(value) {
+  jspb.Message.setWrapperField(this, 5, value);
+};
+
+
+/**
+ * Clears the message field making it undefined.
+ */
+proto.objsh.Result.prototype.clearProgress = function() {
+  this.setProgress(undefined);
+};
+
+
+/**
+ * Returns whether this field is set.
+ * @return {boolean}
+ */
+proto.objsh.Result.prototype.hasProgress = function() {
+  return jspb.Message.getField(this, 5) != null;
+};
+
+
+/**
+ * optional Chunk chunk = 6;
+ * @return {?proto.objsh.Chunk}
+ */
+proto.objsh.Result.prototype.getChunk = function() {
+  return /** @type{?proto.objsh.Chunk} */ (
+    jspb.Message.getWrapperField(this, proto.objsh.Chunk, 6));
+};
+
+
+/** @param {?proto.objsh.Chunk|undefined} value */
+proto.objsh.Result.prototype.setChunk = function(value) {
+  jspb.Message.setWrapperField(this, 6, value);
+};
+
+
+/**
+ * Clears the message field making it undefined.
+ */
+proto.objsh.Result.prototype.clearChunk = function() {
+  this.setChunk(undefined);
+};
+
+
+/**
+ * Returns whether this field is set.
+ * @return {boolean}
+ */
+proto.objsh.Result.prototype.hasChunk = function() {
+  return jspb.Message.getField(this, 6) != null;
+};
+
+
+
+
+
+if (jspb.Message.GENERATE_TO_OBJECT) {
+/**
+ * Creates an object representation of this proto suitable for use in Soy templates.
+ * Field names that are reserved in JavaScript and will be renamed to pb_name.
+ * To access a reserved field use, foo.pb_<name>, eg, foo.pb_default.
+ * For the list of reserved names please see:
+ *     net/proto2/compiler/js/internal/generator.cc#kKeyword.
+ * @param {boolean=} opt_includeInstance Deprecated. whether to include the
+ *     JSPB instance for transitional soy proto support:
+ *     http://goto/soy-param-migration
+ * @return {!Object}
+ */
+proto.objsh.Progress.prototype.toObject = function(opt_includeInstance) {
+  return proto.objsh.Progress.toObject(opt_includeInstance, this);
+};
+
+
+/**
+ * Static version of the {@see toObject} method.
+ * @param {boolean|undefined} includeInstance Deprecated. Whether to include
+ *     the JSPB instance for transitional soy proto support:
+ *     http://goto/soy-param-migration
+ * @param {!proto.objsh.Progress} msg The msg instance to transform.
+ * @return {!Object}
+ * @suppress {unusedLocalVariables} f is only used for nested messages
+ */
+proto.objsh.Progress.toObject = function(includeInstance, msg) {
+  var f, obj = {
+    percent: +jspb.Message.getFloatingPointFieldWithDefault(msg, 1, 0.0),
+    step: jspb.Message.getFieldWithDefault(msg, 2, 0),
+    totalSteps: jspb.Message.getFieldWithDefault(msg, 3, 0),
+    message: jspb.Message.getFieldWithDefault(msg, 4, ""),
+    eta: jspb.Message.getFieldWithDefault(msg, 5, 0)
+  };
+
+  if (includeInstance) {
+    obj.$jspbMessageInstance = msg;
+  }
+  return obj;
+};
+}
+
+
+/**
+ * Deserializes binary data (in protobuf wire format).
+ * @param {jspb.ByteSource} bytes The bytes to deserialize.
+ * @return {!proto.objsh.Progress}
+ */
+proto.objsh.Progress.deserializeBinary = function(bytes) {
+  var reader = new jspb.BinaryReader(bytes);
+  var msg = new proto.objsh.Progress;
+  return proto.objsh.Progress.deserializeBinaryFromReader(msg, reader);
+};
+
+
+/**
+ * Deserializes binary data (in protobuf wire format) from the
+ * given reader into the given message object.
+ * @param {!proto.objsh.Progress} msg The message object to deserialize into.
+ * @param {!jspb.BinaryReader} reader The BinaryReader to use.
+ * @return {!proto.objsh.Progress}
+ */
+proto.objsh.Progress.deserializeBinaryFromReader = function(msg, reader) {
+  while (reader.nextField()) {
+    if (reader.isEndGroup()) {
+      break;
+    }
+    var field = reader.getFieldNumber();
+    switch (field) {
+    case 1:
+      var value = /** @type {number} */ (reader.readFloat());
+      msg.setPercent(value);
+      break;
+    case 2:
+      var value = /** @type {number} */ (reader.readInt32());
+      msg.setStep(value);
+      break;
+    case 3:
+      var value = /** @type {number} */ (reader.readInt32());
+      msg.setTotalSteps(value);
+      break;
+    case 4:
+      var value = /** @type {string} */ (reader.readString());
+      msg.setMessage(value);
+      break;
+    case 5:
+      var value = /** @type {number} */ (reader.readInt32());
+      msg.setEta(value);
+      break;
+    default:
+      reader.skipField();
+      break;
+    }
+  }
+  return msg;
+};
+
+
+/**
+ * Serializes the message to binary data (in protobuf wire format).
+ * @return {!Uint8Array}
+ */
+proto.objsh.Progress.prototype.serializeBinary = function() {
+  var writer = new jspb.BinaryWriter();
+  proto.objsh.Progress.serializeBinaryToWriter(this, writer);
+  return writer.getResultBuffer();
+};
+
+
+/**
+ * Serializes the given message to binary data (in protobuf wire
+ * format), writing to the given BinaryWriter.
+ * @param {!proto.objsh.Progress} message
+ * @param {!jspb.BinaryWriter} writer
+ * @suppress {unusedLocalVariables} f is only used for nested messages
+ */
+proto.objsh.Progress.serializeBinaryToWriter = function(message, writer) {
+  var f = undefined;
+  f = message.getPercent();
+  if (f !== 0.0) {
+    writer.writeFloat(
+      1,
+      f
+    );
+  }
+  f = message.getStep();
+  if (f !== 0) {
+    writer.writeInt32(
+      2,
+      f
+    );
+  }
+  f = message.getTotalSteps();
+  if (f !== 0) {
+    writer.writeInt32(
+      3,
+      f
+    );
+  }
+  f = message.getMessage();
+  if (f.length > 0) {
+    writer.writeString(
+      4,
+      f
+    );
+  }
+  f = message.getEta();
+  if (f !== 0) {
+    writer.writeInt32(
+      5,
+      f
+    );
+  }
+};
+
+
+/**
+ * optional float percent = 1;
+ * @return {number}
+ */
+proto.objsh.Progress.prototype.getPercent = function() {
+  return /** @type {number} */ (+jspb.Message.getFloatingPointFieldWithDefault(this, 1, 0.0));
+};
+
+
+/** @param {number} value */
+proto.objsh.Progress.prototype.setPercent = function(value) {
+  jspb.Message.setProto3FloatField(this, 1, value);
+};
+
+
+/**
+ * optional int32 step = 2;
+ * @return {number}
+ */
+proto.objsh.Progress.prototype.getStep = function() {
+  return /** @type {number} */ (jspb.Message.getFieldWithDefault(this, 2, 0));
+};
+
+
+/** @param {number} value */
+proto.objsh.Progress.prototype.setStep = function(value) {
+  jspb.Message.setProto3IntField(this, 2, value);
+};
+
+
+/**
+ * optional int32 total_steps = 3;
+ * @return {number}
+ */
+proto.objsh.Progress.prototype.getTotalSteps = function() {
+  return /** @type {number} */ (jspb.Message.getFieldWithDefault(this, 3, 0));
+};
+
+
+/** @param {number} value */
+proto.objsh.Progress.prototype.setTotalSteps = function(value) {
+  jspb.Message.setProto3IntField(this, 3, value);
+};
+
+
+/**
+ * optional string message = 4;
+ * @return {string}
+ */
+proto.objsh.Progress.prototype.getMessage = function() {
+  return /** @type {string} */ (jspb.Message.getFieldWithDefault(this, 4, ""));
+};
+
+
+/** @param {string} value */
+proto.objsh.Progress.prototype.setMessage = function(value) {
+  jspb.Message.setProto3StringField(this, 4, value);
+};
+
+
+/**
+ * optional int32 eta = 5;
+ * @return {number}
+ */
+proto.objsh.Progress.prototype.getEta = function() {
+  return /** @type {number} */ (jspb.Message.getFieldWithDefault(this, 5, 0));
+};
+
+
+/** @param {number} value */
+proto.objsh.Progress.prototype.setEta = function(value) {
+  jspb.Message.setProto3IntField(this, 5, value);
+};
+
+
+/**
+ * Generated by JsPbCodeGenerator.
+ * @param {Array=} opt_data Optional initial data array, typically from a
+ * server response, or constructed directly in Javascript. The array is used
+ * in place and becomes part of the constructed object. It is not cloned.
+ * If no data is provided, the constructed object will be empty, but still
+ * valid.
+ * @extends {jspb.Message}
+ * @constructor
+ */
+proto.objsh.Chunk = function(opt_data) {
+  jspb.Message.initialize(this, opt_data, 0, -1, null, null);
+};
+goog.inherits(proto.objsh.Chunk, jspb.Message);
+if (goog.DEBUG && !COMPILED) {
+  /**
+   * @public
+   * @override
+   */
+  proto.objsh.Chunk.displayName = 'proto.objsh.Chunk';
+}
+
+
+
+if (jspb.Message.GENERATE_TO_OBJECT) {
+/**
+ * Creates an object representation of this proto suitable for use in Soy templates.
+ * Field names that are reserved in JavaScript and will be renamed to pb_name.
+ * To access a reserved field use, foo.pb_<name>, eg, foo.pb_default.
+ * For the list of reserved names please see:
+ *     net/proto2/compiler/js/internal/generator.cc#kKeyword.
+ * @param {boolean=} opt_includeInstance Deprecated. whether to include the
+ *     JSPB instance for transitional soy proto support:
+ *     http://goto/soy-param-migration
+ * @return {!Object}
+ */
+proto.objsh.Chunk.prototype.toObject = function(opt_includeInstance) {
+  return proto.objsh.Chunk.toObject(opt_includeInstance, this);
+};
+
+
+/**
+ * Static version of the {@see toObject} method.
+ * @param {boolean|undefined} includeInstance Deprecated. Whether to include
+ *     the JSPB instance for transitional soy proto support:
+ *     http://goto/soy-param-migration
+ * @param {!proto.objsh.Chunk} msg The msg instance to transform.
+ * @return {!Object}
+ * @suppress {unusedLocalVariables} f is only used for nested messages
+ */
+proto.objsh.Chunk.toObject = function(includeInstance, msg) {
+  var f, obj = {
+    id: jspb.Message.getFieldWithDefault(msg, 1, 0),
+    offset: jspb.Message.getFieldWithDefault(msg, 2, 0),
+    data: msg.getData_asB64(),
+    eof: jspb.Message.getBooleanFieldWithDefault(msg, 4, false),
+    limit: jspb.Message.getFieldWithDefault(msg, 5, 0),
+    abort: jspb.Message.getBooleanFieldWithDefault(msg, 6, false),
+    contentType: jspb.Message.getFieldWithDefault(msg, 7, ""),
+    size: jspb.Message.getFieldWithDefault(msg, 8, 0)
+  };
+
+  if (includeInstance) {
+    obj.$jspbMessageInstance = msg;
+  }
+  return obj;
+};
+}
+
+
+/**
+ * Deserializes binary data (in protobuf wire format).
+ * @param {jspb.ByteSource} bytes The bytes to deserialize.
+ * @return {!proto.objsh.Chunk}
+ */
+proto.objsh.Chunk.deserializeBinary = function(bytes) {
+  var reader = new jspb.BinaryReader(bytes);
+  var msg = new proto.objsh.Chunk;
+  return proto.objsh.Chunk.deserializeBinaryFromReader(msg, reader);
+};
+
+
+/**
+ * Deserializes binary data (in protobuf wire format) from the
+ * given reader into the given message object.
+ * @param {!proto.objsh.Chunk} msg The message object to deserialize into.
+ * @param {!jspb.BinaryReader} reader The BinaryReader to use.
+ * @return {!proto.objsh.Chunk}
+ */
+proto.objsh.Chunk.deserializeBinaryFromReader = function(msg, reader) {
+  while (reader.nextField()) {
+    if (reader.isEndGroup()) {
+      break;
+    }
+    var field = reader.getFieldNumber();
+    switch (field) {
+    case 1:
+      var value = /** @type {number} */ (reader.readInt32());
+      msg.setId(value);
+      break;
+    case 2:
+      var value = /** @type {number} */ (reader.readInt64());
+      msg.setOffset(value);
+      break;
+    case 3:
+      var value = /** @type {!Uint8Array} */ (reader.readBytes());
+      msg.setData(value);
+      break;
+    case 4:
+      var value = /** @type {boolean} */ (reader.readBool());
+      msg.setEof(value);
+      break;
+    case 5:
+      var value = /** @type {number} */ (reader.readInt64());
+      msg.setLimit(value);
+      break;
+    case 6:
+      var value = /** @type {boolean} */ (reader.readBool());
+      msg.setAbort(value);
+      break;
+    case 7:
+      var value = /** @type {string} */ (reader.readString());
+      msg.setContentType(value);
+      break;
+    case 8:
+      var value = /** @type {number} */ (reader.readInt64());
+      msg.setSize(value);
+      break;
+    default:
+      reader.skipField();
+      break;
+    }
+  }
+  return msg;
+};
+
+
+/**
+ * Serializes the message to binary data (in protobuf wire format).
+ * @return {!Uint8Array}
+ */
+proto.objsh.Chunk.prototype.serializeBinary = function() {
+  var writer = new jspb.BinaryWriter();
+  proto.objsh.Chunk.serializeBinaryToWriter(this, writer);
+  return writer.getResultBuffer();
+};
+
+
+/**
+ * Serializes the given message to binary data (in protobuf wire
+ * format), writing to the given BinaryWriter.
+ * @param {!proto.objsh.Chunk} message
+ * @param {!jspb.BinaryWriter} writer
+ * @suppress {unusedLocalVariables} f is only used for nested messages
+ */
+proto.objsh.Chunk.serializeBinaryToWriter = function(message, writer) {
+  var f = undefined;
+  f = message.getId();
+  if (f !== 0) {
+    writer.writeInt32(
+      1,
+      f
+    );
+  }
+  f = message.getOffset();
+  if (f !== 0) {
+    writer.writeInt64(
+      2,
+      f
+    );
+  }
+  f = message.getData_asU8();
+  if (f.length > 0) {
+    writer.writeBytes(
+      3,
+      f
+    );
+  }
+  f = message.getEof();
+  if (f) {
+    writer.writeBool(
+      4,
+      f
+    );
+  }
+  f = message.getLimit();
+  if (f !== 0) {
+    writer.writeInt64(
+      5,
+      f
+    );
+  }
+  f = message.getAbort();
+  if (f) {
+    writer.writeBool(
+      6,
+      f
+    );
+  }
+  f = message.getContentType();
+  if (f.length > 0) {
+    writer.writeString(
+      7,
+      f
+    );
+  }
+  f = message.getSize();
+  if (f !== 0) {
+    writer.writeInt64(
+      8,
+      f
+    );
+  }
+};
+
+
+/**
+ * optional int32 id = 1;
+ * @return {number}
+ */
+proto.objsh.Chunk.prototype.getId = function() {
+  return /** @type {number} */ (jspb.Message.getFieldWithDefault(this, 1, 0));
+};
+
+
+/** @param {number} value */
+proto.objsh.Chunk.prototype.setId = function(value) {
+  jspb.Message.setProto3IntField(this, 1, value);
+};
+
+
+/**
+ * optional int64 offset = 2;
+ * @return {number}
+ */
+proto.objsh.Chunk.prototype.getOffset = function() {
+  return /** @type {number} */ (jspb.Message.getFieldWithDefault(this, 2, 0));
+};
+
+
+/** @param {number} value */
+proto.objsh.Chunk.prototype.setOffset = function(value) {
+  jspb.Message.setProto3IntField(this, 2, value);
+};
+
+
+/**
+ * optional bytes data = 3;
+ * @return {!(string|Uint8Array)}
+ */
+proto.objsh.Chunk.prototype.getData = function() {
+  return /** @type {!(string|Uint8Array)} */ (jspb.Message.getFieldWithDefault(this, 3, ""));
+};
+
+
+/**
+ * optional bytes data = 3;
+ * This is a type-conversion wrapper around `getData()`
+ * @return {string}
+ */
+proto.objsh.Chunk.prototype.getData_asB64 = function() {
+  return /** @type {string} */ (jspb.Message.bytesAsB64(
+      this.getData()));
+};
+
+
+/**
+ * optional bytes data = 3;
+ * Note that Uint8Array is not supported on all browsers.
+ * @see http://caniuse.com/Uint8Array
+ * This is a type-conversion wrapper around `getData()`
+ * @return {!Uint8Array}
+ */
+proto.objsh.Chunk.prototype.getData_asU8 = function() {
+  return /** @type {!Uint8Array} */ (jspb.Message.bytesAsU8(
+      this.getData()));
+};
+
+
+/** @param {!(string|Uint8Array)} value */
+proto.objsh.Chunk.prototype.setData = function(value) {
+  jspb.Message.setProto3BytesField(this, 3, value);
+};
+
+
+/**
+ * optional bool eof = 4;
+ * @return {boolean}
+ */
+proto.objsh.Chunk.prototype.getEof = function() {
+  return /** @type {boolean} */ (jspb.Message.getBooleanFieldWithDefault(this, 4, false));
+};
+
+
+/** @param {boolean} value */
+proto.objsh.Chunk.prototype.setEof = function(value) {
+  jspb.Message.setProto3BooleanField(this, 4, value);
+};
+
+
+/**
+ * optional int64 limit = 5;
+ * @return {number}
+ */
+proto.objsh.Chunk.prototype.getLimit = function() {
+  return /** @type {number} */ (jspb.Message.getFieldWithDefault(this, 5, 0));
+};
+
+
+/** @param {number} value */
+proto.objsh.Chunk.prototype.setLimit = function(value) {
+  jspb.Message.setProto3IntField(this, 5, value);
+};
+
+
+/**
+ * optional bool abort = 6;
+ * @return {boolean}
+ */
+proto.objsh.Chunk.prototype.getAbort = function() {
+  return /** @type {boolean} */ (jspb.Message.getBooleanFieldWithDefault(this, 6, false));
+};
+
+
+/** @param {boolean} value */
+proto.objsh.Chunk.prototype.setAbort = function(value) {
+  jspb.Message.setProto3BooleanField(this, 6, value);
+};
+
+
+/**
+ * optional string content_type = 7;
+ * @return {string}
+ */
+proto.objsh.Chunk.prototype.getContentType = function() {
+  return /** @type {string} */ (jspb.Message.getFieldWithDefault(this, 7, ""));
+};
+
+
+/** @param {string} value */
+proto.objsh.Chunk.prototype.setContentType = function(value) {
+  jspb.Message.setProto3StringField(this, 7, value);
+};
+
+
+/**
+ * optional int64 size = 8;
+ * @return {number}
+ */
+proto.objsh.Chunk.prototype.getSize = function() {
+  return /** @type {number} */ (jspb.Message.getFieldWithDefault(this, 8, 0));
+};
+
+
+/** @param {number} value */
+proto.objsh.Chunk.prototype.setSize = function(value) {
+  jspb.Message.setProto3IntField(this, 8, value);
+};
+
+
 goog.object.extend(exports, proto.objsh);
 
 },{"google-protobuf":1,"google-protobuf/google/protobuf/Any_pb.js":2}],4:[function(require,module,exports){
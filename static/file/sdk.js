@@ -397,6 +397,10 @@ ObjshSDK.ProtobufMessage.prototype = {
 
 ObjshSDK.Deferred = function(){
     this.progressListener = []
+    this.reportListener = [] //structured progress
+    this.uploadListener = [] //bytes sent by upload()
+    this.streamListener = [] //binary streams sent by server
+    this.streamingListener = [] //bytes received of a binary stream
     this.doneListener = []
     this.failListener = []
     this.thenListener = [] //notify and done
@@ -409,6 +413,28 @@ ObjshSDK.Deferred.prototype = {
         this.progressListener.push(callback)
         return this
     }
+    ,report:function(callback){
+        // callback is called with {percent, step, totalSteps, message, eta},
+        // percent and eta (seconds) are -1 if unknown
+        this.reportListener.push(callback)
+        return this
+    }
+    ,uploading:function(callback){
+        // callback is called with (sent, total) in bytes, see Tree.upload()
+        this.uploadListener.push(callback)
+        return this
+    }
+    ,stream:function(callback){
+        // callback is called with (blob, contentType) when a binary stream is received,
+        // it is sent by ctx.StreamWriter() at server side
+        this.streamListener.push(callback)
+        return this
+    }
+    ,streaming:function(callback){
+        // callback is called with (received, size) in bytes, size is -1 if unknown
+        this.streamingListener.push(callback)
+        return this
+    }
     ,done: function(callback){
         if (this.resolved != undefined){
             this.fire([callback],this.resolved)
@@ -433,6 +459,18 @@ ObjshSDK.Deferred.prototype = {
         this.fire(this.thenListener, arguments)
         this.fire(this.progressListener, arguments)
     }
+    ,progressReport:function(){
+        this.fire(this.reportListener, arguments)
+    }
+    ,uploadReport:function(){
+        this.fire(this.uploadListener, arguments)
+    }
+    ,streamReport:function(){
+        this.fire(this.streamingListener, arguments)
+    }
+    ,streamDone:function(){
+        this.fire(this.streamListener, arguments)
+    }
     ,resolve: function(){
         this.resolved = arguments
         this.fire(this.doneListener, arguments)
@@ -450,6 +488,17 @@ ObjshSDK.Deferred.prototype = {
     ,kill:function(){
         if (this.killer) return this.killer()
     }
+    ,pause:function(){
+        // the task should be pausable at server side
+        if (this.pauser) return this.pauser(true)
+    }
+    ,resume:function(){
+        if (this.pauser) return this.pauser(false)
+    }
+    ,abort:function(){
+        // stops sending the blob of upload(), the reader at server side gets an error
+        if (this.aborter) return this.aborter()
+    }
 }
   
 ObjshSDK.Tree = function (sdk, url,treeName,packageName){
@@ -466,12 +515,22 @@ ObjshSDK.Tree = function (sdk, url,treeName,packageName){
     this.protobuf.lazy = true
     this.nodes = {}
     this.queue = {}
+    this.topics = {} //topic:[callback], see subscribe()
+    // if true, calls survive a reconnection by reconnect() within the grace period of server
+    this.resumable = true
+    this.session = null //session id given by server, see reconnect()
     this.utf8Decoder = new TextDecoder("utf-8")
     if (url) this.connect(url)
 }
+// bytes of a Chunk sent by upload()
+ObjshSDK.Tree.ChunkSize = 64 * 1024
 ObjshSDK.Tree.prototype = {
     connect:function(url){
         var self = this
+        this.url = url
+        if (this.resumable){
+            url += (url.indexOf('?') == -1 ? '?' : '&') + 'resume=' + (this.session ? encodeURIComponent(this.session) : '')
+        }
         //initailly, request tree's layout
         //url += (url.indexOf('?') == -1 ? '?' : '&') + 'layout=0' 
         this.protobuf.connect(url)
@@ -480,6 +539,15 @@ ObjshSDK.Tree.prototype = {
         this.protobuf.onerror=function(e){self.onerror(e)}
         this.protobuf.onmessage = function(message){
             var id = message.value.getId()
+            if (message.typeName == 'Chunk'){
+                //server grants to send the blob of upload() until limit
+                var data = self.queue[id]
+                if (data && data.upload){
+                    data.upload.limit = message.value.getLimit()
+                    self._sendChunks(id)
+                }
+                return
+            }
             /*
             if (id==0){
                 //layout
@@ -514,21 +582,54 @@ ObjshSDK.Tree.prototype = {
                         data.deferred.background = true
                     case -1:
                         // progress result
+                        if (message.value.hasChunk()){
+                            self._receiveChunk(data, message.value.getChunk())
+                        }
+                        if (message.value.hasProgress()){
+                            data.deferred.progressReport(message.value.getProgress().toObject())
+                        }
                         var stdout = self.utf8Decoder.decode(message.value.getStdout_asU8());
-                        data.deferred.notify(JSON.parse(stdout))
+                        if (stdout != '') data.deferred.notify(JSON.parse(stdout))
                         break
                     default:
-                        // error result; suppose result is string
-                        //var stderr = message.value['getStderr']();
-                        //data.deferred.reject({code:message.value.getRetcode(),message:stderr})
-                        data.deferred.reject(message.value.getRetcode(),message.value['getStderr']())
+                        // error result; stderr is a JSON of {code, message, details}
+                        // callback of fail() is called with (retcode, message, error)
+                        var stderr = message.value['getStderr']();
+                        var error = {code:message.value.getRetcode(),message:stderr}
+                        try{
+                            error = JSON.parse(stderr)
+                        }catch(e){
+                            // not a structured error
+                        }
+                        data.deferred.reject(message.value.getRetcode(),error.message,error)
                         delete self.queue[id]
                 }
             }
             else{
                 var stdout = self.utf8Decoder.decode(message.value.getStdout_asU8());
                 try{
-                    self.onannouce(JSON.parse(stdout))
+                    var content = JSON.parse(stdout)
+                    if (id == 0 && content && content.topic == '$session'){
+                        //session id for reconnect(), buffered results follow if resumed
+                        if (self.session && !content.payload.resumed){
+                            //calls of the expired session would never be finished
+                            for (var qid in self.queue){
+                                self.queue[qid].deferred.reject(500,'session expired',{code:500,message:'session expired'})
+                            }
+                            self.queue = {}
+                        }
+                        self.session = content.payload.id
+                        self.sdk.fire('tree:session', content.payload)
+                        return
+                    }
+                    if (id == 0 && content && content.topic && self.topics[content.topic]){
+                        //announcement of a subscribed topic
+                        self.topics[content.topic].forEach(function(callback){
+                            callback(content.payload, content)
+                        })
+                        return
+                    }
+                    self.onannouce(content)
                 }catch(e){
                     console.log(e)
                     console.log('[stdout]=',[stdout])
@@ -538,6 +639,12 @@ ObjshSDK.Tree.prototype = {
             }
         }
     }
+    ,reconnect:function(){
+        // connects again, pending calls get their results if the session is resumed,
+        // otherwise they are rejected by server when the session expires
+        if (this.protobuf.ws && this.protobuf.ws.readyState < 2) this.protobuf.ws.close()
+        this.connect(this.url)
+    }
     ,call:function(branchName){
         //Support call styles:
         // call(branchName,[arg])
@@ -602,6 +709,11 @@ ObjshSDK.Tree.prototype = {
         if (pbMsg){
            data.message = pbMsg
         }
+        if (this._callOptions){
+            //set by callWithOptions()
+            if (this._callOptions.timeout) data.timeout = parseInt(this._callOptions.timeout)
+            this._callOptions = null
+        }
         var command = this.protobuf.message('Command',data)
         command.emit()
         
@@ -609,10 +721,95 @@ ObjshSDK.Tree.prototype = {
         deferred.killer = function(){
             return self.kill(this.c)
         }.bind({c:data})
+        deferred.pauser = function(yes){
+            return yes ? self.pause(this.c) : self.resume(this.c)
+        }.bind({c:data})
         
+        deferred.id = data.id
         this.queue[data.id] = {deferred:deferred}
         return deferred
     }
+    ,upload:function(branchName,blob){
+        //Same as call() but with a Blob (or File) as the 2nd argument,
+        //it is read by ctx.Upload() at server side, ex.
+        //  upload(branchName,file,[arg],{k:v}).uploading(function(sent,total){}).done(...)
+        //Blob is sent in slices when server grants, call abort() of the returned deferred to stop
+        var self = this
+        var deferred = this.call.apply(this,[branchName].concat(Array.prototype.slice.call(arguments,2)))
+        if (!deferred) return
+        var id = deferred.id
+        this.queue[id].upload = {blob:blob, offset:0, limit:0, reading:false, done:false}
+        deferred.aborter = function(){
+            var data = self.queue[id]
+            if (!data || !data.upload || data.upload.done) return
+            data.upload.done = true
+            self.protobuf.message('Chunk',{id:id, abort:true}).emit()
+        }
+        return deferred
+    }
+    ,_sendChunks:function(id){
+        // sends slices of blob until the limit granted by server
+        var self = this
+        var data = this.queue[id]
+        if (!data) return
+        var upload = data.upload
+        var size = upload.blob.size
+        if (upload.reading || upload.done || (upload.offset >= upload.limit && size > 0)) return
+        var end = Math.min(size, upload.limit, upload.offset + ObjshSDK.Tree.ChunkSize)
+        upload.reading = true
+        var reader = new FileReader()
+        reader.onload = function(){
+            upload.reading = false
+            //the call might be finished or aborted while reading
+            if (upload.done || !self.queue[id]) return
+            var eof = (end == size)
+            self.protobuf.message('Chunk',{
+                id: id
+                ,offset: upload.offset
+                ,data: new Uint8Array(reader.result)
+                ,eof: eof
+            }).emit()
+            upload.offset = end
+            upload.done = eof
+            data.deferred.uploadReport(end, size)
+            self._sendChunks(id)
+        }
+        reader.onerror = function(){
+            upload.reading = false
+            data.deferred.abort()
+        }
+        reader.readAsArrayBuffer(upload.blob.slice(upload.offset, end))
+    }
+    ,_receiveChunk:function(data,chunk){
+        // reassembles a binary stream, content type and size are given in the first chunk
+        if (!data.stream && chunk.getOffset() == 0){
+            data.stream = {parts:[], received:0, contentType:chunk.getContentType(), size:chunk.getSize()}
+        }
+        var stream = data.stream
+        if (!stream || chunk.getOffset() != stream.received){
+            console.warn('chunk at '+chunk.getOffset()+' is out of order')
+            delete data.stream
+            return
+        }
+        var bytes = chunk.getData_asU8()
+        if (bytes.length){
+            stream.parts.push(bytes)
+            stream.received += bytes.length
+            data.deferred.streamReport(stream.received, stream.size)
+        }
+        if (chunk.getEof()){
+            delete data.stream
+            data.deferred.streamDone(new Blob(stream.parts, {type:stream.contentType}), stream.contentType)
+        }
+    }
+    ,callWithOptions:function(options,branchName){
+        //Same as call() but with options as the 1st argument, options are:
+        //  timeout: deadline of this call in milliseconds,
+        //           the call is rejected with retcode 504 if it is not completed in time
+        //ex. callWithOptions({timeout:5000},branchName,[arg],{k:v})
+        this._callOptions = options
+        return this.call.apply(this,Array.prototype.slice.call(arguments,1))
+    }
     ,hook:function(cmdID,cmdPath){
         //A handy function to call hook and watch in a background task
         if (typeof cmdPath == 'undefined') cmdPath = '$.Hook' //default to $.Hook
@@ -632,6 +829,8 @@ ObjshSDK.Tree.prototype = {
             deferred.done(stdout)
         }).progress(function(stdout){
             deferred.notify(stdout)
+        }).report(function(progress){
+            deferred.progressReport(progress)
         }).fail(function(err){
             deferred.reject(err)
         })
@@ -689,6 +888,35 @@ ObjshSDK.Tree.prototype = {
        this.queue[cmdId] = {deferred:deferred,id:cmdId,name:name}
        return deferred
     }
+    ,subscribe:function(topic,callback){
+        //receives announcements of topic, callback is called with (payload, {topic, payload, ts})
+        var self = this
+        return this.call('$push.Subscribe',[topic]).done(function(){
+            if (!self.topics[topic]) self.topics[topic] = []
+            self.topics[topic].push(callback)
+        })
+    }
+    ,unsubscribe:function(topic){
+        delete this.topics[topic]
+        return this.call('$push.Unsubscribe',[topic])
+    }
+    ,pause:function(command_data){
+        //same as kill(), but pauses the task until resume() is called
+        return this._pauseOrResume(command_data,'pause')
+    }
+    ,resume:function(command_data){
+        return this._pauseOrResume(command_data,'resume')
+    }
+    ,_pauseOrResume:function(command_data,flag){
+        var cmdId = (new String(Math.floor(1000 * (new Date().getTime()+Math.random()))).substr(2)) % 2147483648
+        var data = {id:cmdId, name:new String(command_data.id)}
+        data[flag] = true
+        var command = this.protobuf.message('Command',data)
+        command.emit()
+        var deferred = new ObjshSDK.Deferred()
+        this.queue[cmdId] = {deferred:deferred,id:cmdId,name:command_data.name}
+        return deferred
+    }
 }
 
 
@@ -727,7 +727,7 @@ $(function () {
                 sdk.tree.call('$.ListUserTasks').done(function(response){
                     var records = []
                     response.forEach(function(cmdInfo,i){
-                        //id, cmdpath, username, args, kw, ctime
+                        //id, cmdpath, username, args, kw, ctime, job status, progress
                         var values = cmdInfo.split('\t')
                         values[3] = values[3].replace('&',', ')
                         records.push({
@@ -737,6 +737,8 @@ $(function () {
                             cmdID: values[0],
                             cmdPath: values[1],
                             argsKw:(values[3] || '')+( (values[3] && values[4] )? ', ' : '')+(values[4] || ''),
+                            status: values[6] || '',
+                            progress: values[7] || '',
                         })
                     })
                     if (w2ui['bgtasks-table']) w2ui['bgtasks-table'].destroy()
@@ -759,8 +761,10 @@ $(function () {
                             { field: 'time', caption: 'Timestamp', size:'10%',info: true},
                             { field: 'username', caption: 'User',size:'10%'},
                             { field: 'cmdID', caption: 'ID',size:'10%'},
-                            { field: 'cmdPath', caption: 'Call',size:'30%'},
-                            { field: 'argsKw', caption: 'Args & Kw',size:'40%'},
+                            { field: 'cmdPath', caption: 'Call',size:'25%'},
+                            { field: 'argsKw', caption: 'Args & Kw',size:'20%'},
+                            { field: 'status', caption: 'Status',size:'15%'},
+                            { field: 'progress', caption: 'Progress',size:'15%'},
                         ],
                         records: records,
                         toolbar:{
@@ -788,6 +792,25 @@ $(function () {
                                         }
                                     }
                                 }, 
+                                { type: 'button', id: 'pause-btn', icon: 'fa fa-pause-circle', text: 'Pause', disabled:false,
+                                    tooltip: function (item) {
+                                        return 'Pause or resume this task';
+                                    },
+                                    onClick: function (event) {
+                                        var recid = w2ui['bgtasks-table'].getSelection()
+                                        var records =  w2ui['bgtasks-table'].get(recid)
+                                        if (records.length){
+                                            var paused = records[0].status == 'paused'
+                                            var command_data = {name:records[0].cmdPath, id:records[0].cmdID}
+                                            var deferred = paused ? sdk.tree.resume(command_data) : sdk.tree.pause(command_data)
+                                            deferred.done(function(){
+                                                w2ui['bgtasks-table'].set(records[0].recid, {status: paused ? '' : 'paused'})
+                                            }).fail(function(err){
+                                                w2alert(JSON.stringify(err))
+                                            })
+                                        }
+                                    }
+                                }, 
                                 { type: 'button', id: 'hook-btn', icon: 'fa fa-play-circle', text: 'Hook', disabled:false,
                                     tooltip: function (item) {
                                         return 'Hook up to this task';
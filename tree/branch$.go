@@ -9,6 +9,8 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+
+	model "github.com/iapyeh/fastjob/model"
 )
 
 type DefaultBranch struct {
@@ -27,15 +29,24 @@ func (db *DefaultBranch) BeReady(treeroot *TreeRoot) {
 		db.ListUserTasks,
 		db.Hook,
 		db.Unhook,
+		db.Metrics,
+		db.Limits,
+		db.Jobs,
 	)
 	treeroot.SureReady(db)
 }
 
+// Layout returns branches (at every level) and their exported API which are accessible by the caller.
+// A branch is hidden if the ACL mode of it or of any parent is not met (see BaseBranch.SetACL),
+// ex. a ProtectMode branch is hidden from guests, a TraceMode branch from guests who are not traced.
 func (db *DefaultBranch) Layout(callCtx *TreeCallCtx) {
 	layout := db.treeRoot.Layout()
 	ret := make(map[string](map[string](map[string]string)))
 	rootName := db.treeRoot.Name
 	for branchName, exportableNames := range layout {
+		if !db.treeRoot.Accessible(branchName, callCtx.WsCtx) {
+			continue
+		}
 		key := rootName + "." + branchName
 		ret[key] = make(map[string](map[string]string))
 		for _, apiinfo := range exportableNames {
@@ -82,7 +93,8 @@ func (db *DefaultBranch) ListUserTasks(tcCtx *TreeCallCtx) {
 	if tcCtxs, err := db.treeRoot.Bank.ListUser(user); err == nil {
 		ret := make([]string, len(tcCtxs))
 		for i, tcCtx := range tcCtxs {
-			ret[i] = fmt.Sprintf("%v\t%v\t%v\t%v\t%v", tcCtx.CmdID, tcCtx.CmdPath, strings.Join(tcCtx.Args, ", "), tcCtx.Kw.String(), tcCtx.Ctime)
+			// id, cmdpath, username, args, kw, ctime, job status, progress
+			ret[i] = fmt.Sprintf("%v\t%v\t%v\t%v\t%v\t%v\t%v", tcCtx.CmdID, tcCtx.CmdPath, strings.Join(tcCtx.Args, ", "), tcCtx.Kw.String(), tcCtx.Ctime, tcCtx.JobStatus(), model.FormatProgress(tcCtx.LastProgress()))
 		}
 		tcCtx.Resolve(ret)
 	} else {
@@ -90,6 +102,21 @@ func (db *DefaultBranch) ListUserTasks(tcCtx *TreeCallCtx) {
 	}
 }
 
+//Metrics returns system-wide counters, ex. {"treecall.panic": 1}
+func (db *DefaultBranch) Metrics(tcCtx *TreeCallCtx) {
+	tcCtx.Resolve(model.Metrics.Snapshot())
+}
+
+//Limits returns concurrency limits of calls and their usages (including the caller's)
+func (db *DefaultBranch) Limits(tcCtx *TreeCallCtx) {
+	tcCtx.Resolve(db.treeRoot.Limiter.Snapshot(tcCtx.WsCtx.GetUser()))
+}
+
+//Jobs returns workers, running and queued jobs of every worker pool
+func (db *DefaultBranch) Jobs(tcCtx *TreeCallCtx) {
+	tcCtx.Resolve(db.treeRoot.Scheduler.Snapshot())
+}
+
 //Hook is called by Playground to hook-up output of background tasks if any.
 func (db *DefaultBranch) Hook(tcCtx *TreeCallCtx) {
 	user := tcCtx.WsCtx.GetUser()
@@ -0,0 +1,81 @@
+package tree
+
+import (
+	"errors"
+	"strconv"
+
+	model "github.com/iapyeh/fastjob/model"
+)
+
+// DeadLetterBranch lets administrators inspect and re-run background calls
+// which are failed permanently (TreeRoot.Retry). See model.AdminChecker.
+type DeadLetterBranch struct {
+	BaseBranch
+	treeRoot *TreeRoot
+}
+
+func (dl *DeadLetterBranch) BeReady(treeroot *TreeRoot) {
+	dl.treeRoot = treeroot
+	dl.SetName("$deadletter")
+	dl.InitBaseBranch()
+	dl.Use(model.RequireAdmin)
+	dl.Export(
+		dl.List,
+		dl.Rerun,
+		dl.Delete,
+	)
+	treeroot.SureReady(dl)
+}
+
+// idOf returns the id of dead letter in Args[0], otherwise ctx is rejected
+func (dl *DeadLetterBranch) idOf(ctx *TreeCallCtx) (int64, bool) {
+	if len(ctx.Args) < 1 {
+		ctx.Reject(model.RetcodeBadRequest, errors.New("id is missing"))
+		return 0, false
+	}
+	id, err := strconv.ParseInt(ctx.Args[0], 10, 64)
+	if err != nil {
+		ctx.Reject(model.RetcodeBadRequest, err)
+		return 0, false
+	}
+	return id, true
+}
+
+/*
+# $deadletter.List
+Returns failed background calls, oldest first
+*/
+func (dl *DeadLetterBranch) List(ctx *TreeCallCtx) {
+	ctx.Resolve(dl.treeRoot.Retry.DeadLetters())
+}
+
+/*
+# $deadletter.Rerun
+Calls a dead letter again as its user in background, returns CmdID of the new call,
+which could be hooked by $.Hook
+    Args:[id*]
+*/
+func (dl *DeadLetterBranch) Rerun(ctx *TreeCallCtx) {
+	if id, ok := dl.idOf(ctx); ok {
+		callCtx, err := dl.treeRoot.Retry.Rerun(id)
+		if err != nil {
+			ctx.Reject(model.RetcodeNotFound, err)
+			return
+		}
+		ctx.Resolve(callCtx.CmdID)
+	}
+}
+
+/*
+# $deadletter.Delete
+    Args:[id*]
+*/
+func (dl *DeadLetterBranch) Delete(ctx *TreeCallCtx) {
+	if id, ok := dl.idOf(ctx); ok {
+		if err := dl.treeRoot.Retry.Delete(id); err != nil {
+			ctx.Reject(model.RetcodeNotFound, err)
+			return
+		}
+		ctx.Resolve(1)
+	}
+}
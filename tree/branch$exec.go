@@ -7,7 +7,10 @@ import (
 	"io/ioutil"
 	"os/exec"
 	"sync"
+	"time"
 	"unicode/utf8"
+
+	model "github.com/iapyeh/fastjob/model"
 )
 
 // ChatBranch would connect to tree
@@ -98,22 +101,26 @@ func (sb *ExecBranch) BackgroundCommand(ctx *TreeCallCtx) {
 	cmd.Start()
 
 	ctx.SetBackground(true)
+	// output is sent at most 5 times per second
+	ctx.SetNotifyPolicy(&model.NotifyPolicy{Interval: 200 * time.Millisecond, Mode: model.NotifyBatch, Merge: model.MergeStrings})
 
 	var mutex sync.Mutex
 	stop := make(chan bool)
 	outC := make(chan []byte)
 	// copy the output in a separate goroutine so printing can't block indefinitely
 	go func() {
-		oneRune := make([]byte, utf8.UTFMax)
+		chunk := make([]byte, 4096)
 		for {
 			mutex.Lock()
-			count, err := stdout.Read(oneRune)
+			count, err := stdout.Read(chunk)
 			mutex.Unlock()
+			if count > 0 {
+				outC <- append([]byte(nil), chunk[:count]...)
+			}
 			if err != nil {
 				fmt.Println("error read stdout", err)
 				break
 			}
-			outC <- oneRune[:count]
 		}
 		fmt.Println("---stop--")
 		stop <- true
@@ -125,27 +132,43 @@ func (sb *ExecBranch) BackgroundCommand(ctx *TreeCallCtx) {
 		stop <- true
 	})
 
+	// a rune might be split by reads, it is kept until the rest arrives
 	var buf bytes.Buffer
 loop:
 	for {
-		//newLine := []byte("\n")
 		select {
 		case s := <-outC:
 			mutex.Lock()
 			buf.Write(s)
-			if buf.Len() > 1024 {
-				ctx.Notify(buf.String())
-				fmt.Println(buf.String())
-				buf.Reset()
+			if n := completeRunes(buf.Bytes()); n > 0 {
+				ctx.Notify(string(buf.Next(n)))
 			}
 			mutex.Unlock()
 		case <-stop:
 			break loop
 		}
 	}
+	if buf.Len() > 0 {
+		ctx.Notify(buf.String())
+	}
 	cmd.Wait()
 	fmt.Println("---bye--")
 	ctx.Resolve(1)
 	return
 
 }
+
+// completeRunes returns length of b without an incomplete rune at the end
+func completeRunes(b []byte) int {
+	n := len(b)
+	for i := 1; i < utf8.UTFMax && i <= n; i++ {
+		if !utf8.RuneStart(b[n-i]) {
+			continue
+		}
+		if !utf8.FullRune(b[n-i:]) {
+			return n - i
+		}
+		break
+	}
+	return n
+}
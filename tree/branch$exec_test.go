@@ -0,0 +1,29 @@
+package tree
+
+import "testing"
+
+func TestCompleteRunes(t *testing.T) {
+	s := "a中😀"
+	cases := []struct {
+		b      []byte
+		expect int
+	}{
+		{nil, 0},
+		{[]byte("abc"), 3},
+		{[]byte(s), len(s)},
+		{[]byte(s[:2]), 1},
+		{[]byte(s[:3]), 1},
+		{[]byte(s[:4]), 4},
+		{[]byte(s[:5]), 4},
+		{[]byte(s[:7]), 4},
+		{[]byte(s[1:2]), 0},
+		// invalid bytes are not kept
+		{[]byte{'a', 0xff}, 2},
+		{[]byte{0x80, 0x80, 0x80, 0x80}, 4},
+	}
+	for i, c := range cases {
+		if n := completeRunes(c.b); n != c.expect {
+			t.Errorf("case %d: expect %d, got %d", i, c.expect, n)
+		}
+	}
+}
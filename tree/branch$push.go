@@ -0,0 +1,118 @@
+package tree
+
+import (
+	"encoding/json"
+	"errors"
+
+	model "github.com/iapyeh/fastjob/model"
+)
+
+// PushBranch lets browsers subscribe to topics of announcements (TreeRoot.Push),
+// and administrators send announcements.
+type PushBranch struct {
+	BaseBranch
+	treeRoot *TreeRoot
+}
+
+func (pb *PushBranch) BeReady(treeroot *TreeRoot) {
+	pb.treeRoot = treeroot
+	pb.SetName("$push")
+	pb.InitBaseBranch()
+	pb.Export(
+		pb.Subscribe,
+		pb.Unsubscribe,
+		pb.Topics,
+		pb.Send,
+	)
+	treeroot.SureReady(pb)
+}
+
+/*
+# $push.Subscribe
+Receives announcements of topics by this connection
+    Args:[
+        topic*,
+        topic1,
+    ]
+*/
+func (pb *PushBranch) Subscribe(ctx *TreeCallCtx) {
+	if len(ctx.Args) < 1 {
+		ctx.Reject(model.RetcodeBadRequest, errors.New("topic is missing"))
+		return
+	}
+	if err := pb.treeRoot.Push.Subscribe(ctx.WsCtx, ctx.Args...); err != nil {
+		ctx.Reject(model.RetcodeBadRequest, err)
+		return
+	}
+	ctx.Resolve(pb.treeRoot.Push.Topics(ctx.WsCtx))
+}
+
+/*
+# $push.Unsubscribe
+    Args:[
+        topic*,
+        topic1,
+    ]
+*/
+func (pb *PushBranch) Unsubscribe(ctx *TreeCallCtx) {
+	if len(ctx.Args) < 1 {
+		ctx.Reject(model.RetcodeBadRequest, errors.New("topic is missing"))
+		return
+	}
+	if err := pb.treeRoot.Push.Unsubscribe(ctx.WsCtx, ctx.Args...); err != nil {
+		ctx.Reject(model.RetcodeBadRequest, err)
+		return
+	}
+	ctx.Resolve(pb.treeRoot.Push.Topics(ctx.WsCtx))
+}
+
+/*
+# $push.Topics
+Returns topics subscribed by this connection
+*/
+func (pb *PushBranch) Topics(ctx *TreeCallCtx) {
+	ctx.Resolve(pb.treeRoot.Push.Topics(ctx.WsCtx))
+}
+
+/*
+# $push.Send
+Sends an announcement, admin only. Returns the number of connections sent to.
+
+    Args:[
+        target*: user, group or all, (* = required)
+        name*: username or group name, ignored for all,
+        topic*: "" for all connections of target,
+        payload: JSON,
+    ]
+*/
+func (pb *PushBranch) Send(ctx *TreeCallCtx) {
+	// other exports are open to everyone, so the middleware is applied to this one only
+	model.RequireAdmin(ctx, func() {
+		pb.send(ctx)
+	})
+}
+
+func (pb *PushBranch) send(ctx *TreeCallCtx) {
+	if len(ctx.Args) < 3 {
+		ctx.Reject(model.RetcodeBadRequest, errors.New("target, name and topic are required"))
+		return
+	}
+	var payload interface{}
+	if len(ctx.Args) > 3 {
+		if err := json.Unmarshal([]byte(ctx.Args[3]), &payload); err != nil {
+			// not JSON, send as is
+			payload = ctx.Args[3]
+		}
+	}
+	name, topic := ctx.Args[1], ctx.Args[2]
+	switch ctx.Args[0] {
+	case "user":
+		ctx.Resolve(pb.treeRoot.Push.ToUser(name, topic, payload))
+	case "group":
+		ctx.Resolve(pb.treeRoot.Push.ToGroup(name, topic, payload))
+	case "all":
+		ctx.Resolve(pb.treeRoot.Push.Broadcast(topic, payload))
+	default:
+		ctx.Reject(model.RetcodeBadRequest, errors.New("unknown target "+ctx.Args[0]))
+	}
+}
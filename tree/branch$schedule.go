@@ -0,0 +1,162 @@
+package tree
+
+import (
+	"errors"
+	"strings"
+
+	model "github.com/iapyeh/fastjob/model"
+)
+
+// ScheduleBranch manages cron entries of the tree (TreeRoot.Cron).
+// Entries are run as the user who added them, a user can only manage their own entries.
+type ScheduleBranch struct {
+	BaseBranch
+	treeRoot *TreeRoot
+}
+
+func (sb *ScheduleBranch) BeReady(treeroot *TreeRoot) {
+	sb.treeRoot = treeroot
+	sb.SetName("$schedule")
+	sb.InitBaseBranch()
+	// entries are owned by username, guests are not allowed
+	sb.SetACL(model.ProtectMode)
+	sb.Export(
+		sb.List,
+		sb.Add,
+		sb.Pause,
+		sb.Resume,
+		sb.Delete,
+		sb.RunNow,
+		sb.History,
+	)
+	treeroot.SureReady(sb)
+}
+
+func usernameOf(ctx *TreeCallCtx) string {
+	if user := ctx.WsCtx.GetUser(); user != nil {
+		return user.Username()
+	}
+	return ""
+}
+
+// entryOf returns the entry of Args[0] if it is owned by caller, otherwise ctx is rejected
+func (sb *ScheduleBranch) entryOf(ctx *TreeCallCtx) *model.CronEntry {
+	if len(ctx.Args) < 1 {
+		ctx.Reject(model.RetcodeBadRequest, errors.New("id is missing"))
+		return nil
+	}
+	entry := sb.treeRoot.Cron.Get(ctx.Args[0])
+	if entry == nil || entry.Username != usernameOf(ctx) {
+		ctx.Reject(model.RetcodeNotFound, errors.New(ctx.Args[0]+" not found"))
+		return nil
+	}
+	return entry
+}
+
+/*
+# $schedule.List
+Returns entries of caller with their next run time
+*/
+func (sb *ScheduleBranch) List(ctx *TreeCallCtx) {
+	ctx.Resolve(sb.treeRoot.Cron.List(usernameOf(ctx)))
+}
+
+/*
+# $schedule.Add
+Adds an entry which calls an API on schedule as the caller.
+
+    Args:[
+        spec*: 0 6 * * 1-5, (* = required)
+        path*: reports.Daily,
+        arg0: ...,
+    ]
+    Kw: passed to the API
+@spec: cron expression of minute, hour, day-of-month, month and day-of-week, or @daily, @hourly ...
+@path: path of the API, the tree name could be omitted
+*/
+func (sb *ScheduleBranch) Add(ctx *TreeCallCtx) {
+	if len(ctx.Args) < 2 {
+		ctx.Reject(model.RetcodeBadRequest, errors.New("spec and path are required"))
+		return
+	}
+	path := ctx.Args[1]
+	if !strings.HasPrefix(path, sb.treeRoot.Name+".") {
+		path = sb.treeRoot.Name + "." + path
+	}
+	kw := make(map[string]string)
+	ctx.Kw.VisitAll(func(key, value []byte) {
+		kw[string(key)] = string(value)
+	})
+	entry, err := sb.treeRoot.Cron.Add(&model.CronEntry{
+		Spec:     ctx.Args[0],
+		Path:     path,
+		Args:     ctx.Args[2:],
+		Kw:       kw,
+		Username: usernameOf(ctx),
+	})
+	if err != nil {
+		ctx.Reject(model.RetcodeBadRequest, err)
+		return
+	}
+	ctx.Resolve(sb.treeRoot.Cron.Get(entry.ID))
+}
+
+/*
+# $schedule.Pause
+    Args:[id*]
+*/
+func (sb *ScheduleBranch) Pause(ctx *TreeCallCtx) {
+	if entry := sb.entryOf(ctx); entry != nil {
+		sb.treeRoot.Cron.SetPaused(entry.ID, true)
+		ctx.Resolve(1)
+	}
+}
+
+/*
+# $schedule.Resume
+    Args:[id*]
+*/
+func (sb *ScheduleBranch) Resume(ctx *TreeCallCtx) {
+	if entry := sb.entryOf(ctx); entry != nil {
+		sb.treeRoot.Cron.SetPaused(entry.ID, false)
+		ctx.Resolve(1)
+	}
+}
+
+/*
+# $schedule.Delete
+    Args:[id*]
+*/
+func (sb *ScheduleBranch) Delete(ctx *TreeCallCtx) {
+	if entry := sb.entryOf(ctx); entry != nil {
+		sb.treeRoot.Cron.Delete(entry.ID)
+		ctx.Resolve(1)
+	}
+}
+
+/*
+# $schedule.RunNow
+Runs an entry immediately, returns CmdID of the call, which could be hooked by $.Hook
+    Args:[id*]
+*/
+func (sb *ScheduleBranch) RunNow(ctx *TreeCallCtx) {
+	if entry := sb.entryOf(ctx); entry != nil {
+		cmdID, err := sb.treeRoot.Cron.RunNow(entry.ID)
+		if err != nil {
+			ctx.Reject(model.RetcodeBadRequest, err)
+			return
+		}
+		ctx.Resolve(cmdID)
+	}
+}
+
+/*
+# $schedule.History
+Returns latest runs of an entry, latest first
+    Args:[id*]
+*/
+func (sb *ScheduleBranch) History(ctx *TreeCallCtx) {
+	if entry := sb.entryOf(ctx); entry != nil {
+		ctx.Resolve(sb.treeRoot.Cron.History(entry.ID))
+	}
+}
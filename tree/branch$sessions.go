@@ -0,0 +1,81 @@
+package tree
+
+import (
+	"errors"
+
+	model "github.com/iapyeh/fastjob/model"
+)
+
+// SessionsBranch lets administrators list and disconnect live websockets (model.Sessions).
+// See model.AdminChecker.
+type SessionsBranch struct {
+	BaseBranch
+}
+
+func (sb *SessionsBranch) BeReady(treeroot *TreeRoot) {
+	sb.SetName("$sessions")
+	sb.InitBaseBranch()
+	sb.Use(model.RequireAdmin)
+	sb.Export(
+		sb.List,
+		sb.ListUser,
+		sb.Disconnect,
+	)
+	treeroot.SureReady(sb)
+}
+
+/*
+# $sessions.List
+Returns live websockets, oldest first
+    Args:[
+        route: url path of websocket route, ex. /objsh/tree; all routes if omitted
+    ]
+*/
+func (sb *SessionsBranch) List(ctx *TreeCallCtx) {
+	route := ""
+	if len(ctx.Args) > 0 {
+		route = ctx.Args[0]
+	}
+	ctx.Resolve(model.Sessions.List(route))
+}
+
+/*
+# $sessions.ListUser
+Returns live websockets of a user
+    Args:[username*]
+*/
+func (sb *SessionsBranch) ListUser(ctx *TreeCallCtx) {
+	if len(ctx.Args) < 1 {
+		ctx.Reject(model.RetcodeBadRequest, errors.New("username is missing"))
+		return
+	}
+	ctx.Resolve(model.Sessions.ListUser(ctx.Args[0]))
+}
+
+/*
+# $sessions.Disconnect
+Closes a websocket, its foreground calls are killed.
+If the websocket is of a resumable session (?resume=), the session expires too and can not be resumed.
+    Args:[id*]
+    Returns: {"id": id, "sessionExpired": true if a resumable session expired}
+*/
+func (sb *SessionsBranch) Disconnect(ctx *TreeCallCtx) {
+	if len(ctx.Args) < 1 {
+		ctx.Reject(model.RetcodeBadRequest, errors.New("id is missing"))
+		return
+	}
+	ws, _ := ctx.WsCtx.(*model.WebsocketCtx)
+	if session, ok := ctx.WsCtx.(*model.ResumableSession); ok {
+		ws = session.Websocket()
+	}
+	if ws != nil && ws.ID == ctx.Args[0] {
+		ctx.Reject(model.RetcodeBadRequest, errors.New("can not disconnect the caller itself"))
+		return
+	}
+	expired, err := model.Sessions.Disconnect(ctx.Args[0])
+	if err != nil {
+		ctx.Reject(model.RetcodeNotFound, err)
+		return
+	}
+	ctx.Resolve(map[string]interface{}{"id": ctx.Args[0], "sessionExpired": expired})
+}
@@ -0,0 +1,62 @@
+package tree
+
+import (
+	"errors"
+
+	model "github.com/iapyeh/fastjob/model"
+)
+
+// WorkflowBranch runs workflows (DAGs of tree calls) as background tasks, see model.Workflow
+type WorkflowBranch struct {
+	BaseBranch
+}
+
+func (wb *WorkflowBranch) BeReady(treeroot *TreeRoot) {
+	wb.SetName("$workflow")
+	wb.InitBaseBranch()
+	wb.Export(
+		wb.Run,
+		wb.Validate,
+	)
+	treeroot.SureReady(wb)
+}
+
+func workflowOf(ctx *TreeCallCtx) (*model.Workflow, error) {
+	if len(ctx.Args) < 1 {
+		return nil, errors.New("workflow is missing")
+	}
+	return model.ParseWorkflow([]byte(ctx.Args[0]))
+}
+
+/*
+# $workflow.Run
+Runs a workflow in background. Steps are called as the caller, their states are notified.
+Resolves outputs of all steps, or rejects when a step is failed.
+
+    Args:[
+        workflow*: {"steps":[{"name":"a","path":"x.A"},{"name":"b","path":"x.B","args":["{{a.output}}"],"dependsOn":["a"]}]},
+    ]
+*/
+func (wb *WorkflowBranch) Run(ctx *TreeCallCtx) {
+	wf, err := workflowOf(ctx)
+	if err != nil {
+		ctx.Reject(model.RetcodeBadRequest, err)
+		return
+	}
+	if err := ctx.RunWorkflow(wf); err != nil {
+		ctx.Reject(model.RetcodeBadRequest, err)
+	}
+}
+
+/*
+# $workflow.Validate
+Checks a workflow without running it
+    Args:[workflow*]
+*/
+func (wb *WorkflowBranch) Validate(ctx *TreeCallCtx) {
+	if _, err := workflowOf(ctx); err != nil {
+		ctx.Reject(model.RetcodeBadRequest, err)
+		return
+	}
+	ctx.Resolve(1)
+}
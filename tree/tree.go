@@ -6,6 +6,7 @@ import (
 	"log"
 	"strings"
     "strconv"
+	"time"
 	"github.com/golang/protobuf/proto"
 	"github.com/golang/protobuf/ptypes"
 	model "github.com/iapyeh/fastjob/model"
@@ -14,6 +15,7 @@ import (
 type User = model.User
 type Command = model.Command
 type Result = model.Result
+type Chunk = model.Chunk
 type TreeCallCtx = model.TreeCallCtx
 type TreeCallCtxBank = model.TreeCallCtxBank
 type Branch = model.Branch
@@ -61,6 +63,23 @@ func (self *TreeCallHandler) Handler(wsCtx *model.WebsocketCtx) {
 		who = wsCtx.User.Username()
 	}
 	log.Println("Tree connected by ", who)
+	// JSON text protocol is negotiated by subprotocol objsh.json or ?protocol=json
+	useJSON := wsCtx.Subprotocol == model.ProtocolJSON || (wsCtx.Args != nil && string(wsCtx.Args.Peek("protocol")) == "json")
+	if useJSON {
+		wsCtx.SetJSON(true)
+	}
+	// calls are bound to a resumable session if browser asks for it by ?resume=[session id],
+	// see TreeRoot.Resumer
+	var listener model.PromiseStateListener = wsCtx
+	if self.Root.Resumer.Grace > 0 && wsCtx.Args != nil && wsCtx.Args.Has("resume") {
+		session, resumed := self.Root.Resumer.Attach(wsCtx, string(wsCtx.Args.Peek("resume")))
+		if resumed {
+			log.Println("Tree session resumed by ", who)
+		}
+		listener = session
+	}
+	// for announcements, see TreeRoot.Push
+	self.Root.Push.Register(listener)
 	/*
 		layout := wsCtx.Args.Peek("layout")
 		if len(layout) > 0 {
@@ -90,6 +109,39 @@ func (self *TreeCallHandler) Handler(wsCtx *model.WebsocketCtx) {
 		    })
 	*/
 
+	if useJSON {
+		wsCtx.On("Message", "_", func(text string) {
+			// a piece of binary stream of a call, see TreeCallCtx.Upload()
+			chunk, err := model.UnmarshalJSONChunk([]byte(text))
+			if chunk != nil {
+				self.Root.ReceiveChunk(listener, chunk)
+				return
+			}
+			if err != nil {
+				listener.SendTreeCallReturn(&model.TreeCallReturn{
+					Retcode: model.RetcodeBadRequest,
+					Stderr:  err,
+				})
+				return
+			}
+			obj, err := model.UnmarshalJSONCommand([]byte(text))
+			if err != nil {
+				var id int32
+				if obj != nil {
+					id = obj.Id
+				}
+				listener.SendTreeCallReturn(&model.TreeCallReturn{
+					CmdID:   id,
+					Retcode: model.RetcodeBadRequest,
+					Stderr:  err,
+				})
+				return
+			}
+			self.handleCommand(listener, obj)
+		})
+		return
+	}
+
 	wsCtx.On("Protobuf", "_", func(message proto.Message, err error) {
 
 		if err != nil {
@@ -99,52 +151,79 @@ func (self *TreeCallHandler) Handler(wsCtx *model.WebsocketCtx) {
 		//var callCtx *model.TreeCallCtx
 		switch typeName := proto.MessageName(message); typeName {
 		case "objsh.Command":
-			obj := message.(*Command)
-
-			
-			//decode obj.Message (Any Message)
-			if obj.Kill {
-                callCtx := model.NewSimpleTreeCallCtx(self.Root, obj.Id, wsCtx)
-                if idToKill, err := strconv.ParseInt(obj.Name,10,64); err == nil{
-                    if err := callCtx.KillPeer(int32(idToKill)); err == nil{
-                        callCtx.Resolve("job killing completed")
-                    }else{
-                        callCtx.Reject(500, err)
-                    }    
-                }else{
-                    callCtx.Reject(400, err)
-                }
-            } else if !strings.HasPrefix(obj.Name, self.Root.Name) {
-                log.Println("Accept ", self.Root.Name+".* only, not ", obj.Name)
-                wsCtx.SendTreeCallReturn(&model.TreeCallReturn{
-                    CmdID:   obj.Id,
-                    Retcode: -404,
-                    Stderr:  errors.New(obj.Name + " not found"),
-                })
-                return
-            } else {
-				// create TreeCallCtx
-				var pbMsg proto.Message
-				if obj.Message != nil {
-					if objInAny, err2 := ptypes.Empty(obj.Message); err2 == nil {
-						if err = proto.Unmarshal(obj.Message.Value, objInAny); err == nil {
-							pbMsg = objInAny
-						} else {
-							log.Println(err)
-						}
-					} else {
-						fmt.Println(err2)
-					}
-				}
-                callCtx := model.NewTreeCallCtx(self.Root, obj.Id, wsCtx, obj.Args, &obj.Kw, &pbMsg)
-                
-                // 2019-11-21T11:00:02+00:00
-                // if not been put to subroute , ex "self.Root.Call(obj.Name, callCtx)"
-                // a blocking call will blocks all
-				go self.Root.Call(obj.Name, callCtx)
-			}
+			self.handleCommand(listener, message.(*Command))
+		case "objsh.Chunk":
+			// a piece of binary stream of a call, see TreeCallCtx.Upload()
+			self.Root.ReceiveChunk(listener, message.(*Chunk))
 			//default:
 			//	//go self.Root.Call(typeName, callCtx)
 		}
 	})
 }
+
+// handleCommand runs a Command of protobuf or JSON protocol
+func (self *TreeCallHandler) handleCommand(listener model.PromiseStateListener, obj *Command) {
+	if obj.Kill {
+		callCtx := model.NewSimpleTreeCallCtx(self.Root, obj.Id, listener)
+		if idToKill, err := strconv.ParseInt(obj.Name, 10, 64); err == nil {
+			if err := callCtx.KillPeer(int32(idToKill)); err == nil {
+				callCtx.Resolve("job killing completed")
+			} else {
+				callCtx.Reject(500, err)
+			}
+		} else {
+			callCtx.Reject(400, err)
+		}
+	} else if obj.Pause || obj.Resume {
+		// like kill, obj.Name is the id of a pausable call
+		callCtx := model.NewSimpleTreeCallCtx(self.Root, obj.Id, listener)
+		idToPause, err := strconv.ParseInt(obj.Name, 10, 64)
+		if err != nil {
+			callCtx.Reject(model.RetcodeBadRequest, err)
+		} else if obj.Pause {
+			if err := callCtx.PausePeer(int32(idToPause)); err == nil {
+				callCtx.Resolve("job paused")
+			} else {
+				callCtx.Reject(model.RetcodeNotFound, err)
+			}
+		} else {
+			if err := callCtx.ResumePeer(int32(idToPause)); err == nil {
+				callCtx.Resolve("job resumed")
+			} else {
+				callCtx.Reject(model.RetcodeNotFound, err)
+			}
+		}
+	} else if !strings.HasPrefix(obj.Name, self.Root.Name) {
+		log.Println("Accept ", self.Root.Name+".* only, not ", obj.Name)
+		listener.SendTreeCallReturn(&model.TreeCallReturn{
+			CmdID:   obj.Id,
+			Retcode: -404,
+			Stderr:  errors.New(obj.Name + " not found"),
+		})
+	} else {
+		// create TreeCallCtx
+		//decode obj.Message (Any Message)
+		var pbMsg proto.Message
+		if obj.Message != nil {
+			if objInAny, err2 := ptypes.Empty(obj.Message); err2 == nil {
+				if err := proto.Unmarshal(obj.Message.Value, objInAny); err == nil {
+					pbMsg = objInAny
+				} else {
+					log.Println(err)
+				}
+			} else {
+				fmt.Println(err2)
+			}
+		}
+		callCtx := model.NewTreeCallCtx(self.Root, obj.Id, listener, obj.Args, &obj.Kw, &pbMsg)
+		if obj.Timeout > 0 {
+			// client-side deadline in milliseconds
+			callCtx.SetTimeout(time.Duration(obj.Timeout) * time.Millisecond)
+		}
+
+		// 2019-11-21T11:00:02+00:00
+		// if not been put to subroute , ex "self.Root.Call(obj.Name, callCtx)"
+		// a blocking call will blocks all
+		go self.Root.Call(obj.Name, callCtx)
+	}
+}